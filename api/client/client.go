@@ -2,37 +2,151 @@ package client
 
 import (
     "encoding/json"
+    "errors"
+    "fmt"
     "image/png"
+    "io"
     "log"
+    "mime"
     "net/http"
+    "os"
 
     "github.com/gorilla/mux"
 
     "dicom/api/model"
-    "dicom/api/service/processor"
-    "dicom/api/service/parser"
+    "dicom/api/service/dicomweb"
     "dicom/api/service/fetcher"
+    "dicom/api/service/importer"
+    "dicom/api/service/ingester"
+    "dicom/api/service/jobs"
+    "dicom/api/service/parser"
+    "dicom/api/service/uploader"
 )
 
 type Handler struct {
-    dicomParser    *parser.DicomParser
-    dicomProcessor *processor.DicomProcessor
-    dicomFetcher   *fetcher.DicomFetcher
-    logger         *log.Logger
+    dicomParser   *parser.DicomParser
+    dicomIngester *ingester.DicomIngester
+    dicomImporter *importer.DicomImporter
+    dicomFetcher  *fetcher.DicomFetcher
+    dicomUploader *uploader.DicomUploader
+    dicomWeb      *dicomweb.DicomWeb
+    jobQueue      *jobs.JobQueue
+    logger        *log.Logger
 }
 
-func NewHandler(dicomParser *parser.DicomParser, dicomProcessor *processor.DicomProcessor, dicomFetcher *fetcher.DicomFetcher, logger *log.Logger) *Handler {
+func NewHandler(dicomParser *parser.DicomParser, dicomIngester *ingester.DicomIngester, dicomImporter *importer.DicomImporter, dicomFetcher *fetcher.DicomFetcher, dicomUploader *uploader.DicomUploader, dicomWeb *dicomweb.DicomWeb, jobQueue *jobs.JobQueue, logger *log.Logger) *Handler {
     return &Handler{
-        dicomParser:    dicomParser,
-        dicomProcessor: dicomProcessor,
-        dicomFetcher:   dicomFetcher,
-        logger:         logger,
+        dicomParser:   dicomParser,
+        dicomIngester: dicomIngester,
+        dicomImporter: dicomImporter,
+        dicomFetcher:  dicomFetcher,
+        dicomUploader: dicomUploader,
+        dicomWeb:      dicomWeb,
+        jobQueue:      jobQueue,
+        logger:        logger,
     }
 }
 
-// HandleDicomUpload handles the upload of a DICOM file
+// HandleDicomUpload queues the ingest of a DICOM file and responds right away with the job id. The file
+// is either referenced by a path on the server's filesystem in a JSON body, or its bytes are sent as
+// multipart/form-data or application/dicom and kept in a temporary file until the job runs
 func (h *Handler) HandleDicomUpload(w http.ResponseWriter, r *http.Request) {
-    // Parse the request body
+    mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+    switch mediaType {
+    case "multipart/form-data":
+        h.handleMultipartUpload(w, r)
+    case "application/dicom":
+        h.handleStreamUpload(w, r)
+    default:
+        h.HandleCreateJob(w, r)
+    }
+}
+
+// handleMultipartUpload queues the DICOM file sent in the "file" field of a multipart/form-data body
+func (h *Handler) handleMultipartUpload(w http.ResponseWriter, r *http.Request) {
+    reader, err := r.MultipartReader()
+    if err != nil {
+        http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+        return
+    }
+
+    for {
+        part, err := reader.NextPart()
+        if err == io.EOF {
+            http.Error(w, "File field is missing", http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+            return
+        }
+
+        if part.FormName() != "file" {
+            part.Close()
+            continue
+        }
+
+        spooled, err := h.spoolFile(part)
+        part.Close()
+        if err != nil {
+            http.Error(w, "Error receiving DICOM file", http.StatusBadRequest)
+            return
+        }
+
+        h.queueSpooledFile(w, spooled, part.FileName())
+        return
+    }
+}
+
+// handleStreamUpload queues the DICOM file sent as the raw application/dicom body
+func (h *Handler) handleStreamUpload(w http.ResponseWriter, r *http.Request) {
+    spooled, err := h.spoolFile(r.Body)
+    if err != nil {
+        http.Error(w, "Error receiving DICOM file", http.StatusBadRequest)
+        return
+    }
+
+    h.queueSpooledFile(w, spooled, "request body")
+}
+
+// spoolFile copies an uploaded file to a temporary file, so the request can be answered before the
+// file is ingested
+func (h *Handler) spoolFile(file io.Reader) (string, error) {
+    spool, err := os.CreateTemp("", "dicom-upload-*.dcm")
+    if err != nil {
+        h.logger.Printf("Error creating temporary file: %v", err)
+        return "", err
+    }
+    if _, err := io.Copy(spool, file); err != nil {
+        h.logger.Printf("Error receiving DICOM file: %v", err)
+        spool.Close()
+        os.Remove(spool.Name())
+        return "", err
+    }
+    if err := spool.Close(); err != nil {
+        h.logger.Printf("Error writing temporary file: %v", err)
+        os.Remove(spool.Name())
+        return "", err
+    }
+    return spool.Name(), nil
+}
+
+// queueSpooledFile queues the ingest of a spooled file, which is removed once the job is done or dropped
+func (h *Handler) queueSpooledFile(w http.ResponseWriter, spooled string, source string) {
+    remove := func() { os.Remove(spooled) }
+    queued := h.queueJob(w, source, func(progress func(float64)) (string, error) {
+        defer remove()
+        return h.dicomIngester.IngestPath(spooled, progress)
+    }, remove)
+    if !queued {
+        os.Remove(spooled)
+    }
+}
+
+// HandleDicomDirUpload queues the ingest of every file referenced by a DICOMDIR, one job per file, and
+// reports the job of each file
+func (h *Handler) HandleDicomDirUpload(w http.ResponseWriter, r *http.Request) {
     var requestBody struct {
         FilePath string `json:"path"`
     }
@@ -48,33 +162,78 @@ func (h *Handler) HandleDicomUpload(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    // dicomparse
-    dataset, uuid, err := h.dicomParser.GetDicomDatasetByPath(requestBody.FilePath)
+    records, err := h.dicomParser.GetDicomDirRecords(requestBody.FilePath)
     if err != nil {
-        http.Error(w, "Error handling DICOM file", http.StatusInternalServerError)
+        http.Error(w, "Error reading DICOMDIR", http.StatusBadRequest)
         return
     }
 
-    // Convert the DICOM file to PNG
-    err = h.dicomProcessor.ExtractDicomImage(uuid, dataset)
-    if err != nil {
-        http.Error(w, "Error extracting DICOM image", http.StatusInternalServerError)
+    files := make([]model.QueuedFile, 0, len(records))
+    failed := 0
+    for _, record := range records {
+        file := model.QueuedFile{Path: record.Path}
+
+        path := record.Path
+        job, err := h.jobQueue.Enqueue(path, func(progress func(float64)) (string, error) {
+            return h.dicomIngester.IngestPath(path, progress)
+        }, nil)
+        if err != nil {
+            file.Error = err.Error()
+            failed++
+        } else {
+            file.JobID = job.ID
+        }
+        files = append(files, file)
+    }
+
+    response := struct {
+        Queued int                `json:"queued"`
+        Failed int                `json:"failed"`
+        Jobs   []model.QueuedFile `json:"jobs"`
+    }{
+        Queued: len(files) - failed,
+        Failed: failed,
+        Jobs:   files,
+    }
+
+    // Nothing was accepted when every file was turned away by the queue
+    status := http.StatusAccepted
+    if failed > 0 && failed == len(files) {
+        status = http.StatusServiceUnavailable
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(response)
+
+    h.logger.Printf("Queued %d of %d files referenced by DICOMDIR at: %s", response.Queued, len(files), requestBody.FilePath)
+}
+
+// HandleDirectoryImport ingests every DICOM file found below a directory and summarizes the outcome
+func (h *Handler) HandleDirectoryImport(w http.ResponseWriter, r *http.Request) {
+    var requestBody struct {
+        Path string `json:"path"`
+    }
+
+    decoder := json.NewDecoder(r.Body)
+    if err := decoder.Decode(&requestBody); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    if requestBody.Path == "" {
+        http.Error(w, "Directory path is empty", http.StatusBadRequest)
         return
     }
 
-    // Extract tags from the dicom file
-    err = h.dicomProcessor.ExtractDicomHeaders(uuid, dataset)
+    result, err := h.dicomImporter.ImportDirectory(requestBody.Path)
     if err != nil {
-        http.Error(w, "Error extracting DICOM tags", http.StatusInternalServerError)
+        http.Error(w, "Error reading directory", http.StatusBadRequest)
         return
     }
 
-    // Respond with success message
-    response := map[string]string{"id": uuid}
     w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(response)
-
-    h.logger.Printf("Successfully uploaded dicom file at: %s", requestBody.FilePath)
+    json.NewEncoder(w).Encode(result)
 }
 
 func (h *Handler) HandleGetImage(w http.ResponseWriter, r *http.Request) {
@@ -86,6 +245,10 @@ func (h *Handler) HandleGetImage(w http.ResponseWriter, r *http.Request) {
 
     // Get the DICOM image using the UUID
     img, err := h.dicomFetcher.GetImage(uuid)
+    if errors.Is(err, fetcher.ErrNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
     if err != nil {
         http.Error(w, "Failed to fetch DICOM image", http.StatusInternalServerError)
         return
@@ -111,6 +274,22 @@ func (h *Handler) HandleGetTags(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // Sequences can be returned with their items nested instead of as a flat list
+    if r.URL.Query().Get("format") == "tree" {
+        tree, err := h.dicomFetcher.GetTagTree(uuid)
+        if err != nil {
+            http.Error(w, "Failed to fetch DICOM tags", http.StatusInternalServerError)
+            return
+        }
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(struct {
+            UUID string          `json:"uuid"`
+            Tags []model.TagNode `json:"tags"`
+        }{UUID: uuid, Tags: tree})
+        return
+    }
+
     // Get tags associated with the UUID
     tags, err := h.dicomFetcher.GetTags(uuid)
     if err != nil {
@@ -118,6 +297,15 @@ func (h *Handler) HandleGetTags(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // The DICOM JSON model of PS3.18 annex F is returned when asked for by name or media type
+    if r.URL.Query().Get("format") == "json" || acceptsDicomJSON(r) {
+        w.Header().Set("Content-Type", dicomJSONMediaType)
+        if err := json.NewEncoder(w).Encode(dicomweb.TagsJSON(tags, baseURL(r))); err != nil {
+            http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+        }
+        return
+    }
+
     // Prepare response JSON
     response := struct {
         UUID string       `json:"uuid"`
@@ -138,6 +326,82 @@ func (h *Handler) HandleGetTags(w http.ResponseWriter, r *http.Request) {
 }
 
 
+// HandleGetFile streams the original DICOM file of a processed dicom file
+func (h *Handler) HandleGetFile(w http.ResponseWriter, r *http.Request) {
+    uuid := mux.Vars(r)["id"]
+    file, err := h.dicomFetcher.GetFile(uuid)
+    if errors.Is(err, fetcher.ErrNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Failed to fetch DICOM file", http.StatusInternalServerError)
+        return
+    }
+    defer file.Close()
+
+    w.Header().Set("Content-Type", "application/dicom")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", uuid+".dcm"))
+    if _, err := io.Copy(w, file); err != nil {
+        h.logger.Printf("Error sending DICOM file: %v", err)
+    }
+}
+
+// HandleGetBulkData streams a binary value of a processed dicom file that /tags links to with its
+// BulkDataURI
+func (h *Handler) HandleGetBulkData(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+    file, err := h.dicomFetcher.GetBulkData(vars["id"], vars["key"])
+    if errors.Is(err, fetcher.ErrNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Failed to fetch bulk data", http.StatusInternalServerError)
+        return
+    }
+    defer file.Close()
+
+    w.Header().Set("Content-Type", "application/octet-stream")
+    if _, err := io.Copy(w, file); err != nil {
+        h.logger.Printf("Error sending bulk data: %v", err)
+    }
+}
+
+// HandleSearchTags returns the DICOM files whose top level tags match the predicates of the query, see
+// fetcher.ParseTagQuery
+func (h *Handler) HandleSearchTags(w http.ResponseWriter, r *http.Request) {
+    query, err := fetcher.ParseTagQuery(r.URL.Query())
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    matches, err := h.dicomFetcher.SearchTags(query)
+    if err != nil {
+        http.Error(w, "Failed to search DICOM tags", http.StatusInternalServerError)
+        return
+    }
+    if matches == nil {
+        matches = []model.SearchMatch{}
+    }
+
+    response := struct {
+        Matches []model.SearchMatch `json:"matches"`
+        Limit   int                 `json:"limit"`
+        Offset  int                 `json:"offset"`
+    }{
+        Matches: matches,
+        Limit:   query.Limit,
+        Offset:  query.Offset,
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(response); err != nil {
+        h.logger.Printf("Error encoding search response: %v", err)
+    }
+}
+
 func HealthCheck(w http.ResponseWriter, r *http.Request) {
     w.WriteHeader(http.StatusOK)
     w.Write([]byte("OK"))
@@ -148,11 +412,48 @@ func Heartbeat(w http.ResponseWriter, r *http.Request) {
     w.Write([]byte("Alive"))
 }
 
-func Setup(router *mux.Router, dicomParser *parser.DicomParser, dicomProcessor *processor.DicomProcessor, dicomFetcher *fetcher.DicomFetcher, logger *log.Logger) {
-    handler := NewHandler(dicomParser, dicomProcessor, dicomFetcher, logger)
+func Setup(router *mux.Router, dicomParser *parser.DicomParser, dicomIngester *ingester.DicomIngester, dicomImporter *importer.DicomImporter, dicomFetcher *fetcher.DicomFetcher, dicomUploader *uploader.DicomUploader, dicomWeb *dicomweb.DicomWeb, jobQueue *jobs.JobQueue, logger *log.Logger) {
+    handler := NewHandler(dicomParser, dicomIngester, dicomImporter, dicomFetcher, dicomUploader, dicomWeb, jobQueue, logger)
 
     router.HandleFunc("/dicom", handler.HandleDicomUpload).Methods("POST")
+    router.HandleFunc("/dicomdir", handler.HandleDicomDirUpload).Methods("POST")
+    router.HandleFunc("/import", handler.HandleDirectoryImport).Methods("POST")
+    router.HandleFunc("/jobs", handler.HandleCreateJob).Methods("POST")
+    router.HandleFunc("/jobs/{id}", handler.HandleGetJob).Methods("GET")
+    router.HandleFunc("/uploads", handler.HandleCreateUpload).Methods("POST")
+    router.HandleFunc("/uploads/{id}", handler.HandleGetUpload).Methods("GET")
+    router.HandleFunc("/uploads/{id}", handler.HandleDeleteUpload).Methods("DELETE")
+    router.HandleFunc("/uploads/{id}/chunks/{index:[0-9]+}", handler.HandleUploadChunk).Methods("PUT")
+    router.HandleFunc("/uploads/{id}/complete", handler.HandleCompleteUpload).Methods("POST")
+    router.HandleFunc("/studies", handler.HandleStoreInstances).Methods("POST")
+    router.HandleFunc("/studies/{study}", handler.HandleStoreInstances).Methods("POST")
+    router.HandleFunc("/studies", handler.HandleSearch(dicomweb.StudyLevel)).Methods("GET")
+    router.HandleFunc("/series", handler.HandleSearch(dicomweb.SeriesLevel)).Methods("GET")
+    router.HandleFunc("/studies/{study}/series", handler.HandleSearch(dicomweb.SeriesLevel)).Methods("GET")
+    router.HandleFunc("/instances", handler.HandleSearch(dicomweb.InstanceLevel)).Methods("GET")
+    router.HandleFunc("/studies/{study}/instances", handler.HandleSearch(dicomweb.InstanceLevel)).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances", handler.HandleSearch(dicomweb.InstanceLevel)).Methods("GET")
+    router.HandleFunc("/studies/{study}", handler.HandleRetrieveInstances).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}", handler.HandleRetrieveInstances).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}", handler.HandleRetrieveInstances).Methods("GET")
+    router.HandleFunc("/studies/{study}/metadata", handler.HandleRetrieveMetadata).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/metadata", handler.HandleRetrieveMetadata).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/metadata", handler.HandleRetrieveMetadata).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/frames/{frames}", handler.HandleRetrieveFrames).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/bulkdata/{tag}", handler.HandleRetrieveBulkData).Methods("GET")
+    router.HandleFunc("/studies/{study}/rendered", handler.HandleRendered).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/rendered", handler.HandleRendered).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/rendered", handler.HandleRendered).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/frames/{frames}/rendered", handler.HandleRendered).Methods("GET")
+    router.HandleFunc("/studies/{study}/thumbnail", handler.HandleThumbnail).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/thumbnail", handler.HandleThumbnail).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/thumbnail", handler.HandleThumbnail).Methods("GET")
+    router.HandleFunc("/studies/{study}/series/{series}/instances/{instance}/frames/{frames}/thumbnail", handler.HandleThumbnail).Methods("GET")
+    router.HandleFunc("/wado", handler.HandleWadoURI).Methods("GET")
     router.HandleFunc("/tags", handler.HandleGetTags).Methods("GET")
+    router.HandleFunc("/search", handler.HandleSearchTags).Methods("GET")
+    router.HandleFunc("/dicom/{id}/file", handler.HandleGetFile).Methods("GET")
+    router.HandleFunc("/dicom/{id}/bulkdata/{key}", handler.HandleGetBulkData).Methods("GET")
     router.HandleFunc("/image", handler.HandleGetImage).Methods("GET")
     router.HandleFunc("/health", HealthCheck).Methods("GET")
     router.HandleFunc("/heartbeat", Heartbeat).Methods("GET")
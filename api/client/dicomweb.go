@@ -0,0 +1,597 @@
+package client
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "image"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+    "strconv"
+    "strings"
+
+    "github.com/gorilla/mux"
+
+    "dicom/api/model"
+    "dicom/api/service/dicomweb"
+    "dicom/api/service/renderer"
+)
+
+// dicomJSONMediaType is the media type of DICOM JSON responses
+const dicomJSONMediaType = "application/dicom+json"
+
+// HandleStoreInstances stores the instances of a STOW-RS multipart/related request, optionally
+// restricted to the study in the path, and lists which instances were stored and which failed
+func (h *Handler) HandleStoreInstances(w http.ResponseWriter, r *http.Request) {
+    mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if err != nil || mediaType != "multipart/related" || params["boundary"] == "" {
+        http.Error(w, "Expected a multipart/related body", http.StatusUnsupportedMediaType)
+        return
+    }
+    if partType, _, err := mime.ParseMediaType(params["type"]); err != nil || partType != "application/dicom" {
+        http.Error(w, "Only application/dicom parts are supported", http.StatusUnsupportedMediaType)
+        return
+    }
+
+    studyInstanceUID := mux.Vars(r)["study"]
+    result, err := h.dicomWeb.StoreInstances(multipart.NewReader(r.Body, params["boundary"]), studyInstanceUID)
+    if errors.Is(err, dicomweb.ErrNoInstances) {
+        http.Error(w, "No instances in request", http.StatusBadRequest)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+        return
+    }
+
+    // Everything stored is a success, a partial store is accepted with warnings, nothing stored is a conflict
+    status := http.StatusOK
+    if len(result.Failed) > 0 {
+        status = http.StatusAccepted
+        if len(result.Referenced) == 0 {
+            status = http.StatusConflict
+        }
+    }
+
+    w.Header().Set("Content-Type", dicomJSONMediaType)
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(dicomweb.StoreResponse(result, studyInstanceUID, baseURL(r)))
+}
+
+// HandleSearch returns a handler running QIDO-RS searches at the level, below the study and series
+// in the path if any
+func (h *Handler) HandleSearch(level dicomweb.QueryLevel) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        vars := mux.Vars(r)
+        query := dicomweb.Query{
+            Level:             level,
+            StudyInstanceUID:  vars["study"],
+            SeriesInstanceUID: vars["series"],
+            Params:            r.URL.Query(),
+        }
+
+        result, err := h.dicomWeb.Search(query, baseURL(r))
+        if errors.Is(err, dicomweb.ErrInvalidQuery) {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        if err != nil {
+            http.Error(w, "Error searching", http.StatusInternalServerError)
+            return
+        }
+
+        for _, warning := range result.Warnings {
+            w.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+        }
+        w.Header().Set("Content-Type", dicomJSONMediaType)
+        json.NewEncoder(w).Encode(result.Matches)
+    }
+}
+
+// HandleRetrieveInstances returns the original files of the study, series or instance in the path as a
+// multipart/related response. A single instance can also be asked for as plain application/dicom
+func (h *Handler) HandleRetrieveInstances(w http.ResponseWriter, r *http.Request) {
+    instances, ok := h.retrieveInstances(w, r)
+    if !ok {
+        return
+    }
+
+    transferSyntaxes := make([]string, len(instances))
+    for i := range instances {
+        transferSyntax, err := h.dicomWeb.TransferSyntax(&instances[i])
+        if err != nil {
+            retrieveFailed(w, err)
+            return
+        }
+        transferSyntaxes[i] = transferSyntax
+    }
+
+    // Files are returned as they were received, they cannot be transcoded
+    single := false
+    acceptable := false
+    for _, accepted := range acceptedTypes(r) {
+        if accepted.MediaType == "application/dicom" && len(instances) == 1 {
+            acceptable, single = accepted.allows(transferSyntaxes...), true
+        } else if accepted.multipart("application/dicom") {
+            acceptable = accepted.allows(transferSyntaxes...)
+        }
+        if acceptable {
+            break
+        }
+    }
+    if !acceptable {
+        http.Error(w, "Instances are only available as application/dicom in their original transfer syntax", http.StatusNotAcceptable)
+        return
+    }
+
+    if single {
+        file, err := h.dicomWeb.OpenInstance(&instances[0])
+        if err != nil {
+            retrieveFailed(w, err)
+            return
+        }
+        defer file.Close()
+
+        w.Header().Set("Content-Type", "application/dicom; transfer-syntax="+transferSyntaxes[0])
+        if _, err := io.Copy(w, file); err != nil {
+            h.logger.Printf("Error sending DICOM file: %v", err)
+        }
+        return
+    }
+
+    writer := multipartRelated(w, "application/dicom")
+    defer writer.Close()
+    for i := range instances {
+        if err := h.writeInstancePart(writer, &instances[i], transferSyntaxes[i]); err != nil {
+            // The status is already sent, the truncated body tells the client something went wrong
+            h.logger.Printf("Error sending DICOM file: %v", err)
+            return
+        }
+    }
+}
+
+func (h *Handler) writeInstancePart(writer *multipart.Writer, instance *model.Dicom, transferSyntax string) error {
+    file, err := h.dicomWeb.OpenInstance(instance)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    part, err := writer.CreatePart(textproto.MIMEHeader{
+        "Content-Type": {"application/dicom; transfer-syntax=" + transferSyntax},
+    })
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy(part, file)
+    return err
+}
+
+// HandleRetrieveMetadata returns the DICOM JSON of the instances of the study, series or instance in
+// the path
+func (h *Handler) HandleRetrieveMetadata(w http.ResponseWriter, r *http.Request) {
+    instances, ok := h.retrieveInstances(w, r)
+    if !ok {
+        return
+    }
+
+    metadata, err := h.dicomWeb.Metadata(instances, baseURL(r))
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", dicomJSONMediaType)
+    json.NewEncoder(w).Encode(metadata)
+}
+
+// HandleRetrieveFrames returns the frames listed in the path in the transfer syntax of the stored file
+func (h *Handler) HandleRetrieveFrames(w http.ResponseWriter, r *http.Request) {
+    numbers, err := dicomweb.ParseFrameNumbers(mux.Vars(r)["frames"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    instances, ok := h.retrieveInstances(w, r)
+    if !ok {
+        return
+    }
+
+    frames, err := h.dicomWeb.Frames(&instances[0], numbers)
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+    h.writeBulkData(w, r, frames)
+}
+
+// HandleRetrieveBulkData returns the binary value of the attribute in the path, which metadata links to
+func (h *Handler) HandleRetrieveBulkData(w http.ResponseWriter, r *http.Request) {
+    t, err := dicomweb.ParseTag(mux.Vars(r)["tag"])
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    instances, ok := h.retrieveInstances(w, r)
+    if !ok {
+        return
+    }
+
+    bulkData, err := h.dicomWeb.BulkData(&instances[0], t)
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+    h.writeBulkData(w, r, bulkData)
+}
+
+func (h *Handler) writeBulkData(w http.ResponseWriter, r *http.Request, bulkData *dicomweb.BulkData) {
+    acceptable := false
+    for _, accepted := range acceptedTypes(r) {
+        if accepted.multipart(bulkData.MediaType) && accepted.allows(bulkData.TransferSyntaxUID) {
+            acceptable = true
+            break
+        }
+    }
+    if !acceptable {
+        http.Error(w, fmt.Sprintf("Only available as %s in transfer syntax %s", bulkData.MediaType, bulkData.TransferSyntaxUID), http.StatusNotAcceptable)
+        return
+    }
+
+    writer := multipartRelated(w, bulkData.MediaType)
+    defer writer.Close()
+    for _, data := range bulkData.Parts {
+        part, err := writer.CreatePart(textproto.MIMEHeader{
+            "Content-Type": {bulkData.MediaType + "; transfer-syntax=" + bulkData.TransferSyntaxUID},
+        })
+        if err != nil {
+            h.logger.Printf("Error sending bulk data: %v", err)
+            return
+        }
+        if _, err := part.Write(data); err != nil {
+            h.logger.Printf("Error sending bulk data: %v", err)
+            return
+        }
+    }
+}
+
+// renderedFrame is a frame, numbered from 1, of an instance to render
+type renderedFrame struct {
+    instance *model.Dicom
+    number   int
+}
+
+// HandleRendered returns the study, series, instance or frames in the path rendered for display. An
+// instance or a single frame comes as a plain image, studies, series and lists of frames as
+// multipart/related with the first frame of every instance or every frame listed
+func (h *Handler) HandleRendered(w http.ResponseWriter, r *http.Request) {
+    options, quality, err := renderOptions(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    numbers := []int{1}
+    if list := mux.Vars(r)["frames"]; list != "" {
+        if numbers, err = dicomweb.ParseFrameNumbers(list); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+
+    instances, ok := h.retrieveInstances(w, r)
+    if !ok {
+        return
+    }
+
+    var frames []renderedFrame
+    instanceLevel := mux.Vars(r)["instance"] != ""
+    if instanceLevel {
+        for _, number := range numbers {
+            frames = append(frames, renderedFrame{instance: &instances[0], number: number})
+        }
+    } else {
+        for i := range instances {
+            frames = append(frames, renderedFrame{instance: &instances[i], number: 1})
+        }
+    }
+
+    mediaType, multipartResponse, ok := renderedMediaType(r, instanceLevel && len(frames) == 1)
+    if !ok {
+        http.Error(w, "Rendered images are available as "+strings.Join(renderer.MediaTypes, ", "), http.StatusNotAcceptable)
+        return
+    }
+
+    var writer *multipart.Writer
+    for i, frame := range frames {
+        img, err := h.dicomWeb.Render(frame.instance, frame.number, options)
+        if err != nil && i == 0 {
+            retrieveFailed(w, err)
+            return
+        }
+        if err != nil {
+            // The status is already sent, the truncated body tells the client something went wrong
+            h.logger.Printf("Error rendering frame: %v", err)
+            return
+        }
+
+        if !multipartResponse {
+            h.writeImage(w, img, mediaType, quality)
+            return
+        }
+        if writer == nil {
+            writer = multipartRelated(w, mediaType)
+            defer writer.Close()
+        }
+        part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {mediaType}})
+        if err != nil {
+            h.logger.Printf("Error sending rendered image: %v", err)
+            return
+        }
+        if err := renderer.Encode(part, img, mediaType, quality); err != nil {
+            h.logger.Printf("Error sending rendered image: %v", err)
+            return
+        }
+    }
+}
+
+// HandleThumbnail returns a small image representing the study, series, instance or frames in the path.
+// Studies and series are represented by their first instance, and a list of frames by its first frame
+func (h *Handler) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
+    options, quality, err := renderOptions(r)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    if options.Viewport == nil {
+        options.Viewport = &renderer.Viewport{Width: renderer.ThumbnailSize, Height: renderer.ThumbnailSize}
+    }
+    numbers := []int{1}
+    if list := mux.Vars(r)["frames"]; list != "" {
+        if numbers, err = dicomweb.ParseFrameNumbers(list); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    }
+
+    mediaType, _, ok := renderedMediaType(r, true)
+    if !ok {
+        http.Error(w, "Thumbnails are available as "+strings.Join(renderer.MediaTypes, ", "), http.StatusNotAcceptable)
+        return
+    }
+
+    instances, ok := h.retrieveInstances(w, r)
+    if !ok {
+        return
+    }
+
+    img, err := h.dicomWeb.Render(&instances[0], numbers[0], options)
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+    h.writeImage(w, img, mediaType, quality)
+}
+
+func (h *Handler) writeImage(w http.ResponseWriter, img image.Image, mediaType string, quality int) {
+    w.Header().Set("Content-Type", mediaType)
+    if err := renderer.Encode(w, img, mediaType, quality); err != nil {
+        h.logger.Printf("Error sending rendered image: %v", err)
+    }
+}
+
+// HandleWadoURI answers legacy WADO-URI links with the original file of the instance or a rendered image
+// of one of its frames, as the contentType parameter asks
+func (h *Handler) HandleWadoURI(w http.ResponseWriter, r *http.Request) {
+    request, err := dicomweb.ParseURIRequest(r.URL.Query())
+    if errors.Is(err, dicomweb.ErrNotAcceptable) {
+        http.Error(w, err.Error(), http.StatusNotAcceptable)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    instances, err := h.dicomWeb.Instances(request.StudyInstanceUID, request.SeriesInstanceUID, request.SOPInstanceUID)
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+    instance := &instances[0]
+
+    if request.ContentType != dicomweb.MediaTypeDICOM {
+        img, err := h.dicomWeb.Render(instance, request.Frame, request.Options)
+        if err != nil {
+            retrieveFailed(w, err)
+            return
+        }
+        h.writeImage(w, img, request.ContentType, request.Quality)
+        return
+    }
+
+    // Files are returned as they were received, they cannot be transcoded
+    transferSyntax, err := h.dicomWeb.TransferSyntax(instance)
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+    if request.TransferSyntaxUID != "" && request.TransferSyntaxUID != transferSyntax {
+        http.Error(w, "The instance is only available in transfer syntax "+transferSyntax, http.StatusNotAcceptable)
+        return
+    }
+
+    file, err := h.dicomWeb.OpenInstance(instance)
+    if err != nil {
+        retrieveFailed(w, err)
+        return
+    }
+    defer file.Close()
+
+    w.Header().Set("Content-Type", dicomweb.MediaTypeDICOM)
+    if _, err := io.Copy(w, file); err != nil {
+        h.logger.Printf("Error sending DICOM file: %v", err)
+    }
+}
+
+// renderOptions parses the window, viewport and quality query parameters of a rendered request
+func renderOptions(r *http.Request) (renderer.Options, int, error) {
+    var options renderer.Options
+    var err error
+    query := r.URL.Query()
+    if value := query.Get("window"); value != "" {
+        if options.Window, err = renderer.ParseWindow(value); err != nil {
+            return options, 0, err
+        }
+    }
+    if value := query.Get("viewport"); value != "" {
+        if options.Viewport, err = renderer.ParseViewport(value); err != nil {
+            return options, 0, err
+        }
+    }
+
+    quality := renderer.DefaultQuality
+    if value := query.Get("quality"); value != "" {
+        quality, err = strconv.Atoi(value)
+        if err != nil || quality < 1 || quality > 100 {
+            return options, 0, fmt.Errorf("%w: quality must be from 1 to 100", renderer.ErrInvalidOptions)
+        }
+    }
+    return options, quality, nil
+}
+
+// renderedMediaType picks the image type of a rendered response from the Accept header and whether it
+// comes as multipart/related. A plain image is only possible when single is set, JPEG is the default
+func renderedMediaType(r *http.Request, single bool) (string, bool, bool) {
+    for _, accepted := range acceptedTypes(r) {
+        switch {
+        case single && (accepted.MediaType == "*/*" || accepted.MediaType == "image/*"):
+            return renderer.MediaTypes[0], false, true
+        case single && renderedType(accepted.MediaType):
+            return accepted.MediaType, false, true
+        case accepted.MediaType == "*/*" || accepted.MediaType == "multipart/*":
+            return renderer.MediaTypes[0], true, true
+        case accepted.MediaType == "multipart/related":
+            if accepted.Type == "" || accepted.Type == "*/*" || accepted.Type == "image/*" {
+                return renderer.MediaTypes[0], true, true
+            }
+            if renderedType(accepted.Type) {
+                return accepted.Type, true, true
+            }
+        }
+    }
+    return "", false, false
+}
+
+func renderedType(mediaType string) bool {
+    for _, supported := range renderer.MediaTypes {
+        if mediaType == supported {
+            return true
+        }
+    }
+    return false
+}
+
+// retrieveInstances looks up the instances addressed by the path, answering the request itself when
+// there are none
+func (h *Handler) retrieveInstances(w http.ResponseWriter, r *http.Request) ([]model.Dicom, bool) {
+    vars := mux.Vars(r)
+    instances, err := h.dicomWeb.Instances(vars["study"], vars["series"], vars["instance"])
+    if err != nil {
+        retrieveFailed(w, err)
+        return nil, false
+    }
+    return instances, true
+}
+
+func retrieveFailed(w http.ResponseWriter, err error) {
+    if errors.Is(err, dicomweb.ErrNotFound) {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+    http.Error(w, "Error retrieving instances", http.StatusInternalServerError)
+}
+
+// acceptedType is one media range of an Accept header
+type acceptedType struct {
+    MediaType      string
+    Type           string
+    TransferSyntax string
+}
+
+// acceptedTypes parses the Accept header, a missing header accepts anything
+func acceptedTypes(r *http.Request) []acceptedType {
+    header := strings.Join(r.Header.Values("Accept"), ",")
+    if strings.TrimSpace(header) == "" {
+        return []acceptedType{{MediaType: "*/*"}}
+    }
+
+    var types []acceptedType
+    for _, value := range strings.Split(header, ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(value))
+        if err != nil {
+            continue
+        }
+        types = append(types, acceptedType{
+            MediaType:      mediaType,
+            Type:           params["type"],
+            TransferSyntax: params["transfer-syntax"],
+        })
+    }
+    return types
+}
+
+// acceptsDicomJSON reports whether the Accept header asks for DICOM JSON by name, wildcards are not enough
+func acceptsDicomJSON(r *http.Request) bool {
+    for _, accepted := range acceptedTypes(r) {
+        if accepted.MediaType == dicomJSONMediaType {
+            return true
+        }
+    }
+    return false
+}
+
+// multipart reports whether a multipart/related response with parts of the media type is accepted
+func (a acceptedType) multipart(partType string) bool {
+    if a.MediaType == "*/*" || a.MediaType == "multipart/*" {
+        return true
+    }
+    return a.MediaType == "multipart/related" && (a.Type == "" || a.Type == partType || a.Type == "*/*")
+}
+
+// allows reports whether content in the transfer syntaxes is accepted. When no transfer syntax is
+// asked for, the stored one is accepted
+func (a acceptedType) allows(transferSyntaxes ...string) bool {
+    if a.TransferSyntax == "" || a.TransferSyntax == "*" {
+        return true
+    }
+    for _, transferSyntax := range transferSyntaxes {
+        if transferSyntax != a.TransferSyntax {
+            return false
+        }
+    }
+    return true
+}
+
+// multipartRelated starts a multipart/related response with parts of the media type
+func multipartRelated(w http.ResponseWriter, partType string) *multipart.Writer {
+    writer := multipart.NewWriter(w)
+    w.Header().Set("Content-Type", fmt.Sprintf("multipart/related; type=%q; boundary=%s", partType, writer.Boundary()))
+    return writer
+}
+
+// baseURL is the scheme and host the request was sent to, for links in responses
+func baseURL(r *http.Request) string {
+    scheme := "http"
+    if r.TLS != nil {
+        scheme = "https"
+    }
+    if forwarded := r.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+        scheme = forwarded
+    }
+    return scheme + "://" + r.Host
+}
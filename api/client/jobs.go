@@ -0,0 +1,67 @@
+package client
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+
+    "github.com/gorilla/mux"
+
+    "dicom/api/service/jobs"
+)
+
+// HandleCreateJob queues the ingest of a DICOM file and responds right away with the job id
+func (h *Handler) HandleCreateJob(w http.ResponseWriter, r *http.Request) {
+    var requestBody struct {
+        FilePath string `json:"path"`
+    }
+
+    decoder := json.NewDecoder(r.Body)
+    if err := decoder.Decode(&requestBody); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    if requestBody.FilePath == "" {
+        http.Error(w, "File path is empty", http.StatusBadRequest)
+        return
+    }
+
+    h.queueJob(w, requestBody.FilePath, func(progress func(float64)) (string, error) {
+        return h.dicomIngester.IngestPath(requestBody.FilePath, progress)
+    }, nil)
+}
+
+// queueJob enqueues the task and responds with 202 and its job, or with 503 when the job is not
+// accepted. It reports whether the job was queued, discard is called if it is dropped before running
+func (h *Handler) queueJob(w http.ResponseWriter, source string, task jobs.Task, discard func()) bool {
+    job, err := h.jobQueue.Enqueue(source, task, discard)
+    if err != nil {
+        http.Error(w, "Too many queued jobs", http.StatusServiceUnavailable)
+        return false
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Location", "/jobs/"+job.ID)
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(job)
+
+    h.logger.Printf("Queued job %s for dicom file at: %s", job.ID, source)
+    return true
+}
+
+// HandleGetJob reports the state, progress and error of a job
+func (h *Handler) HandleGetJob(w http.ResponseWriter, r *http.Request) {
+    job, err := h.jobQueue.GetJob(mux.Vars(r)["id"])
+    if errors.Is(err, jobs.ErrJobNotFound) {
+        http.Error(w, "Job not found", http.StatusNotFound)
+        return
+    }
+    if err != nil {
+        http.Error(w, "Failed to fetch job", http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(job)
+}
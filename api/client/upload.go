@@ -0,0 +1,134 @@
+package client
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/gorilla/mux"
+
+    "dicom/api/service/parser"
+    "dicom/api/service/uploader"
+)
+
+// HandleCreateUpload starts a resumable upload session for a large DICOM file
+func (h *Handler) HandleCreateUpload(w http.ResponseWriter, r *http.Request) {
+    var requestBody struct {
+        Size      int64 `json:"size"`
+        ChunkSize int64 `json:"chunkSize"`
+    }
+
+    decoder := json.NewDecoder(r.Body)
+    if err := decoder.Decode(&requestBody); err != nil {
+        http.Error(w, "Invalid request body", http.StatusBadRequest)
+        return
+    }
+
+    upload, err := h.dicomUploader.CreateUpload(requestBody.Size, requestBody.ChunkSize)
+    if err != nil {
+        h.uploadError(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(upload)
+
+    h.logger.Printf("Successfully created upload: %s", upload.ID)
+}
+
+// HandleGetUpload reports which chunks of an upload are still missing
+func (h *Handler) HandleGetUpload(w http.ResponseWriter, r *http.Request) {
+    upload, err := h.dicomUploader.GetUpload(mux.Vars(r)["id"])
+    if err != nil {
+        h.uploadError(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(upload)
+}
+
+// HandleUploadChunk stores one numbered chunk, the X-Checksum-SHA256 header must hold the hex encoded
+// SHA-256 of the chunk
+func (h *Handler) HandleUploadChunk(w http.ResponseWriter, r *http.Request) {
+    vars := mux.Vars(r)
+
+    index, err := strconv.Atoi(vars["index"])
+    if err != nil {
+        http.Error(w, "Invalid chunk index", http.StatusBadRequest)
+        return
+    }
+
+    checksum := r.Header.Get("X-Checksum-SHA256")
+    if checksum == "" {
+        http.Error(w, "X-Checksum-SHA256 header is required", http.StatusBadRequest)
+        return
+    }
+
+    if err := h.dicomUploader.WriteChunk(vars["id"], index, checksum, r.Body); err != nil {
+        h.uploadError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleCompleteUpload checks that an upload is complete and queues the ingest of the assembled file,
+// responding right away with the job id. The session is removed once the file is stored, it is kept
+// when the file fails so that completing it can be retried
+func (h *Handler) HandleCompleteUpload(w http.ResponseWriter, r *http.Request) {
+    id := mux.Vars(r)["id"]
+
+    file, err := h.dicomUploader.OpenUpload(id)
+    if err != nil {
+        h.uploadError(w, err)
+        return
+    }
+    file.Close()
+
+    // A job dropped at shutdown keeps the chunks, so the upload can be completed again
+    h.queueJob(w, "upload "+id, func(progress func(float64)) (string, error) {
+        file, err := h.dicomUploader.OpenUpload(id)
+        if err != nil {
+            return "", err
+        }
+        uuid, err := h.dicomIngester.IngestFile(file, progress)
+        file.Close()
+        // The content of a duplicate is already stored, its chunks are no longer needed
+        if err != nil && !errors.Is(err, parser.ErrDuplicate) {
+            return "", err
+        }
+
+        if err := h.dicomUploader.DeleteUpload(id); err != nil {
+            h.logger.Printf("Error removing completed upload %s: %v", id, err)
+        }
+        return uuid, err
+    }, nil)
+}
+
+// HandleDeleteUpload abandons an upload and discards its chunks
+func (h *Handler) HandleDeleteUpload(w http.ResponseWriter, r *http.Request) {
+    if err := h.dicomUploader.DeleteUpload(mux.Vars(r)["id"]); err != nil {
+        h.uploadError(w, err)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) uploadError(w http.ResponseWriter, err error) {
+    switch {
+    case errors.Is(err, uploader.ErrUploadNotFound):
+        http.Error(w, "Upload not found", http.StatusNotFound)
+    case errors.Is(err, uploader.ErrInvalidUpload), errors.Is(err, uploader.ErrInvalidChunk):
+        http.Error(w, err.Error(), http.StatusBadRequest)
+    case errors.Is(err, uploader.ErrChecksumMismatch):
+        http.Error(w, "Chunk checksum mismatch", http.StatusUnprocessableEntity)
+    case errors.Is(err, uploader.ErrUploadIncomplete):
+        http.Error(w, "Upload is missing chunks", http.StatusConflict)
+    default:
+        http.Error(w, "Error handling upload", http.StatusInternalServerError)
+    }
+}
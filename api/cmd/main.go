@@ -1,19 +1,79 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 	
 	"github.com/gorilla/mux"
 
 	"dicom/api/client"
 	"dicom/api/repository/blob"
 	"dicom/api/repository/sql"
+	"dicom/api/service/dicomweb"
+	"dicom/api/service/dimse"
 	"dicom/api/service/fetcher"
+	"dicom/api/service/importer"
+	"dicom/api/service/ingester"
+	"dicom/api/service/jobs"
 	"dicom/api/service/parser"
 	"dicom/api/service/processor"
+	"dicom/api/service/renderer"
+	"dicom/api/service/uploader"
+	"dicom/api/service/watcher"
+)
+
+const (
+	// Number of ingest jobs processed at the same time
+	jobWorkers = 4
+	// Number of ingest jobs that can wait for a worker before new ones are rejected
+	jobQueueSize = 1000
+	// Number of files ingested at the same time by a directory import
+	importWorkers = 4
+	// How long requests in progress and queued jobs are given to finish when the service is stopped
+	shutdownTimeout = 30 * time.Second
+	// Environment variable choosing what happens to files that were already ingested: reject, existing or replace
+	duplicatePolicyEnv = "DICOM_DUPLICATE_POLICY"
+	// Environment variables choosing the database, sqlite3 (the default) or postgres, and its data source:
+	// a file for SQLite, ./dicom.db by default, or a connection string for PostgreSQL
+	dbDriverEnv = "DICOM_DB_DRIVER"
+	dbDSNEnv    = "DICOM_DB_DSN"
+	// Environment variable which, when true, reports the pending schema migrations and exits without
+	// changing the database
+	migrateDryRunEnv = "DICOM_MIGRATE_DRY_RUN"
+	// Environment variable with the size in bytes above which binary values are stored apart from the tags
+	bulkDataThresholdEnv = "DICOM_BULK_DATA_THRESHOLD"
+	// Environment variable setting how long an upload is kept without receiving a chunk, e.g. 12h
+	uploadExpiryEnv = "DICOM_UPLOAD_EXPIRY"
+	// Environment variable listing drop directories to watch for new files, separated like PATH
+	watchDirsEnv = "DICOM_WATCH_DIRS"
+	// Environment variable setting how often the drop directories are polled, e.g. 10s
+	watchIntervalEnv = "DICOM_WATCH_INTERVAL"
+	// Poll interval used when none is configured
+	defaultWatchInterval = 5 * time.Second
+	// Environment variable with the address of the DICOM network listener, e.g. :11112
+	dicomAddrEnv = "DICOM_SCP_ADDR"
+	// Environment variable with the size in bytes above which a dataset received by the DICOM network
+	// listener aborts the association
+	dicomMaxDatasetSizeEnv = "DICOM_SCP_MAX_DATASET_SIZE"
+	// Environment variable with the AE title of the DICOM network listener
+	dicomAETitleEnv = "DICOM_SCP_AE_TITLE"
+	// AE title used when none is configured
+	defaultAETitle = "DICOM"
+	// Environment variables listing, separated by commas, the AE titles of the devices allowed to associate
+	// and the AE titles the listener answers to. Any AE title is allowed when unset
+	callingAETitlesEnv = "DICOM_SCP_CALLING_AE_TITLES"
+	calledAETitlesEnv  = "DICOM_SCP_CALLED_AE_TITLES"
 )
 
 func main() {
@@ -24,24 +84,125 @@ func main() {
 		panic(err)
 	}
 
-	
-	sqlRepo, err := sql.NewSqlDatabase(logger)
+	if value := os.Getenv(migrateDryRunEnv); value != "" {
+		dryRun, err := strconv.ParseBool(value)
+		if err != nil {
+			panic(err)
+		}
+		if dryRun {
+			if err := sql.DryRunMigrations(os.Getenv(dbDriverEnv), os.Getenv(dbDSNEnv), logger); err != nil {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	sqlRepo, err := sql.NewSqlDatabase(os.Getenv(dbDriverEnv), os.Getenv(dbDSNEnv), logger)
 	if err != nil {
 		panic(err)
 	}
 
+	// Instantiate renderer service
+	dicomRenderer := renderer.NewDicomRenderer(logger)
+
 	// Instantiate fetcher service
-	dicomFetcher := fetcher.NewDicomFetcher(blobStorage, sqlRepo, logger)
+	dicomFetcher := fetcher.NewDicomFetcher(blobStorage, sqlRepo, dicomRenderer, logger)
 
 	// Instantiate parser service
-	dicomParser := parser.NewDicomParser(sqlRepo, logger)
+	dicomParser := parser.NewDicomParser(sqlRepo, blobStorage, logger)
+	if name := os.Getenv(duplicatePolicyEnv); name != "" {
+		policy, err := parser.ParseDuplicatePolicy(name)
+		if err != nil {
+			panic(err)
+		}
+		dicomParser.SetDuplicatePolicy(policy)
+	}
 
 	// Instantiate processor service
 	dicomProcessor := processor.NewDicomProcessor(sqlRepo, blobStorage, logger)
+	bulkDataThreshold := processor.DefaultBulkDataThreshold
+	if value := os.Getenv(bulkDataThresholdEnv); value != "" {
+		threshold, err := strconv.Atoi(value)
+		if err != nil {
+			panic(err)
+		}
+		bulkDataThreshold = threshold
+	}
+	dicomProcessor.SetBulkDataThreshold(bulkDataThreshold)
+
+	// Instantiate ingester service running the parser and processor as one pipeline
+	dicomIngester := ingester.NewDicomIngester(dicomParser, dicomProcessor, logger)
+
+	// Instantiate importer service for directory batch imports
+	dicomImporter := importer.NewDicomImporter(dicomIngester, importWorkers, logger)
+
+	// Instantiate job queue running ingests in the background
+	jobQueue := jobs.NewJobQueue(jobWorkers, jobQueueSize, logger)
+
+	// Instantiate uploader service for resumable chunked uploads
+	dicomUploader, err := uploader.NewDicomUploader("uploads", logger)
+	if err != nil {
+		panic(err)
+	}
+	if value := os.Getenv(uploadExpiryEnv); value != "" {
+		expiry, err := time.ParseDuration(value)
+		if err != nil {
+			panic(err)
+		}
+		dicomUploader.SetExpiry(expiry)
+	}
+
+	// Instantiate watcher service when drop directories are configured
+	if dirs := os.Getenv(watchDirsEnv); dirs != "" {
+		interval := defaultWatchInterval
+		if value := os.Getenv(watchIntervalEnv); value != "" {
+			interval, err = time.ParseDuration(value)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		dicomWatcher, err := watcher.NewDicomWatcher(dicomIngester, filepath.SplitList(dirs), interval, logger)
+		if err != nil {
+			panic(err)
+		}
+		dicomWatcher.Start()
+		defer dicomWatcher.Stop()
+	}
+
+	// Instantiate DICOM network listener when an address is configured
+	if addr := os.Getenv(dicomAddrEnv); addr != "" {
+		aeTitle := defaultAETitle
+		if value := os.Getenv(dicomAETitleEnv); value != "" {
+			aeTitle = value
+		}
+
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			panic(err)
+		}
+
+		dicomServer := dimse.NewDicomServer(dicomIngester, aeTitle, logger)
+		dicomServer.SetAllowedAETitles(splitList(os.Getenv(callingAETitlesEnv)), splitList(os.Getenv(calledAETitlesEnv)))
+		if value := os.Getenv(dicomMaxDatasetSizeEnv); value != "" {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				panic(err)
+			}
+			dicomServer.SetMaxDatasetSize(size)
+		}
+		go dicomServer.Serve(listener)
+		defer dicomServer.Close()
+	}
+
+	// Instantiate DICOMweb service
+	dicomWeb := dicomweb.NewDicomWeb(dicomIngester, sqlRepo, blobStorage, dicomRenderer, logger)
+	// Metadata inlines the same binary values as the stored tags
+	dicomWeb.SetBulkDataThreshold(bulkDataThreshold)
 
 	// Set up HTTP server
 	router := mux.NewRouter()
-	client.Setup(router, dicomParser, dicomProcessor, dicomFetcher, logger)
+	client.Setup(router, dicomParser, dicomIngester, dicomImporter, dicomFetcher, dicomUploader, dicomWeb, jobQueue, logger)
 
 	// Define server settings
 	serverAddr := ":8000"
@@ -50,10 +211,41 @@ func main() {
 		Handler: router,
 	}
 
-	fmt.Println("server started")
+	// Stop on SIGINT or SIGTERM, letting requests in progress and then queued jobs finish within the
+	// shutdown timeout. Jobs that have not started by then are dropped. The deferred listeners and
+	// watcher are closed last
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Start server
-	if err := server.ListenAndServe(); err != nil {
-		panic(err)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
+
+	fmt.Println("server started")
+
+	<-ctx.Done()
+	logger.Println("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Printf("Error shutting down server: %v", err)
+	}
+	if err := jobQueue.Close(shutdownCtx); err != nil {
+		logger.Printf("Error closing job queue: %v", err)
+	}
+}
+
+// splitList splits a comma separated environment variable, ignoring empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
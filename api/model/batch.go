@@ -0,0 +1,8 @@
+package model
+
+// BatchResult summarizes the import of every file found below a directory
+type BatchResult struct {
+    Succeeded []IngestResult `json:"succeeded"`
+    Skipped   []string       `json:"skipped"`
+    Failed    []IngestResult `json:"failed"`
+}
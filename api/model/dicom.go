@@ -1,6 +1,33 @@
 package model
 
 type Dicom struct {
-	ID       int64
-	ImageURL string
-}
\ No newline at end of file
+	ID             int64
+	UUID           string
+	ImageURL       string
+	FileURL        string
+	SOPInstanceUID string
+	FileHash       string
+	DicomAttributes
+}
+
+// DicomAttributes are the patient, study, series and instance attributes of a DICOM file, kept so files
+// can be searched without reading their tags
+type DicomAttributes struct {
+	StudyInstanceUID       string
+	SeriesInstanceUID      string
+	SOPClassUID            string
+	PatientID              string
+	PatientName            string
+	PatientBirthDate       string
+	PatientSex             string
+	StudyDate              string
+	StudyTime              string
+	AccessionNumber        string
+	StudyID                string
+	StudyDescription       string
+	ReferringPhysicianName string
+	Modality               string
+	SeriesNumber           string
+	SeriesDescription      string
+	InstanceNumber         string
+}
@@ -0,0 +1,26 @@
+package model
+
+// DicomDirRecord is a file referenced by a DICOMDIR, along with the patient, study and series
+// directory records it was found under
+type DicomDirRecord struct {
+    Path              string `json:"path"`
+    RecordType        string `json:"recordType"`
+    PatientID         string `json:"patientId,omitempty"`
+    StudyInstanceUID  string `json:"studyInstanceUid,omitempty"`
+    SeriesInstanceUID string `json:"seriesInstanceUid,omitempty"`
+    SOPInstanceUID    string `json:"sopInstanceUid,omitempty"`
+}
+
+// IngestResult is the outcome of ingesting a single file out of a larger request
+type IngestResult struct {
+    Path  string `json:"path"`
+    ID    string `json:"id,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+// QueuedFile is the job queued to ingest a single file out of a larger request, or why it was not queued
+type QueuedFile struct {
+    Path  string `json:"path"`
+    JobID string `json:"jobId,omitempty"`
+    Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,20 @@
+package model
+
+// DicomJSON is a dataset in the DICOM JSON model of PS3.18 annex F, keyed by tags in "ggggeeee" form
+type DicomJSON map[string]DicomJSONAttribute
+
+// DicomJSONAttribute is one attribute of a DicomJSON dataset. Sequence items are DicomJSON values,
+// person names are DicomJSONPersonName values
+type DicomJSONAttribute struct {
+    VR           string        `json:"vr"`
+    Value        []interface{} `json:"Value,omitempty"`
+    BulkDataURI  string        `json:"BulkDataURI,omitempty"`
+    InlineBinary string        `json:"InlineBinary,omitempty"`
+}
+
+// DicomJSONPersonName holds the component groups of a person name
+type DicomJSONPersonName struct {
+    Alphabetic  string `json:"Alphabetic,omitempty"`
+    Ideographic string `json:"Ideographic,omitempty"`
+    Phonetic    string `json:"Phonetic,omitempty"`
+}
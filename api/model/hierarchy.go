@@ -0,0 +1,33 @@
+package model
+
+// Patient is a patient of the DICOM information model, identified by its patient ID
+type Patient struct {
+	ID               int64
+	PatientID        string
+	PatientName      string
+	PatientBirthDate string
+	PatientSex       string
+}
+
+// Study is a study of a patient, identified by its study instance UID
+type Study struct {
+	ID                     int64
+	StudyInstanceUID       string
+	PatientID              string
+	StudyDate              string
+	StudyTime              string
+	AccessionNumber        string
+	StudyID                string
+	StudyDescription       string
+	ReferringPhysicianName string
+}
+
+// Series is a series of a study, identified by its series instance UID
+type Series struct {
+	ID                int64
+	SeriesInstanceUID string
+	StudyInstanceUID  string
+	Modality          string
+	SeriesNumber      string
+	SeriesDescription string
+}
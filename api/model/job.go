@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+const (
+    JobQueued    = "queued"
+    JobRunning   = "running"
+    JobSucceeded = "succeeded"
+    JobFailed    = "failed"
+)
+
+// Job is an ingest request that is processed in the background
+type Job struct {
+    ID         string     `json:"id"`
+    State      string     `json:"state"`
+    Progress   float64    `json:"progress"`
+    Source     string     `json:"source"`
+    DicomID    string     `json:"dicomId,omitempty"`
+    Error      string     `json:"error,omitempty"`
+    CreatedAt  time.Time  `json:"createdAt"`
+    StartedAt  *time.Time `json:"startedAt,omitempty"`
+    FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
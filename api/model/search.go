@@ -0,0 +1,83 @@
+package model
+
+// TagOperator is how a TagPredicate compares the values of a tag
+type TagOperator string
+
+const (
+	TagEqual          TagOperator = "="
+	TagLess           TagOperator = "<"
+	TagLessOrEqual    TagOperator = "<="
+	TagGreater        TagOperator = ">"
+	TagGreaterOrEqual TagOperator = ">="
+	// TagWildcard matches the text of the values with * for any characters and ? for one, ignoring case
+	TagWildcard TagOperator = "*"
+	// TagPresent matches files that have the tag, whatever its value
+	TagPresent TagOperator = "present"
+)
+
+// TagPredicate matches the DICOM files with a top level Tag, e.g. (0008,0070), whose values compare to
+// Value. Value is a float64 compared to the first number, a time.Time compared to the first date or time
+// or a string compared to the values as text, see TagText. Negate matches the files the predicate does not
+type TagPredicate struct {
+	Tag      string
+	Operator TagOperator
+	Value    interface{}
+	Negate   bool
+}
+
+// TagQuery searches DICOM files matching every predicate and returns the Include tags of each
+type TagQuery struct {
+	Predicates []TagPredicate
+	Include    []string
+	Limit      int
+	Offset     int
+}
+
+// SearchMatch is a DICOM file matched by a TagQuery, with the tags the query included
+type SearchMatch struct {
+	ID   string `json:"id"`
+	Tags []Tag  `json:"tags"`
+}
+
+// HierarchyLevel is a level of the patient, study, series and instance hierarchy
+type HierarchyLevel int
+
+const (
+	StudyLevel HierarchyLevel = iota
+	SeriesLevel
+	InstanceLevel
+)
+
+// AttributeMatch is how an AttributePredicate compares the value of an attribute
+type AttributeMatch string
+
+const (
+	// AttributeEqual matches a value equal to any of the values
+	AttributeEqual AttributeMatch = "="
+	// AttributeWildcard matches any of the values with * for any characters and ? for one
+	AttributeWildcard AttributeMatch = "*"
+	// AttributeRange matches a value from the first value to the second, either bound may be empty. A value
+	// more precise than the upper bound is cut to its precision, so 0930 includes 093015
+	AttributeRange AttributeMatch = "-"
+)
+
+// AttributePredicate matches the DICOM files whose Attribute, the name of a field of Dicom such as
+// Modality, compares to Values. IgnoreCase compares the text of wildcards ignoring case, and Fuzzy also
+// matches person names with a component starting with one of Values, ignoring case
+type AttributePredicate struct {
+	Attribute  string
+	Match      AttributeMatch
+	Values     []string
+	IgnoreCase bool
+	Fuzzy      bool
+}
+
+// HierarchyQuery searches the studies, series or instances of Level with an instance matching each
+// predicate. Attributes of lower levels only have to match on one instance, e.g. a study matches a
+// Modality predicate with any of its series. At most Limit matches are returned, which must be set
+type HierarchyQuery struct {
+	Level      HierarchyLevel
+	Predicates []AttributePredicate
+	Limit      int
+	Offset     int
+}
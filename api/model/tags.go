@@ -1,5 +1,10 @@
 package model
 
+import (
+    "fmt"
+    "strings"
+)
+
 // Tag: (0400,0563)
 //   Tag Name: ModifyingSystem
 //   VR: VRStringList
@@ -8,11 +13,49 @@ package model
 //   Value: [INTELEPACSPACS-4-6-1-P146LDSPACS-4-6-1-P146]
 
 
-// Tag represents a DICOM tag
+// Tag represents a DICOM tag. Tags inside sequence items have the Path of the item they are in and Item,
+// the index of that item; top level tags have an empty Path. The Value of a sequence is its item count.
+// Values holds every value typed after RawVR, the two letter VR: numbers, time.Time for dates and times,
+// PersonName for person names and strings otherwise. Binary values and sequences have none
 type Tag struct {
-	ID    int64
+	ID     int64
+    Tag    string
+    VR     string
+    Value  string
+    Name   string
+    Path   string
+    Item   int
+    RawVR  string
+    Values []interface{} `json:",omitempty"`
+    // BulkDataURI links to binary values too large to be stored inline, their Value is empty
+    BulkDataURI string `json:",omitempty"`
+}
+
+// BulkDataKey identifies a tag within a DICOM file, e.g. 00081140.0.7FE00010 for a tag in the first item
+// of a sequence
+func BulkDataKey(path string, tag string) string {
+    if path != "" {
+        tag = path + "." + tag
+    }
+    return strings.ToUpper(strings.NewReplacer("(", "", ",", "", ")", "", "[", ".", "]", "").Replace(tag))
+}
+
+// TagNode is a tag with the tags of the items of a sequence nested below it
+type TagNode struct {
     Tag   string
     VR    string
     Value string
     Name  string
+    BulkDataURI string `json:",omitempty"`
+    Items [][]TagNode `json:",omitempty"`
+}
+
+// ItemPath is the Path of the tags in an item of the sequence tag found at path, e.g.
+// (0008,1140)[0].(0008,1155)[2]
+func ItemPath(path string, tag string, item int) string {
+    itemPath := fmt.Sprintf("%s[%d]", tag, item)
+    if path == "" {
+        return itemPath
+    }
+    return path + "." + itemPath
 }
\ No newline at end of file
@@ -0,0 +1,19 @@
+package model
+
+// Upload is a resumable upload session of a single DICOM file sent in numbered chunks
+type Upload struct {
+    ID        string        `json:"id"`
+    Size      int64         `json:"size"`
+    ChunkSize int64         `json:"chunkSize"`
+    Chunks    int           `json:"chunks"`
+    Missing   []UploadChunk `json:"missing"`
+}
+
+// UploadChunk describes the byte range covered by Count consecutive chunks of an upload, starting with the
+// chunk at Index
+type UploadChunk struct {
+    Index  int   `json:"index"`
+    Count  int   `json:"count"`
+    Offset int64 `json:"offset"`
+    Size   int64 `json:"size"`
+}
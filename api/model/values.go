@@ -0,0 +1,191 @@
+package model
+
+import (
+    "encoding/json"
+    "errors"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// TimestampLayout formats typed DA, TM and DT values in UTC with a fixed width, so they sort as text
+const TimestampLayout = "2006-01-02T15:04:05.000000Z07:00"
+
+// PersonName is a PN value split into its components (PS3.5 section 6.2). The ideographic and phonetic
+// groups are kept whole
+type PersonName struct {
+    Family      string `json:",omitempty"`
+    Given       string `json:",omitempty"`
+    Middle      string `json:",omitempty"`
+    Prefix      string `json:",omitempty"`
+    Suffix      string `json:",omitempty"`
+    Ideographic string `json:",omitempty"`
+    Phonetic    string `json:",omitempty"`
+}
+
+// ParsePersonName splits a PN value like Family^Given^Middle^Prefix^Suffix=Ideographic=Phonetic
+func ParsePersonName(value string) PersonName {
+    groups := strings.SplitN(value, "=", 3)
+    components := append(strings.SplitN(groups[0], "^", 5), make([]string, 5)...)
+
+    name := PersonName{
+        Family: strings.TrimSpace(components[0]),
+        Given:  strings.TrimSpace(components[1]),
+        Middle: strings.TrimSpace(components[2]),
+        Prefix: strings.TrimSpace(components[3]),
+        Suffix: strings.TrimSpace(components[4]),
+    }
+    if len(groups) > 1 {
+        name.Ideographic = strings.TrimSpace(groups[1])
+    }
+    if len(groups) > 2 {
+        name.Phonetic = strings.TrimSpace(groups[2])
+    }
+    return name
+}
+
+// String formats the name back as a PN value, leaving out empty trailing components and groups
+func (n PersonName) String() string {
+    name := strings.TrimRight(strings.Join([]string{n.Family, n.Given, n.Middle, n.Prefix, n.Suffix}, "^"), "^")
+    return strings.TrimRight(strings.Join([]string{name, n.Ideographic, n.Phonetic}, "="), "=")
+}
+
+// ParseDate parses a DA value, YYYYMMDD or the older YYYY.MM.DD, as midnight UTC
+func ParseDate(value string) (time.Time, error) {
+    return time.Parse("20060102", strings.ReplaceAll(strings.TrimSpace(value), ".", ""))
+}
+
+// ParseTime parses a TM value, HH[MM[SS[.FFFFFF]]] or the older HH:MM:SS, on January 1st of year 0
+func ParseTime(value string) (time.Time, error) {
+    value = strings.ReplaceAll(strings.TrimSpace(value), ":", "")
+    if value == "" || strings.HasPrefix(value, ".") {
+        return time.Time{}, errors.New("time without hours")
+    }
+    return parseTimestamp("00000101"+value, time.UTC)
+}
+
+// ParseDateTime parses a DT value, YYYY[MM[DD[HH[MM[SS[.FFFFFF]]]]]][&ZZXX], in UTC without an offset
+func ParseDateTime(value string) (time.Time, error) {
+    value = strings.TrimSpace(value)
+    location := time.UTC
+    if i := strings.IndexAny(value, "+-"); i >= 0 {
+        offset, err := time.Parse("-0700", value[i:])
+        if err != nil {
+            return time.Time{}, err
+        }
+        location = offset.Location()
+        value = value[:i]
+    }
+    return parseTimestamp(value, location)
+}
+
+// parseTimestamp parses a YYYY[MM[DD[HH[MM[SS[.FFFFFF]]]]]] value, missing parts default to their minimum
+func parseTimestamp(value string, location *time.Location) (time.Time, error) {
+    fraction := ""
+    if i := strings.Index(value, "."); i >= 0 {
+        value, fraction = value[:i], value[i:]
+    }
+    if len(value) < 4 || len(value)%2 != 0 || len(value) > 14 {
+        return time.Time{}, errors.New("invalid date time " + value)
+    }
+    // Month and day default to 01, hours, minutes and seconds to 00
+    defaults := "0101000000"
+    return time.ParseInLocation("20060102150405.999999999", value+defaults[len(value)-4:]+fraction, location)
+}
+
+// BinaryVR tells whether values of the VR are bytes, which are stored base64 encoded when small enough
+// to be kept with the tags
+func BinaryVR(vr string) bool {
+    switch vr {
+    case "OB", "OD", "OF", "OL", "OV", "OW", "UN":
+        return true
+    }
+    return false
+}
+
+// TagText formats typed values back as text in the DICOM encoding of the VR, separated by backslashes
+// like multiple values are in DICOM files. Dates and times lose the precision they were written with, and
+// binary values have no text
+func TagText(vr string, values []interface{}) string {
+    if BinaryVR(vr) {
+        return ""
+    }
+    texts := make([]string, len(values))
+    for i, value := range values {
+        switch v := value.(type) {
+        case string:
+            texts[i] = v
+        case PersonName:
+            texts[i] = v.String()
+        case int64:
+            texts[i] = strconv.FormatInt(v, 10)
+        case float64:
+            texts[i] = strconv.FormatFloat(v, 'g', -1, 64)
+        case time.Time:
+            switch vr {
+            case "DA":
+                texts[i] = v.Format("20060102")
+            case "TM":
+                texts[i] = v.Format("150405.999999")
+            default:
+                texts[i] = v.Format("20060102150405.999999")
+            }
+        }
+    }
+    return strings.Join(texts, "\\")
+}
+
+// DecodeTagValues decodes the typed values of a tag stored as JSON, giving back the types they were
+// encoded from for the VR: time.Time, PersonName, int64, float64 or string
+func DecodeTagValues(vr string, data string) []interface{} {
+    if data == "" {
+        return nil
+    }
+    var raw []json.RawMessage
+    if err := json.Unmarshal([]byte(data), &raw); err != nil {
+        return nil
+    }
+
+    values := make([]interface{}, len(raw))
+    for i, value := range raw {
+        values[i] = decodeTagValue(vr, value)
+    }
+    return values
+}
+
+func decodeTagValue(vr string, raw json.RawMessage) interface{} {
+    if string(raw) == "null" {
+        return nil
+    }
+
+    var typed interface{}
+    var err error
+    switch vr {
+    case "DA", "TM", "DT":
+        var value time.Time
+        err = json.Unmarshal(raw, &value)
+        typed = value
+    case "PN":
+        var value PersonName
+        err = json.Unmarshal(raw, &value)
+        typed = value
+    case "IS", "SS", "US", "SL", "UL", "SV", "UV":
+        var value int64
+        err = json.Unmarshal(raw, &value)
+        typed = value
+    case "DS", "FL", "FD":
+        var value float64
+        err = json.Unmarshal(raw, &value)
+        typed = value
+    default:
+        err = errors.New("untyped VR")
+    }
+    if err == nil {
+        return typed
+    }
+
+    // Values that did not parse as their VR when stored are kept as they were
+    var value interface{}
+    json.Unmarshal(raw, &value)
+    return value
+}
@@ -1,15 +1,23 @@
 package blob
 
 import (
+    "errors"
     "image"
     "image/png"
+    "io"
+    "io/fs"
     "log"
     "os"
+    "path/filepath"
 )
 
 type Repository interface {
     WritePngToFile(image image.Image, path string) error
     ReadImageFromFile(path string) (image.Image, error)
+    WriteFile(r io.Reader, path string) error
+    OpenFile(path string) (io.ReadCloser, error)
+    DeleteFile(path string) error
+    MoveFile(from string, to string) error
 }
 
 type BlobStorage struct {
@@ -59,3 +67,66 @@ func (b *BlobStorage) ReadImageFromFile(path string) (image.Image, error) {
 
     return img, nil
 }
+
+// WriteFile stores everything read from r at the path, replacing any file already there. The content
+// is written next to the path first so a failed write never leaves a truncated file behind
+func (b *BlobStorage) WriteFile(r io.Reader, path string) error {
+    file, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+    if err != nil {
+        b.logger.Printf("Error creating file: %v", err)
+        return err
+    }
+    defer os.Remove(file.Name())
+    // Temporary files are only readable by their owner
+    if err := file.Chmod(0644); err != nil {
+        file.Close()
+        b.logger.Printf("Error creating file: %v", err)
+        return err
+    }
+
+    if _, err := io.Copy(file, r); err != nil {
+        file.Close()
+        b.logger.Printf("Error writing file: %v", err)
+        return err
+    }
+    if err := file.Close(); err != nil {
+        b.logger.Printf("Error writing file: %v", err)
+        return err
+    }
+
+    if err := os.Rename(file.Name(), path); err != nil {
+        b.logger.Printf("Error moving file into place: %v", err)
+        return err
+    }
+
+    return nil
+}
+
+// OpenFile opens a file stored with WriteFile, the caller closes it
+func (b *BlobStorage) OpenFile(path string) (io.ReadCloser, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        b.logger.Printf("Error opening file: %v", err)
+        return nil, err
+    }
+
+    return file, nil
+}
+
+// DeleteFile removes a stored file, a file that does not exist is already removed
+func (b *BlobStorage) DeleteFile(path string) error {
+    if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+        b.logger.Printf("Error removing file: %v", err)
+        return err
+    }
+    return nil
+}
+
+// MoveFile puts a stored file in place of another, replacing it at once
+func (b *BlobStorage) MoveFile(from string, to string) error {
+    if err := os.Rename(from, to); err != nil {
+        b.logger.Printf("Error moving file: %v", err)
+        return err
+    }
+    return nil
+}
@@ -2,12 +2,17 @@ package blob
 
 import (
     "image"
+    "io"
 )
 
 // MockRepository is a mock implementation of the Repository interface
 type MockRepository struct {
     WritePngToFileFunc  func(image image.Image, path string) error
     ReadImageFromFileFunc func(path string) (image.Image, error)
+    WriteFileFunc func(r io.Reader, path string) error
+    OpenFileFunc func(path string) (io.ReadCloser, error)
+    DeleteFileFunc func(path string) error
+    MoveFileFunc func(from string, to string) error
 }
 
 func (m *MockRepository) WritePngToFile(image image.Image, path string) error {
@@ -23,3 +28,31 @@ func (m *MockRepository) ReadImageFromFile(path string) (image.Image, error) {
     }
     return nil, nil
 }
+
+func (m *MockRepository) WriteFile(r io.Reader, path string) error {
+    if m.WriteFileFunc != nil {
+        return m.WriteFileFunc(r, path)
+    }
+    return nil
+}
+
+func (m *MockRepository) OpenFile(path string) (io.ReadCloser, error) {
+    if m.OpenFileFunc != nil {
+        return m.OpenFileFunc(path)
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) DeleteFile(path string) error {
+    if m.DeleteFileFunc != nil {
+        return m.DeleteFileFunc(path)
+    }
+    return nil
+}
+
+func (m *MockRepository) MoveFile(from string, to string) error {
+    if m.MoveFileFunc != nil {
+        return m.MoveFileFunc(from, to)
+    }
+    return nil
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"image"
 	"image/png"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -106,3 +109,67 @@ func TestReadImageFromFile_Error(t *testing.T) {
 		t.Errorf("Expected logger output containing '%s', got '%s'", expectedLogOutput, mockLogger.Output.String())
 	}
 }
+
+func TestWriteFileAndOpenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test_file.dcm")
+	for _, content := range []string{"first content", "second"} {
+		if err := blockStorage.WriteFile(strings.NewReader(content), path); err != nil {
+			t.Fatalf("WriteFile returned an unexpected error: %v", err)
+		}
+
+		file, err := blockStorage.OpenFile(path)
+		if err != nil {
+			t.Fatalf("OpenFile returned an unexpected error: %v", err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil || string(data) != content {
+			t.Errorf("Expected %q, got %q, %v", content, data, err)
+		}
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary files to be left, got %d entries", len(entries))
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test_file.dcm")
+	if err := blockStorage.WriteFile(strings.NewReader("content"), path); err != nil {
+		t.Fatalf("WriteFile returned an unexpected error: %v", err)
+	}
+
+	// Removing a file twice is not an error
+	for i := 0; i < 2; i++ {
+		if err := blockStorage.DeleteFile(path); err != nil {
+			t.Errorf("DeleteFile returned an unexpected error: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the file to be removed, got %v", err)
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "staged.dcm"), filepath.Join(dir, "test_file.dcm")
+	for path, content := range map[string]string{from: "new", to: "old"} {
+		if err := blockStorage.WriteFile(strings.NewReader(content), path); err != nil {
+			t.Fatalf("WriteFile returned an unexpected error: %v", err)
+		}
+	}
+
+	if err := blockStorage.MoveFile(from, to); err != nil {
+		t.Fatalf("MoveFile returned an unexpected error: %v", err)
+	}
+	if content, err := os.ReadFile(to); err != nil || string(content) != "new" {
+		t.Errorf("Expected the moved file in place, got %q, %v", content, err)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("Expected the staged file to be gone, got %v", err)
+	}
+	if err := blockStorage.MoveFile(from, to); err == nil {
+		t.Error("Expected an error moving a missing file, got nil")
+	}
+}
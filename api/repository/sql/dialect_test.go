@@ -0,0 +1,48 @@
+package sql
+
+import (
+	"testing"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	postgres := dialects[DriverPostgres]
+	tests := map[string]string{
+		"SELECT id FROM dicom WHERE uuid = ?":                       "SELECT id FROM dicom WHERE uuid = $1",
+		"WHERE CAST(? AS TEXT) = '' OR patient_id = ?":              "WHERE CAST($1 AS TEXT) = '' OR patient_id = $2",
+		"SELECT '?' FROM dicom WHERE uuid = ? AND file_hash != '?'": "SELECT '?' FROM dicom WHERE uuid = $1 AND file_hash != '?'",
+		"SELECT COUNT(*) FROM dicom":                                "SELECT COUNT(*) FROM dicom",
+	}
+	for query, expected := range tests {
+		if rebound := postgres.rebind(query); rebound != expected {
+			t.Errorf("Expected %q, got %q", expected, rebound)
+		}
+	}
+
+	query := "SELECT id FROM dicom WHERE uuid = ?"
+	if rebound := dialects[DriverSQLite].rebind(query); rebound != query {
+		t.Errorf("Expected SQLite queries to be left alone, got %q", rebound)
+	}
+}
+
+func TestDialect_Migrations(t *testing.T) {
+	// Every schema change is made for each driver
+	if len(sqliteMigrations) != len(postgresMigrations) {
+		t.Fatalf("Expected as many PostgreSQL as SQLite migrations, got %d and %d", len(postgresMigrations), len(sqliteMigrations))
+	}
+	for i, m := range sqliteMigrations {
+		if postgres := postgresMigrations[i]; postgres.version != m.version || postgres.name != m.name {
+			t.Errorf("Expected PostgreSQL migration %d %s, got %d %s", m.version, m.name, postgres.version, postgres.name)
+		}
+		for _, script := range []string{m.script, postgresMigrations[i].script} {
+			if _, err := migrationScripts.ReadFile(script); err != nil {
+				t.Errorf("Migration script %s is not embedded: %v", script, err)
+			}
+		}
+	}
+}
+
+func TestOpenConn_UnsupportedDriver(t *testing.T) {
+	if _, err := openConn("mysql", ""); err == nil {
+		t.Error("Expected an error for an unsupported driver")
+	}
+}
@@ -0,0 +1,423 @@
+package sql
+
+import (
+    "database/sql"
+    "embed"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+
+    "dicom/api/model"
+)
+
+// migrationScripts hold the schema changes of each driver, applied once in the order of its migrations
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationScripts embed.FS
+
+// migration changes the schema from the previous version to version. The script runs between before and
+// after, which upgrade databases created before migrations were versioned or convert stored data
+type migration struct {
+    version int
+    name    string
+    script  string
+    before  func(tx *transaction) error
+    after   func(tx *transaction) error
+}
+
+// sqliteMigrations are applied in order, a new migration goes at the end with the next version and the
+// same version is added to postgresMigrations. Applied migrations are never changed
+var sqliteMigrations = []migration{
+    {version: 1, name: "dicom", script: "migrations/sqlite/001_dicom.sql", before: upgradeDicomTable},
+    {version: 2, name: "hierarchy", script: "migrations/sqlite/002_hierarchy.sql", after: moveDicomAttributes},
+    {version: 3, name: "tags", script: "migrations/sqlite/003_tags.sql", after: moveTags},
+    {version: 4, name: "text_values", script: "migrations/sqlite/004_text_values.sql", after: fillTextValues},
+    {version: 5, name: "unique_fingerprints", script: "migrations/sqlite/005_unique_fingerprints.sql"},
+    {version: 6, name: "inline_binary", script: "migrations/sqlite/006_inline_binary.sql", after: fillBinaryValues},
+}
+
+// postgresMigrations build the schema of sqliteMigrations in PostgreSQL, which was only supported once
+// migrations were versioned
+var postgresMigrations = []migration{
+    {version: 1, name: "dicom", script: "migrations/postgres/001_dicom.sql"},
+    {version: 2, name: "hierarchy", script: "migrations/postgres/002_hierarchy.sql"},
+    {version: 3, name: "tags", script: "migrations/postgres/003_tags.sql"},
+    {version: 4, name: "text_values", script: "migrations/postgres/004_text_values.sql", after: fillTextValues},
+    {version: 5, name: "unique_fingerprints", script: "migrations/postgres/005_unique_fingerprints.sql"},
+    {version: 6, name: "inline_binary", script: "migrations/postgres/006_inline_binary.sql", after: fillBinaryValues},
+}
+
+// migrate applies the migrations the database has not seen yet, each in its own transaction
+func (d *Database) migrate() error {
+    version, err := schemaVersion(d.db)
+    if err != nil {
+        return err
+    }
+
+    for _, m := range pendingMigrations(d.db.dialect.migrations, version) {
+        tx, err := d.db.Begin()
+        if err != nil {
+            return err
+        }
+        if err := applyMigration(tx, m); err != nil {
+            tx.Rollback()
+            return fmt.Errorf("migration %d %s: %w", m.version, m.name, err)
+        }
+        if err := tx.Commit(); err != nil {
+            return err
+        }
+        d.logger.Printf("Applied migration %d %s", m.version, m.name)
+    }
+    return nil
+}
+
+// DryRunMigrations reports the schema version of the database and applies the pending migrations in a
+// transaction that is rolled back, so nothing is changed
+func DryRunMigrations(driver string, dataSource string, logger *log.Logger) error {
+    db, err := openConn(driver, dataSource)
+    if err != nil {
+        logger.Printf("Error opening database: %v", err)
+        return err
+    }
+    defer db.Close()
+
+    version, err := schemaVersion(db)
+    if err != nil {
+        logger.Printf("Error reading schema version: %v", err)
+        return err
+    }
+    pending := pendingMigrations(db.dialect.migrations, version)
+    logger.Printf("Schema version %d, %d migrations pending", version, len(pending))
+
+    tx, err := db.Begin()
+    if err != nil {
+        logger.Printf("Error starting transaction: %v", err)
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, m := range pending {
+        if err := applyMigration(tx, m); err != nil {
+            logger.Printf("Migration %d %s would fail: %v", m.version, m.name, err)
+            return err
+        }
+        logger.Printf("Migration %d %s would be applied", m.version, m.name)
+    }
+    return nil
+}
+
+// schemaVersion is the version of the last migration applied, 0 for a new database or one created
+// before migrations were versioned
+func schemaVersion(db *conn) (int, error) {
+    var found int
+    err := db.QueryRow(db.dialect.tableExists, "schema_version").Scan(&found)
+    if err != nil || found == 0 {
+        return 0, err
+    }
+
+    var version int
+    if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+        return 0, err
+    }
+    migrations := db.dialect.migrations
+    if latest := migrations[len(migrations)-1].version; version > latest {
+        return 0, fmt.Errorf("database schema version %d is newer than the latest migration %d", version, latest)
+    }
+    return version, nil
+}
+
+func pendingMigrations(migrations []migration, version int) []migration {
+    for i, m := range migrations {
+        if m.version > version {
+            return migrations[i:]
+        }
+    }
+    return nil
+}
+
+func applyMigration(tx *transaction, m migration) error {
+    _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TEXT NOT NULL
+    )`)
+    if err != nil {
+        return err
+    }
+
+    if m.before != nil {
+        if err := m.before(tx); err != nil {
+            return err
+        }
+    }
+
+    script, err := migrationScripts.ReadFile(m.script)
+    if err != nil {
+        return err
+    }
+    if _, err := tx.Exec(string(script)); err != nil {
+        return err
+    }
+
+    if m.after != nil {
+        if err := m.after(tx); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.Exec("INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)", m.version, m.name, time.Now().UTC().Format(time.RFC3339))
+    return err
+}
+
+// upgradeDicomTable adds the fingerprint and file columns to a dicom table created by older versions
+func upgradeDicomTable(tx *transaction) error {
+    for _, column := range []string{"sop_instance_uid", "file_hash", "file_url"} {
+        if err := addMissingColumn(tx, "dicom", column, "TEXT"); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// moveDicomAttributes fills the hierarchy tables from the attribute columns older versions kept in the
+// dicom table
+func moveDicomAttributes(tx *transaction) error {
+    legacyColumns := make([]string, len(attributeColumns))
+    for i, column := range attributeColumns {
+        legacyColumns[i] = "COALESCE(" + column[strings.Index(column, ".")+1:] + ", '')"
+    }
+
+    var found int
+    err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info('dicom') WHERE name = 'study_instance_uid'").Scan(&found)
+    if err != nil || found == 0 {
+        return err
+    }
+
+    rows, err := tx.Query(`
+        SELECT uuid, ` + strings.Join(legacyColumns, ", ") + `
+        FROM dicom
+        WHERE study_instance_uid != '' AND id NOT IN (SELECT dicom_id FROM instances)
+    `)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    moved := map[string]model.DicomAttributes{}
+    for rows.Next() {
+        var uuid string
+        var attributes model.DicomAttributes
+        if err := rows.Scan(append([]interface{}{&uuid}, attributeValues(&attributes)...)...); err != nil {
+            return err
+        }
+        moved[uuid] = attributes
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    rows.Close()
+
+    for uuid, attributes := range moved {
+        if err := setDicomAttributes(tx, uuid, attributes); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// moveTags moves the tags older versions stored once per DICOM file, each with its own copy of the
+// definition, to the tag_definitions and instance_tags tables
+func moveTags(tx *transaction) error {
+    var found int
+    err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('tags', 'dicomTags')").Scan(&found)
+    if err != nil || found < 2 {
+        return err
+    }
+
+    // Tags stored by older versions may lack the columns added since
+    for _, column := range [][2]string{{"path", "TEXT"}, {"item", "INTEGER"}, {"raw_vr", "TEXT"}, {"tag_values", "TEXT"}, {"number_value", "REAL"}, {"time_value", "TEXT"}, {"bulk_data_uri", "TEXT"}} {
+        if err := addMissingColumn(tx, "tags", column[0], column[1]); err != nil {
+            return err
+        }
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO tag_definitions (tag, vr, raw_vr, name)
+        SELECT DISTINCT COALESCE(Tag, ''), COALESCE(VR, ''), COALESCE(raw_vr, ''), COALESCE(Name, '')
+        FROM tags
+        WHERE true
+        ON CONFLICT (tag, vr, raw_vr, name) DO NOTHING
+    `)
+    if err != nil {
+        return err
+    }
+
+    _, err = tx.Exec(`
+        INSERT INTO instance_tags (dicom_id, definition_ref, path, item, value, tag_values, number_value, time_value, bulk_data_uri)
+        SELECT dicomTags.dicomId, tag_definitions.id, COALESCE(tags.path, ''), COALESCE(tags.item, 0), tags.Value,
+            tags.tag_values, tags.number_value, tags.time_value, tags.bulk_data_uri
+        FROM dicomTags
+        JOIN tags ON tags.id = dicomTags.tagId
+        JOIN tag_definitions ON tag_definitions.tag = COALESCE(tags.Tag, '') AND tag_definitions.vr = COALESCE(tags.VR, '')
+            AND tag_definitions.raw_vr = COALESCE(tags.raw_vr, '') AND tag_definitions.name = COALESCE(tags.Name, '')
+        ORDER BY tags.id
+    `)
+    if err != nil {
+        return err
+    }
+
+    for _, table := range []string{"dicomTags", "tags"} {
+        if _, err := tx.Exec("DROP TABLE " + table); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// fillTextValues sets the text_value of the tags stored before it was, from their typed values. Tags are
+// read in batches so large archives are not loaded at once
+func fillTextValues(tx *transaction) error {
+    const batchSize = 1000
+
+    var lastID int64
+    for {
+        rows, err := tx.Query(`
+            SELECT instance_tags.id, tag_definitions.raw_vr, instance_tags.tag_values
+            FROM instance_tags
+            JOIN tag_definitions ON tag_definitions.id = instance_tags.definition_ref
+            WHERE instance_tags.id > ? AND instance_tags.tag_values IS NOT NULL
+            ORDER BY instance_tags.id
+            LIMIT ?
+        `, lastID, batchSize)
+        if err != nil {
+            return err
+        }
+
+        texts := map[int64]string{}
+        for rows.Next() {
+            var rawVR, values string
+            if err := rows.Scan(&lastID, &rawVR, &values); err != nil {
+                rows.Close()
+                return err
+            }
+            texts[lastID] = model.TagText(rawVR, model.DecodeTagValues(rawVR, values))
+        }
+        if err := rows.Err(); err != nil {
+            rows.Close()
+            return err
+        }
+        rows.Close()
+
+        for id, text := range texts {
+            if _, err := tx.Exec("UPDATE instance_tags SET text_value = ? WHERE id = ?", nullIfEmpty(text), id); err != nil {
+                return err
+            }
+        }
+        if len(texts) < batchSize {
+            return nil
+        }
+    }
+}
+
+// fillBinaryValues stores the small binary values of the tags stored before they were kept base64 encoded
+// in tag_values, from their printed form like [0 1 255]. Values that do not parse are left without any
+func fillBinaryValues(tx *transaction) error {
+    const batchSize = 1000
+
+    var lastID int64
+    for {
+        rows, err := tx.Query(`
+            SELECT instance_tags.id, instance_tags.value
+            FROM instance_tags
+            JOIN tag_definitions ON tag_definitions.id = instance_tags.definition_ref
+            WHERE instance_tags.id > ? AND tag_definitions.vr = 'VRBytes' AND instance_tags.tag_values IS NULL
+                AND instance_tags.bulk_data_uri IS NULL
+            ORDER BY instance_tags.id
+            LIMIT ?
+        `, lastID, batchSize)
+        if err != nil {
+            return err
+        }
+
+        values := map[int64]string{}
+        count := 0
+        for rows.Next() {
+            var value sql.NullString
+            if err := rows.Scan(&lastID, &value); err != nil {
+                rows.Close()
+                return err
+            }
+            count++
+            if data, ok := parsePrintedBytes(value.String); ok {
+                encoded, _ := json.Marshal([]string{base64.StdEncoding.EncodeToString(data)})
+                values[lastID] = string(encoded)
+            }
+        }
+        if err := rows.Err(); err != nil {
+            rows.Close()
+            return err
+        }
+        rows.Close()
+
+        for id, value := range values {
+            if _, err := tx.Exec("UPDATE instance_tags SET tag_values = ? WHERE id = ?", value, id); err != nil {
+                return err
+            }
+        }
+        if count < batchSize {
+            return nil
+        }
+    }
+}
+
+// parsePrintedBytes parses bytes printed the way Go formats a []byte, e.g. [0 1 255]
+func parsePrintedBytes(value string) ([]byte, bool) {
+    if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+        return nil, false
+    }
+    fields := strings.Fields(value[1 : len(value)-1])
+    data := make([]byte, len(fields))
+    for i, field := range fields {
+        b, err := strconv.ParseUint(field, 10, 8)
+        if err != nil {
+            return nil, false
+        }
+        data[i] = byte(b)
+    }
+    return data, true
+}
+
+// addMissingColumn adds the column to a table created by an older version of the service. Tables that
+// do not exist are left to the migration creating them
+func addMissingColumn(tx *transaction, table string, column string, definition string) error {
+    rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    columns := 0
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            return err
+        }
+        if name == column {
+            return nil
+        }
+        columns++
+    }
+    if err := rows.Err(); err != nil {
+        return err
+    }
+    rows.Close()
+    if columns == 0 {
+        return nil
+    }
+
+    _, err = tx.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+    return err
+}
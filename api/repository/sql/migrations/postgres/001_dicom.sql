@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS dicom (
+    id BIGSERIAL PRIMARY KEY,
+    uuid TEXT UNIQUE,
+    image_url TEXT UNIQUE,
+    sop_instance_uid TEXT,
+    file_hash TEXT,
+    file_url TEXT
+);
+
+CREATE INDEX IF NOT EXISTS dicom_sop_instance_uid ON dicom (sop_instance_uid);
+CREATE INDEX IF NOT EXISTS dicom_file_hash ON dicom (file_hash);
@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS patients (
+    id BIGSERIAL PRIMARY KEY,
+    patient_id TEXT UNIQUE,
+    patient_name TEXT,
+    patient_birth_date TEXT,
+    patient_sex TEXT
+);
+
+CREATE TABLE IF NOT EXISTS studies (
+    id BIGSERIAL PRIMARY KEY,
+    study_instance_uid TEXT UNIQUE NOT NULL,
+    patient_ref BIGINT REFERENCES patients (id),
+    study_date TEXT,
+    study_time TEXT,
+    accession_number TEXT,
+    study_id TEXT,
+    study_description TEXT,
+    referring_physician_name TEXT
+);
+
+CREATE TABLE IF NOT EXISTS series (
+    id BIGSERIAL PRIMARY KEY,
+    series_instance_uid TEXT UNIQUE NOT NULL,
+    study_ref BIGINT NOT NULL REFERENCES studies (id),
+    modality TEXT,
+    series_number TEXT,
+    series_description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS instances (
+    id BIGSERIAL PRIMARY KEY,
+    dicom_id BIGINT UNIQUE NOT NULL REFERENCES dicom (id),
+    series_ref BIGINT REFERENCES series (id),
+    sop_instance_uid TEXT UNIQUE,
+    sop_class_uid TEXT,
+    instance_number TEXT
+);
+
+CREATE INDEX IF NOT EXISTS studies_patient ON studies (patient_ref);
+CREATE INDEX IF NOT EXISTS series_study ON series (study_ref);
+CREATE INDEX IF NOT EXISTS instances_series ON instances (series_ref);
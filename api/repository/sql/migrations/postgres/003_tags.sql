@@ -0,0 +1,28 @@
+-- Tags share their definition between DICOM files, only their values are stored per file
+CREATE TABLE IF NOT EXISTS tag_definitions (
+    id BIGSERIAL PRIMARY KEY,
+    tag TEXT NOT NULL,
+    vr TEXT NOT NULL,
+    raw_vr TEXT NOT NULL,
+    name TEXT NOT NULL,
+    UNIQUE (tag, vr, raw_vr, name)
+);
+
+CREATE TABLE IF NOT EXISTS instance_tags (
+    id BIGSERIAL PRIMARY KEY,
+    dicom_id BIGINT NOT NULL REFERENCES dicom (id),
+    definition_ref BIGINT NOT NULL REFERENCES tag_definitions (id),
+    path TEXT NOT NULL DEFAULT '',
+    item INTEGER NOT NULL DEFAULT 0,
+    value TEXT,
+    tag_values TEXT,
+    number_value DOUBLE PRECISION,
+    time_value TEXT,
+    bulk_data_uri TEXT
+);
+
+CREATE INDEX IF NOT EXISTS instance_tags_dicom ON instance_tags (dicom_id);
+-- Index entries are limited to a third of a page, long values are only indexed by their beginning
+CREATE INDEX IF NOT EXISTS instance_tags_value ON instance_tags (definition_ref, substr(value, 1, 256));
+CREATE INDEX IF NOT EXISTS instance_tags_number ON instance_tags (definition_ref, number_value);
+CREATE INDEX IF NOT EXISTS instance_tags_time ON instance_tags (definition_ref, time_value);
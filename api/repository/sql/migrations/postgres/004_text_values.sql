@@ -0,0 +1,6 @@
+-- Values are searched as text instead of their Go formatting in value, see fillTextValues
+ALTER TABLE instance_tags ADD COLUMN IF NOT EXISTS text_value TEXT;
+
+-- Index entries are limited to a third of a page, long values are only indexed by their beginning
+DROP INDEX IF EXISTS instance_tags_value;
+CREATE INDEX IF NOT EXISTS instance_tags_text ON instance_tags (definition_ref, substr(text_value, 1, 256));
@@ -0,0 +1,15 @@
+-- Fingerprints are only recorded once a file is fully ingested, and are unique so that copies ingested
+-- at the same time are recognized as duplicates. Copies stored before keep the fingerprint of the first
+UPDATE dicom SET sop_instance_uid = NULL
+WHERE sop_instance_uid != '' AND EXISTS (
+    SELECT 1 FROM dicom earlier WHERE earlier.sop_instance_uid = dicom.sop_instance_uid AND earlier.id < dicom.id
+);
+UPDATE dicom SET file_hash = NULL
+WHERE EXISTS (
+    SELECT 1 FROM dicom earlier WHERE earlier.file_hash = dicom.file_hash AND earlier.id < dicom.id
+);
+
+DROP INDEX IF EXISTS dicom_sop_instance_uid;
+DROP INDEX IF EXISTS dicom_file_hash;
+CREATE UNIQUE INDEX IF NOT EXISTS dicom_sop_instance_uid ON dicom (sop_instance_uid) WHERE sop_instance_uid != '';
+CREATE UNIQUE INDEX IF NOT EXISTS dicom_file_hash ON dicom (file_hash);
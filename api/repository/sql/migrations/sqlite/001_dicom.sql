@@ -0,0 +1,12 @@
+-- Databases created before migrations already have the table, see upgradeDicomTable
+CREATE TABLE IF NOT EXISTS dicom (
+    id INTEGER PRIMARY KEY,
+    uuid string TEXT UNIQUE,
+    image_url TEXT UNIQUE,
+    sop_instance_uid TEXT,
+    file_hash TEXT,
+    file_url TEXT
+);
+
+CREATE INDEX IF NOT EXISTS dicom_sop_instance_uid ON dicom (sop_instance_uid);
+CREATE INDEX IF NOT EXISTS dicom_file_hash ON dicom (file_hash);
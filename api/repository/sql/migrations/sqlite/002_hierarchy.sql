@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS patients (
+    id INTEGER PRIMARY KEY,
+    patient_id TEXT UNIQUE,
+    patient_name TEXT,
+    patient_birth_date TEXT,
+    patient_sex TEXT
+);
+
+CREATE TABLE IF NOT EXISTS studies (
+    id INTEGER PRIMARY KEY,
+    study_instance_uid TEXT UNIQUE NOT NULL,
+    patient_ref INTEGER REFERENCES patients (id),
+    study_date TEXT,
+    study_time TEXT,
+    accession_number TEXT,
+    study_id TEXT,
+    study_description TEXT,
+    referring_physician_name TEXT
+);
+
+CREATE TABLE IF NOT EXISTS series (
+    id INTEGER PRIMARY KEY,
+    series_instance_uid TEXT UNIQUE NOT NULL,
+    study_ref INTEGER NOT NULL REFERENCES studies (id),
+    modality TEXT,
+    series_number TEXT,
+    series_description TEXT
+);
+
+CREATE TABLE IF NOT EXISTS instances (
+    id INTEGER PRIMARY KEY,
+    dicom_id INTEGER UNIQUE NOT NULL REFERENCES dicom (id),
+    series_ref INTEGER REFERENCES series (id),
+    sop_instance_uid TEXT UNIQUE,
+    sop_class_uid TEXT,
+    instance_number TEXT
+);
+
+CREATE INDEX IF NOT EXISTS studies_patient ON studies (patient_ref);
+CREATE INDEX IF NOT EXISTS series_study ON series (study_ref);
+CREATE INDEX IF NOT EXISTS instances_series ON instances (series_ref);
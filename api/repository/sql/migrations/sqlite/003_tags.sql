@@ -0,0 +1,27 @@
+-- Tags share their definition between DICOM files, only their values are stored per file
+CREATE TABLE IF NOT EXISTS tag_definitions (
+    id INTEGER PRIMARY KEY,
+    tag TEXT NOT NULL,
+    vr TEXT NOT NULL,
+    raw_vr TEXT NOT NULL,
+    name TEXT NOT NULL,
+    UNIQUE (tag, vr, raw_vr, name)
+);
+
+CREATE TABLE IF NOT EXISTS instance_tags (
+    id INTEGER PRIMARY KEY,
+    dicom_id INTEGER NOT NULL REFERENCES dicom (id),
+    definition_ref INTEGER NOT NULL REFERENCES tag_definitions (id),
+    path TEXT NOT NULL DEFAULT '',
+    item INTEGER NOT NULL DEFAULT 0,
+    value TEXT,
+    tag_values TEXT,
+    number_value REAL,
+    time_value TEXT,
+    bulk_data_uri TEXT
+);
+
+CREATE INDEX IF NOT EXISTS instance_tags_dicom ON instance_tags (dicom_id);
+CREATE INDEX IF NOT EXISTS instance_tags_value ON instance_tags (definition_ref, value);
+CREATE INDEX IF NOT EXISTS instance_tags_number ON instance_tags (definition_ref, number_value);
+CREATE INDEX IF NOT EXISTS instance_tags_time ON instance_tags (definition_ref, time_value);
@@ -0,0 +1,5 @@
+-- Values are searched as text instead of their Go formatting in value, see fillTextValues
+ALTER TABLE instance_tags ADD COLUMN text_value TEXT;
+
+DROP INDEX IF EXISTS instance_tags_value;
+CREATE INDEX IF NOT EXISTS instance_tags_text ON instance_tags (definition_ref, text_value);
@@ -0,0 +1,2 @@
+-- Small binary values are kept base64 encoded in tag_values instead of only their Go formatting in
+-- value, see fillBinaryValues
@@ -0,0 +1,156 @@
+package sql
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func openTestDatabase(t *testing.T, statements ...string) *Database {
+	db, err := openConn(DriverSQLite, filepath.Join(t.TempDir(), "dicom.db"))
+	if err != nil {
+		t.Fatalf("Opening database failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, statement := range statements {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("Preparing database failed: %v", err)
+		}
+	}
+	return &Database{db: db, logger: log.New(os.Stdout, "test ", log.LstdFlags), definitions: map[tagDefinition]int64{}}
+}
+
+func TestMigrate(t *testing.T) {
+	database := openTestDatabase(t)
+	if err := database.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	// Migrating again finds nothing to apply
+	if err := database.migrate(); err != nil {
+		t.Fatalf("Second migrate failed: %v", err)
+	}
+
+	version, err := schemaVersion(database.db)
+	if err != nil {
+		t.Fatalf("schemaVersion failed: %v", err)
+	}
+	if latest := sqliteMigrations[len(sqliteMigrations)-1].version; version != latest {
+		t.Errorf("Expected schema version %d, got %d", latest, version)
+	}
+
+	var applied int
+	if err := database.db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&applied); err != nil {
+		t.Fatalf("Counting applied migrations failed: %v", err)
+	}
+	if applied != len(sqliteMigrations) {
+		t.Errorf("Expected %d applied migrations, got %d", len(sqliteMigrations), applied)
+	}
+}
+
+func TestMigrate_Unversioned(t *testing.T) {
+	// The schema of a version that kept attributes in the dicom table and a copy of every tag per file
+	legacyColumns := make([]string, len(attributeColumns))
+	for i, column := range attributeColumns {
+		legacyColumns[i] = column[strings.Index(column, ".")+1:] + " TEXT"
+	}
+	database := openTestDatabase(t,
+		"CREATE TABLE dicom (id INTEGER PRIMARY KEY, uuid string TEXT UNIQUE, image_url TEXT UNIQUE, "+strings.Join(legacyColumns, ", ")+")",
+		"CREATE TABLE dicomTags (dicomId INTEGER, tagId INTEGER)",
+		"CREATE TABLE tags (id INTEGER PRIMARY KEY, uuid TEXT UNIQUE, Tag TEXT, VR TEXT, Value TEXT, Name TEXT)",
+		"INSERT INTO dicom (id, uuid, image_url, study_instance_uid, series_instance_uid, modality) VALUES (1, 'legacy', 'output/image_legacy.png', '1.2.3', '1.2.3.4', 'CT')",
+		"INSERT INTO tags (id, uuid, Tag, VR, Value, Name) VALUES (1, 'a', '(0008,0060)', 'VRString', '[CT]', 'Modality')",
+		"INSERT INTO dicomTags (dicomId, tagId) VALUES (1, 1)",
+	)
+	if err := database.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	dicom, err := database.GetDicomByUUID("legacy")
+	if err != nil {
+		t.Fatalf("GetDicomByUUID failed: %v", err)
+	}
+	if dicom.StudyInstanceUID != "1.2.3" || dicom.SeriesInstanceUID != "1.2.3.4" || dicom.Modality != "CT" {
+		t.Errorf("Expected the legacy attributes to be moved, got %+v", dicom.DicomAttributes)
+	}
+
+	tags, err := database.GetTagsByDicomUUID("legacy")
+	if err != nil {
+		t.Fatalf("GetTagsByDicomUUID failed: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Tag != "(0008,0060)" || tags[0].Value != "[CT]" || tags[0].Name != "Modality" {
+		t.Errorf("Expected the legacy tag to be moved, got %+v", tags)
+	}
+
+	var found int
+	if err := database.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ('tags', 'dicomTags')").Scan(&found); err != nil {
+		t.Fatalf("Looking for the legacy tables failed: %v", err)
+	}
+	if found != 0 {
+		t.Errorf("Expected the legacy tag tables to be dropped, found %d", found)
+	}
+}
+
+func TestMigrate_DuplicateFingerprints(t *testing.T) {
+	// Copies ingested at the same time before fingerprints were unique
+	database := openTestDatabase(t,
+		"CREATE TABLE dicom (id INTEGER PRIMARY KEY, uuid string TEXT UNIQUE, image_url TEXT UNIQUE, sop_instance_uid TEXT, file_hash TEXT, file_url TEXT)",
+		"INSERT INTO dicom (id, uuid, image_url, sop_instance_uid, file_hash) VALUES (1, 'first', 'output/image_first.png', '1.2.3', 'hash')",
+		"INSERT INTO dicom (id, uuid, image_url, sop_instance_uid, file_hash) VALUES (2, 'same_uid', 'output/image_same_uid.png', '1.2.3', 'other_hash')",
+		"INSERT INTO dicom (id, uuid, image_url, sop_instance_uid, file_hash) VALUES (3, 'same_hash', 'output/image_same_hash.png', '', 'hash')",
+		"INSERT INTO dicom (id, uuid, image_url, sop_instance_uid, file_hash) VALUES (4, 'no_uid', 'output/image_no_uid.png', '', 'third_hash')",
+	)
+	if err := database.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	for _, fingerprint := range [][3]string{{"1.2.3", "", "first"}, {"", "hash", "first"}, {"", "other_hash", "same_uid"}, {"", "third_hash", "no_uid"}} {
+		dicom, err := database.GetDicomByFingerprint(fingerprint[0], fingerprint[1])
+		if err != nil || dicom == nil || dicom.UUID != fingerprint[2] {
+			t.Errorf("Expected %s for fingerprint %q, got %+v, %v", fingerprint[2], fingerprint[:2], dicom, err)
+		}
+	}
+}
+
+func TestMigrate_BinaryValues(t *testing.T) {
+	// Small binary values were only kept in their printed form
+	database := openTestDatabase(t,
+		"CREATE TABLE dicom (id INTEGER PRIMARY KEY, uuid string TEXT UNIQUE, image_url TEXT UNIQUE)",
+		"CREATE TABLE dicomTags (dicomId INTEGER, tagId INTEGER)",
+		"CREATE TABLE tags (id INTEGER PRIMARY KEY, uuid TEXT UNIQUE, Tag TEXT, VR TEXT, Value TEXT, Name TEXT)",
+		"INSERT INTO dicom (id, uuid, image_url) VALUES (1, 'legacy', 'output/image_legacy.png')",
+		"INSERT INTO tags (id, uuid, Tag, VR, Value, Name) VALUES (1, 'a', '(0029,0010)', 'VRBytes', '[0 1 255]', '')",
+		"INSERT INTO tags (id, uuid, Tag, VR, Value, Name) VALUES (2, 'b', '(0029,0011)', 'VRBytes', '[0 1 ...]', '')",
+		"INSERT INTO dicomTags (dicomId, tagId) VALUES (1, 1), (1, 2)",
+	)
+	if err := database.migrate(); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	tags, err := database.GetTagsByDicomUUID("legacy")
+	if err != nil {
+		t.Fatalf("GetTagsByDicomUUID failed: %v", err)
+	}
+	values := map[string][]interface{}{}
+	for _, tag := range tags {
+		values[tag.Tag] = tag.Values
+	}
+	if v := values["(0029,0010)"]; len(v) != 1 || v[0] != "AAH/" {
+		t.Errorf("Expected the binary value base64 encoded, got %v", v)
+	}
+	if v := values["(0029,0011)"]; v != nil {
+		t.Errorf("Expected no value for a truncated binary value, got %v", v)
+	}
+}
+
+func TestMigrate_NewerDatabase(t *testing.T) {
+	database := openTestDatabase(t,
+		"CREATE TABLE schema_version (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)",
+		"INSERT INTO schema_version (version, name, applied_at) VALUES (1000, 'future', '2030-01-01T00:00:00Z')",
+	)
+	if err := database.migrate(); err == nil {
+		t.Error("Expected an error migrating a database newer than the service")
+	}
+}
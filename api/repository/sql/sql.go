@@ -1,130 +1,806 @@
 package sql
 
 import (
-    "dicom/api/common"
     "dicom/api/model"
 
     "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
     "log"
-    _ "github.com/mattn/go-sqlite3"
+    "math"
+    "reflect"
+    "strings"
+    "sync"
+    "time"
 )
 
 // Repository defines the interface for the SQL repository
 type Repository interface {
     Close() error
     InsertDicom(imageURL string, uuid string) (int64, error)
-    InsertTag(tag model.Tag) (int64, error)
-    InsertDicomTag(dicomID, tagID int64) (int64, error)
+    InsertTags(dicomID int64, tags []model.Tag) error
+    SetDicomFingerprint(uuid string, sopInstanceUID string, fileHash string) error
+    SetDicomFileURL(uuid string, fileURL string) error
+    SetDicomAttributes(uuid string, attributes model.DicomAttributes) error
     GetDicomByUUID(uuid string) (*model.Dicom, error)
+    GetDicomByFingerprint(sopInstanceUID string, fileHash string) (*model.Dicom, error)
+    GetDicomInstances(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error)
+    SearchHierarchy(query model.HierarchyQuery) ([][]model.Dicom, error)
+    GetPatients() ([]model.Patient, error)
+    GetStudies(patientID string) ([]model.Study, error)
+    GetSeries(studyInstanceUID string) ([]model.Series, error)
     GetTagsByDicomUUID(uuid string) ([]model.Tag, error)
+    SearchTags(query model.TagQuery) ([]model.SearchMatch, error)
+    DeleteTagsByDicomUUID(uuid string) error
+    DeleteDicom(uuid string) error
 }
 
+// ErrDuplicateFingerprint is returned when the fingerprint being recorded is already that of another DICOM file
+var ErrDuplicateFingerprint = errors.New("DICOM fingerprint already recorded")
+
+// attributeColumns are the columns of the hierarchy tables holding model.DicomAttributes, in field order
+var attributeColumns = []string{
+    "studies.study_instance_uid",
+    "series.series_instance_uid",
+    "instances.sop_class_uid",
+    "patients.patient_id",
+    "patients.patient_name",
+    "patients.patient_birth_date",
+    "patients.patient_sex",
+    "studies.study_date",
+    "studies.study_time",
+    "studies.accession_number",
+    "studies.study_id",
+    "studies.study_description",
+    "studies.referring_physician_name",
+    "series.modality",
+    "series.series_number",
+    "series.series_description",
+    "instances.instance_number",
+}
+
+// dicomColumns are selected from dicomTables by every query returning model.Dicom, see scanDicom
+var dicomColumns = "dicom.id, dicom.uuid, COALESCE(dicom.image_url, ''), COALESCE(dicom.file_url, ''), COALESCE(dicom.sop_instance_uid, ''), COALESCE(dicom.file_hash, ''), COALESCE(" + strings.Join(attributeColumns, ", ''), COALESCE(") + ", '')"
+
+// dicomTables joins every DICOM file to its place in the patient, study, series and instance hierarchy
+const dicomTables = `dicom
+        LEFT JOIN instances ON instances.dicom_id = dicom.id
+        LEFT JOIN series ON series.id = instances.series_ref
+        LEFT JOIN studies ON studies.id = series.study_ref
+        LEFT JOIN patients ON patients.id = studies.patient_ref`
+
+// searchColumns map the fields of model.Dicom a HierarchyQuery matches on to their column
+var searchColumns = func() map[string]string {
+    columns := map[string]string{"SOPInstanceUID": "dicom.sop_instance_uid"}
+    fields := reflect.TypeOf(model.DicomAttributes{})
+    for i, column := range attributeColumns {
+        columns[fields.Field(i).Name] = column
+    }
+    return columns
+}()
+
+// hierarchyKeys identify the studies, series and instances of each level, see hierarchyKey
+var hierarchyKeys = map[model.HierarchyLevel]string{
+    model.StudyLevel:    "studies.study_instance_uid",
+    model.SeriesLevel:   "series.series_instance_uid",
+    model.InstanceLevel: "dicom.uuid",
+}
+
+// databaseFile is the SQLite database used when none is configured, relative to the working directory
+const databaseFile = "./dicom.db"
+
 type Database struct {
-    db     *sql.DB
+    db     *conn
     logger *log.Logger
+    // definitions caches the ids of the tag_definitions rows, which are never deleted
+    definitions     map[tagDefinition]int64
+    definitionsLock sync.Mutex
 }
 
-func NewSqlDatabase(logger *log.Logger) (*Database, error) {
-    db, err := sql.Open("sqlite3", "./dicom.db")
+// NewSqlDatabase opens the database of the driver, DriverSQLite or DriverPostgres, and migrates it to the
+// latest schema. An empty driver is SQLite and an empty SQLite data source ./dicom.db
+func NewSqlDatabase(driver string, dataSource string, logger *log.Logger) (*Database, error) {
+    db, err := openConn(driver, dataSource)
     if err != nil {
         logger.Printf("Error opening database: %v", err)
         return nil, err
     }
 
-    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS dicom (
-        id INTEGER PRIMARY KEY,
-        uuid string TEXT UNIQUE,
-        image_url TEXT UNIQUE
-    )`)
-    if err != nil {
-        logger.Printf("Error creating dicom table: %v", err)
+    database := &Database{db: db, logger: logger, definitions: map[tagDefinition]int64{}}
+    if err := database.migrate(); err != nil {
+        logger.Printf("Error migrating database: %v", err)
         return nil, err
     }
 
-    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS dicomTags (
-        dicomId INTEGER,
-        tagId INTEGER
-    )`)
+    return database, nil
+}
+
+func (d *Database) Close() error {
+    return d.db.Close()
+}
+
+// InsertDicom registers a DICOM file, an empty image URL is stored as NULL as image URLs are unique
+func (d *Database) InsertDicom(imageURL string, uuid string) (int64, error) {
+    var id int64
+    err := d.db.QueryRow("INSERT INTO dicom (image_url, uuid) VALUES (?, ?) RETURNING id", nullIfEmpty(imageURL), uuid).Scan(&id)
     if err != nil {
-        logger.Printf("Error creating dicomTags table: %v", err)
-        return nil, err
+        d.logger.Printf("Error inserting DICOM: %v", err)
+        return 0, err
     }
 
-    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS tags (
-        id INTEGER PRIMARY KEY,
-        uuid TEXT UNIQUE,
-        Tag TEXT,
-        VR TEXT,
-        Value TEXT,
-        Name TEXT
-    )`)
+    return id, nil
+}
 
+// InsertTags stores the tags of a DICOM file in a single transaction, replacing those stored before so
+// a file that fails to be reprocessed keeps its tags. Definitions already seen are looked up from memory
+// instead of the tag_definitions table
+func (d *Database) InsertTags(dicomID int64, tags []model.Tag) error {
+    tx, err := d.db.Begin()
     if err != nil {
-        logger.Printf("Error creating tags table: %v", err)
-        return nil, err
+        d.logger.Printf("Error starting transaction: %v", err)
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.Exec("DELETE FROM instance_tags WHERE dicom_id = ?", dicomID); err != nil {
+        d.logger.Printf("Error deleting previous tags: %v", err)
+        return err
+    }
+
+    insertDefinition, err := tx.Prepare(`
+        INSERT INTO tag_definitions (tag, vr, raw_vr, name) VALUES (?, ?, ?, ?)
+        ON CONFLICT (tag, vr, raw_vr, name) DO UPDATE SET name = excluded.name
+        RETURNING id
+    `)
+    if err != nil {
+        d.logger.Printf("Error preparing tag definition insert: %v", err)
+        return err
     }
+    defer insertDefinition.Close()
 
-    return &Database{db: db, logger: logger}, nil
+    insertTag, err := tx.Prepare(`INSERT INTO instance_tags (dicom_id, definition_ref, path, item, value, tag_values, number_value, time_value, text_value, bulk_data_uri)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+    if err != nil {
+        d.logger.Printf("Error preparing tag insert: %v", err)
+        return err
+    }
+    defer insertTag.Close()
+
+    // Definitions inserted by this transaction are only shared once it commits
+    inserted := map[tagDefinition]int64{}
+    for _, tag := range tags {
+        definition := tagDefinition{tag: tag.Tag, vr: tag.VR, rawVR: tag.RawVR, name: tag.Name}
+        definitionID, ok := d.definitionID(definition)
+        if !ok {
+            definitionID, ok = inserted[definition]
+        }
+        if !ok {
+            if err := insertDefinition.QueryRow(tag.Tag, tag.VR, tag.RawVR, tag.Name).Scan(&definitionID); err != nil {
+                d.logger.Printf("Error inserting tag definition: %v", err)
+                return err
+            }
+            inserted[definition] = definitionID
+        }
+
+        values, number, timestamp, err := encodeTagValues(tag.Values)
+        if err != nil {
+            d.logger.Printf("Error encoding tag values: %v", err)
+            return err
+        }
+        _, err = insertTag.Exec(dicomID, definitionID, tag.Path, tag.Item, tag.Value, values, number, timestamp,
+            nullIfEmpty(model.TagText(tag.RawVR, tag.Values)), nullIfEmpty(tag.BulkDataURI))
+        if err != nil {
+            d.logger.Printf("Error inserting tag: %v", err)
+            return err
+        }
+    }
+
+    if err := tx.Commit(); err != nil {
+        d.logger.Printf("Error committing tags: %v", err)
+        return err
+    }
+
+    d.definitionsLock.Lock()
+    defer d.definitionsLock.Unlock()
+    for definition, definitionID := range inserted {
+        d.definitions[definition] = definitionID
+    }
+    return nil
 }
 
+// tagDefinition is what tags of different DICOM files share, see the tag_definitions table
+type tagDefinition struct {
+    tag   string
+    vr    string
+    rawVR string
+    name  string
+}
 
-func (d *Database) Close() error {
-    return d.db.Close()
+func (d *Database) definitionID(definition tagDefinition) (int64, bool) {
+    d.definitionsLock.Lock()
+    defer d.definitionsLock.Unlock()
+    definitionID, ok := d.definitions[definition]
+    return definitionID, ok
 }
 
-func (d *Database) InsertDicom(imageURL string, uuid string) (int64, error) {
-    result, err := d.db.Exec("INSERT INTO dicom (image_url, uuid) VALUES (?, ?)", imageURL, uuid)
+// nullIfEmpty stores empty optional text as NULL
+func nullIfEmpty(value string) interface{} {
+    if value == "" {
+        return nil
+    }
+    return value
+}
+
+// encodeTagValues encodes typed values as JSON, along with the first number and the first timestamp
+// among them so tags can be compared by range. Only the first value is indexed, so a tag with several
+// values such as ImagePositionPatient is compared on its first one
+func encodeTagValues(values []interface{}) (interface{}, interface{}, interface{}, error) {
+    if len(values) == 0 {
+        return nil, nil, nil, nil
+    }
+    data, err := json.Marshal(values)
     if err != nil {
-        d.logger.Printf("Error inserting DICOM: %v", err)
-        return 0, err
+        return nil, nil, nil, err
     }
 
-    return result.LastInsertId()
+    var number, timestamp interface{}
+    switch value := values[0].(type) {
+    case int64:
+        number = value
+    case float64:
+        number = value
+    case time.Time:
+        timestamp = value.UTC().Format(model.TimestampLayout)
+    }
+    return string(data), number, timestamp, nil
 }
 
-func (d *Database) InsertTag(tag model.Tag) (int64, error) {
-    uuid := common.GenShortUUID()
+// SetDicomFingerprint records what identifies the content of a DICOM file so duplicates can be recognized,
+// on the file and on its instance. ErrDuplicateFingerprint is returned when another file has the same SOP
+// instance UID or content hash
+func (d *Database) SetDicomFingerprint(uuid string, sopInstanceUID string, fileHash string) error {
+    tx, err := d.db.Begin()
+    if err != nil {
+        d.logger.Printf("Error starting transaction: %v", err)
+        return err
+    }
+    defer tx.Rollback()
+
+    _, err = tx.Exec("UPDATE dicom SET sop_instance_uid = ?, file_hash = ? WHERE uuid = ?", sopInstanceUID, fileHash, uuid)
+    if err == nil {
+        _, err = tx.Exec("UPDATE instances SET sop_instance_uid = NULLIF(?, '') WHERE dicom_id = (SELECT id FROM dicom WHERE uuid = ?)", sopInstanceUID, uuid)
+    }
+    if err == nil {
+        err = tx.Commit()
+    }
+    if d.db.dialect.uniqueViolation(err) {
+        return ErrDuplicateFingerprint
+    }
+    if err != nil {
+        d.logger.Printf("Error setting DICOM fingerprint: %v", err)
+        return err
+    }
 
-    result, err := d.db.Exec("INSERT INTO tags (uuid, Tag, VR, Value, Name) VALUES (?, ?, ?, ?, ?)", uuid, tag.Tag, tag.VR, tag.Value, tag.Name)
+    return nil
+}
+
+// SetDicomFileURL records where the original DICOM file is stored
+func (d *Database) SetDicomFileURL(uuid string, fileURL string) error {
+    _, err := d.db.Exec("UPDATE dicom SET file_url = ? WHERE uuid = ?", fileURL, uuid)
     if err != nil {
-        d.logger.Printf("Error inserting tag: %v", err)
-        return 0, err
+        d.logger.Printf("Error setting DICOM file URL: %v", err)
+        return err
     }
 
-    return result.LastInsertId()
+    return nil
 }
 
-func (d *Database) InsertDicomTag(dicomID, tagID int64) (int64, error) {
-    result, err := d.db.Exec("INSERT INTO dicomTags (dicomId, tagId) VALUES (?, ?)", dicomID, tagID)
+// SetDicomAttributes files a DICOM file under its patient, study and series, creating them the first
+// time they are seen and updating their attributes otherwise
+func (d *Database) SetDicomAttributes(uuid string, attributes model.DicomAttributes) error {
+    tx, err := d.db.Begin()
     if err != nil {
-        d.logger.Printf("Error inserting DICOM tag: %v", err)
-        return 0, err
+        d.logger.Printf("Error starting transaction: %v", err)
+        return err
+    }
+    defer tx.Rollback()
+
+    if err := setDicomAttributes(tx, uuid, attributes); err != nil {
+        d.logger.Printf("Error setting DICOM attributes: %v", err)
+        return err
+    }
+
+    return tx.Commit()
+}
+
+// setDicomAttributes places the DICOM file in the patient, study, series and instance hierarchy
+func setDicomAttributes(tx *transaction, uuid string, attributes model.DicomAttributes) error {
+    var dicomID int64
+    if err := tx.QueryRow("SELECT id FROM dicom WHERE uuid = ?", uuid).Scan(&dicomID); err != nil {
+        return fmt.Errorf("getting DICOM by UUID: %w", err)
+    }
+
+    var seriesRef interface{}
+    if attributes.StudyInstanceUID != "" && attributes.SeriesInstanceUID != "" {
+        patientRef, err := setPatient(tx, &attributes)
+        if err != nil {
+            return fmt.Errorf("setting patient: %w", err)
+        }
+
+        studyRef, err := upsert(tx, "studies", []string{"study_instance_uid", "patient_ref", "study_date", "study_time", "accession_number", "study_id", "study_description", "referring_physician_name"},
+            attributes.StudyInstanceUID, patientRef, attributes.StudyDate, attributes.StudyTime, attributes.AccessionNumber, attributes.StudyID, attributes.StudyDescription, attributes.ReferringPhysicianName)
+        if err != nil {
+            return fmt.Errorf("setting study: %w", err)
+        }
+
+        seriesRef, err = upsert(tx, "series", []string{"series_instance_uid", "study_ref", "modality", "series_number", "series_description"},
+            attributes.SeriesInstanceUID, studyRef, attributes.Modality, attributes.SeriesNumber, attributes.SeriesDescription)
+        if err != nil {
+            return fmt.Errorf("setting series: %w", err)
+        }
     }
 
-    return result.LastInsertId()
+    var sopInstanceUID sql.NullString
+    if err := tx.QueryRow("SELECT NULLIF(sop_instance_uid, '') FROM dicom WHERE id = ?", dicomID).Scan(&sopInstanceUID); err != nil {
+        return fmt.Errorf("getting SOP instance UID: %w", err)
+    }
+    _, err := upsert(tx, "instances", []string{"dicom_id", "series_ref", "sop_instance_uid", "sop_class_uid", "instance_number"},
+        dicomID, seriesRef, sopInstanceUID, attributes.SOPClassUID, attributes.InstanceNumber)
+    if err != nil {
+        return fmt.Errorf("setting instance: %w", err)
+    }
+    return nil
+}
+
+// setPatient returns the patient of the study, nil when the file has no patient attributes. Patients
+// without an ID cannot be told apart, each study gets its own
+func setPatient(tx *transaction, attributes *model.DicomAttributes) (interface{}, error) {
+    if attributes.PatientID != "" {
+        return upsert(tx, "patients", []string{"patient_id", "patient_name", "patient_birth_date", "patient_sex"},
+            attributes.PatientID, attributes.PatientName, attributes.PatientBirthDate, attributes.PatientSex)
+    }
+    if attributes.PatientName == "" && attributes.PatientBirthDate == "" && attributes.PatientSex == "" {
+        return nil, nil
+    }
+
+    var patientRef int64
+    err := tx.QueryRow(`
+        SELECT patients.id
+        FROM studies
+        JOIN patients ON patients.id = studies.patient_ref
+        WHERE studies.study_instance_uid = ? AND patients.patient_id IS NULL
+    `, attributes.StudyInstanceUID).Scan(&patientRef)
+    if err == sql.ErrNoRows {
+        err := tx.QueryRow("INSERT INTO patients (patient_name, patient_birth_date, patient_sex) VALUES (?, ?, ?) RETURNING id",
+            attributes.PatientName, attributes.PatientBirthDate, attributes.PatientSex).Scan(&patientRef)
+        return patientRef, err
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    _, err = tx.Exec("UPDATE patients SET patient_name = ?, patient_birth_date = ?, patient_sex = ? WHERE id = ?",
+        attributes.PatientName, attributes.PatientBirthDate, attributes.PatientSex, patientRef)
+    return patientRef, err
+}
+
+// upsert inserts a row or updates the one with the same value in the first column, which must be
+// unique, and returns its id
+func upsert(tx *transaction, table string, columns []string, values ...interface{}) (int64, error) {
+    updates := make([]string, 0, len(columns)-1)
+    for _, column := range columns[1:] {
+        updates = append(updates, column+" = excluded."+column)
+    }
+
+    var id int64
+    err := tx.QueryRow(
+        "INSERT INTO "+table+" ("+strings.Join(columns, ", ")+") VALUES (?"+strings.Repeat(", ?", len(columns)-1)+")"+
+            " ON CONFLICT ("+columns[0]+") DO UPDATE SET "+strings.Join(updates, ", ")+" RETURNING id",
+        values...,
+    ).Scan(&id)
+    return id, err
 }
 
 func (d *Database) GetDicomByUUID(uuid string) (*model.Dicom, error) {
-    var dicom model.Dicom
-    row := d.db.QueryRow("SELECT id, image_url FROM dicom WHERE uuid = ?", uuid)
-    err := row.Scan(&dicom.ID, &dicom.ImageURL)
+    row := d.db.QueryRow("SELECT "+dicomColumns+" FROM "+dicomTables+" WHERE dicom.uuid = ?", uuid)
+    dicom, err := scanDicom(row)
     if err != nil {
         d.logger.Printf("Error getting DICOM by UUID: %v", err)
         return nil, err
     }
+    return dicom, nil
+}
+
+// GetDicomInstances returns every DICOM file of the study, series and SOP instance, an empty UID matches any
+func (d *Database) GetDicomInstances(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error) {
+    where := []string{"1 = 1"}
+    var args []interface{}
+    if studyInstanceUID != "" {
+        where = append(where, "studies.study_instance_uid = ?")
+        args = append(args, studyInstanceUID)
+    }
+    if seriesInstanceUID != "" {
+        where = append(where, "series.series_instance_uid = ?")
+        args = append(args, seriesInstanceUID)
+    }
+    if sopInstanceUID != "" {
+        // Spelled out so the partial index on the SOP instance UID applies
+        where = append(where, "dicom.sop_instance_uid = ? AND dicom.sop_instance_uid != ''")
+        args = append(args, sopInstanceUID)
+    }
+
+    rows, err := d.db.Query(`
+        SELECT `+dicomColumns+`
+        FROM `+dicomTables+`
+        WHERE `+strings.Join(where, " AND ")+`
+        ORDER BY dicom.id
+    `, args...)
+    if err != nil {
+        d.logger.Printf("Error getting DICOM instances: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    var instances []model.Dicom
+    for rows.Next() {
+        dicom, err := scanDicom(rows)
+        if err != nil {
+            d.logger.Printf("Error scanning DICOM row: %v", err)
+            return nil, err
+        }
+        instances = append(instances, *dicom)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over DICOM rows: %v", err)
+        return nil, err
+    }
+
+    return instances, nil
+}
+
+// SearchHierarchy returns the studies, series or instances matching the query, in the order they were
+// first stored, each with all of its instances. Files that are not part of a study are left out
+func (d *Database) SearchHierarchy(query model.HierarchyQuery) ([][]model.Dicom, error) {
+    if query.Limit <= 0 {
+        err := fmt.Errorf("invalid search limit %d", query.Limit)
+        d.logger.Printf("Error building hierarchy search: %v", err)
+        return nil, err
+    }
+    key, ok := hierarchyKeys[query.Level]
+    if !ok {
+        err := fmt.Errorf("unsupported hierarchy level %d", query.Level)
+        d.logger.Printf("Error building hierarchy search: %v", err)
+        return nil, err
+    }
+
+    where := []string{"studies.study_instance_uid IS NOT NULL"}
+    var having []string
+    var whereArgs, havingArgs []interface{}
+    for _, predicate := range query.Predicates {
+        column, ok := searchColumns[predicate.Attribute]
+        if !ok {
+            err := fmt.Errorf("unsupported attribute %s", predicate.Attribute)
+            d.logger.Printf("Error building hierarchy search: %v", err)
+            return nil, err
+        }
+        condition, args, err := d.attributeCondition(column, predicate)
+        if err != nil {
+            d.logger.Printf("Error building hierarchy search: %v", err)
+            return nil, err
+        }
+        // Attributes of the level searched or above are the same for every instance of a match, those of
+        // the levels below have to match on any of its instances
+        if columnLevel(column) <= query.Level {
+            where = append(where, condition)
+            whereArgs = append(whereArgs, args...)
+        } else {
+            having = append(having, "MAX(CASE WHEN "+condition+" THEN 1 ELSE 0 END) = 1")
+            havingArgs = append(havingArgs, args...)
+        }
+    }
+
+    havingClause := ""
+    if len(having) > 0 {
+        havingClause = "HAVING " + strings.Join(having, " AND ")
+    }
+    args := append(append(whereArgs, havingArgs...), query.Limit, query.Offset)
+
+    // The page of matches is selected first, then every instance of the matches is read
+    rows, err := d.db.Query(`
+        SELECT `+dicomColumns+`
+        FROM `+dicomTables+`
+        WHERE `+key+` IN (
+            SELECT `+key+`
+            FROM `+dicomTables+`
+            WHERE `+strings.Join(where, " AND ")+`
+            GROUP BY `+key+`
+            `+havingClause+`
+            ORDER BY MIN(dicom.id)
+            LIMIT ? OFFSET ?
+        )
+        ORDER BY dicom.id
+    `, args...)
+    if err != nil {
+        d.logger.Printf("Error searching hierarchy: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    // Matches come in the order of their first instance
+    matches := [][]model.Dicom{}
+    byKey := map[string]int{}
+    for rows.Next() {
+        dicom, err := scanDicom(rows)
+        if err != nil {
+            d.logger.Printf("Error scanning DICOM row: %v", err)
+            return nil, err
+        }
+        matchKey := hierarchyKey(dicom, query.Level)
+        i, ok := byKey[matchKey]
+        if !ok {
+            i = len(matches)
+            byKey[matchKey] = i
+            matches = append(matches, nil)
+        }
+        matches[i] = append(matches[i], *dicom)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over DICOM rows: %v", err)
+        return nil, err
+    }
+
+    return matches, nil
+}
+
+// hierarchyKey is the value of the hierarchyKeys column of the level for the DICOM file
+func hierarchyKey(dicom *model.Dicom, level model.HierarchyLevel) string {
+    switch level {
+    case model.StudyLevel:
+        return dicom.StudyInstanceUID
+    case model.SeriesLevel:
+        return dicom.SeriesInstanceUID
+    }
+    return dicom.UUID
+}
+
+// columnLevel is the level of the hierarchy a column of searchColumns belongs to
+func columnLevel(column string) model.HierarchyLevel {
+    switch table, _, _ := strings.Cut(column, "."); table {
+    case "patients", "studies":
+        return model.StudyLevel
+    case "series":
+        return model.SeriesLevel
+    }
+    return model.InstanceLevel
+}
+
+// attributeCondition is the condition on a column of searchColumns of a predicate, with its parameters
+func (d *Database) attributeCondition(column string, predicate model.AttributePredicate) (string, []interface{}, error) {
+    if len(predicate.Values) == 0 {
+        return "", nil, fmt.Errorf("no value to match %s against", predicate.Attribute)
+    }
+
+    var conditions []string
+    var args []interface{}
+    switch predicate.Match {
+    case model.AttributeEqual:
+        for _, value := range predicate.Values {
+            args = append(args, value)
+        }
+        return column + " IN (?" + strings.Repeat(", ?", len(args)-1) + ")", args, nil
+    case model.AttributeRange:
+        if len(predicate.Values) != 2 {
+            return "", nil, fmt.Errorf("range of %s needs a lower and an upper bound", predicate.Attribute)
+        }
+        from, to := predicate.Values[0], predicate.Values[1]
+        conditions = append(conditions, column+" != ''")
+        if from != "" {
+            conditions = append(conditions, column+" >= ?")
+            args = append(args, from)
+        }
+        if to != "" {
+            conditions = append(conditions, "substr("+column+", 1, ?) <= ?")
+            args = append(args, len(to), to)
+        }
+        return strings.Join(conditions, " AND "), args, nil
+    case model.AttributeWildcard:
+        like := column + " " + d.db.dialect.caseInsensitiveLike + ` ? ESCAPE '\'`
+        for _, value := range predicate.Values {
+            if predicate.IgnoreCase {
+                conditions = append(conditions, like)
+                args = append(args, likePattern(value))
+            } else {
+                condition, arg := d.db.dialect.wildcard(column, value)
+                conditions = append(conditions, condition)
+                args = append(args, arg)
+            }
+            if predicate.Fuzzy {
+                // A component starts the name or follows a separator
+                conditions = append(conditions, like)
+                args = append(args, likePattern(value)+"%")
+                for _, separator := range []string{"^", " ", "="} {
+                    conditions = append(conditions, like)
+                    args = append(args, "%"+separator+likePattern(value)+"%")
+                }
+            }
+        }
+        return "(" + strings.Join(conditions, " OR ") + ")", args, nil
+    }
+    return "", nil, fmt.Errorf("unsupported match %s for %s", predicate.Match, predicate.Attribute)
+}
+
+// GetPatients returns every patient with an ID
+func (d *Database) GetPatients() ([]model.Patient, error) {
+    rows, err := d.db.Query(`
+        SELECT id, patient_id, COALESCE(patient_name, ''), COALESCE(patient_birth_date, ''), COALESCE(patient_sex, '')
+        FROM patients
+        WHERE patient_id IS NOT NULL
+        ORDER BY id
+    `)
+    if err != nil {
+        d.logger.Printf("Error getting patients: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    var patients []model.Patient
+    for rows.Next() {
+        var patient model.Patient
+        if err := rows.Scan(&patient.ID, &patient.PatientID, &patient.PatientName, &patient.PatientBirthDate, &patient.PatientSex); err != nil {
+            d.logger.Printf("Error scanning patient row: %v", err)
+            return nil, err
+        }
+        patients = append(patients, patient)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over patient rows: %v", err)
+        return nil, err
+    }
+
+    return patients, nil
+}
+
+// GetStudies returns the studies of a patient, an empty patient ID matches any
+func (d *Database) GetStudies(patientID string) ([]model.Study, error) {
+    rows, err := d.db.Query(`
+        SELECT studies.id, studies.study_instance_uid, COALESCE(patients.patient_id, ''), COALESCE(studies.study_date, ''),
+            COALESCE(studies.study_time, ''), COALESCE(studies.accession_number, ''), COALESCE(studies.study_id, ''),
+            COALESCE(studies.study_description, ''), COALESCE(studies.referring_physician_name, '')
+        FROM studies
+        LEFT JOIN patients ON patients.id = studies.patient_ref
+        WHERE CAST(? AS TEXT) = '' OR patients.patient_id = ?
+        ORDER BY studies.id
+    `, patientID, patientID)
+    if err != nil {
+        d.logger.Printf("Error getting studies: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    var studies []model.Study
+    for rows.Next() {
+        var study model.Study
+        if err := rows.Scan(&study.ID, &study.StudyInstanceUID, &study.PatientID, &study.StudyDate, &study.StudyTime,
+            &study.AccessionNumber, &study.StudyID, &study.StudyDescription, &study.ReferringPhysicianName); err != nil {
+            d.logger.Printf("Error scanning study row: %v", err)
+            return nil, err
+        }
+        studies = append(studies, study)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over study rows: %v", err)
+        return nil, err
+    }
+
+    return studies, nil
+}
+
+// GetSeries returns the series of a study, an empty study instance UID matches any
+func (d *Database) GetSeries(studyInstanceUID string) ([]model.Series, error) {
+    rows, err := d.db.Query(`
+        SELECT series.id, series.series_instance_uid, studies.study_instance_uid, COALESCE(series.modality, ''),
+            COALESCE(series.series_number, ''), COALESCE(series.series_description, '')
+        FROM series
+        JOIN studies ON studies.id = series.study_ref
+        WHERE CAST(? AS TEXT) = '' OR studies.study_instance_uid = ?
+        ORDER BY series.id
+    `, studyInstanceUID, studyInstanceUID)
+    if err != nil {
+        d.logger.Printf("Error getting series: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    var series []model.Series
+    for rows.Next() {
+        var s model.Series
+        if err := rows.Scan(&s.ID, &s.SeriesInstanceUID, &s.StudyInstanceUID, &s.Modality, &s.SeriesNumber, &s.SeriesDescription); err != nil {
+            d.logger.Printf("Error scanning series row: %v", err)
+            return nil, err
+        }
+        series = append(series, s)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over series rows: %v", err)
+        return nil, err
+    }
+
+    return series, nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+    Scan(dest ...interface{}) error
+}
+
+// scanDicom reads a row selected with dicomColumns
+func scanDicom(row scanner) (*model.Dicom, error) {
+    var dicom model.Dicom
+    dest := []interface{}{&dicom.ID, &dicom.UUID, &dicom.ImageURL, &dicom.FileURL, &dicom.SOPInstanceUID, &dicom.FileHash}
+    for _, value := range attributeValues(&dicom.DicomAttributes) {
+        dest = append(dest, value)
+    }
+    if err := row.Scan(dest...); err != nil {
+        return nil, err
+    }
     return &dicom, nil
 }
 
+// attributeValues returns pointers to the fields of the attributes in the order of attributeColumns
+func attributeValues(attributes *model.DicomAttributes) []interface{} {
+    return []interface{}{
+        &attributes.StudyInstanceUID,
+        &attributes.SeriesInstanceUID,
+        &attributes.SOPClassUID,
+        &attributes.PatientID,
+        &attributes.PatientName,
+        &attributes.PatientBirthDate,
+        &attributes.PatientSex,
+        &attributes.StudyDate,
+        &attributes.StudyTime,
+        &attributes.AccessionNumber,
+        &attributes.StudyID,
+        &attributes.StudyDescription,
+        &attributes.ReferringPhysicianName,
+        &attributes.Modality,
+        &attributes.SeriesNumber,
+        &attributes.SeriesDescription,
+        &attributes.InstanceNumber,
+    }
+}
+
+// GetDicomByFingerprint finds a DICOM file with the same SOP instance UID or the same content hash,
+// nil is returned when there is none
+func (d *Database) GetDicomByFingerprint(sopInstanceUID string, fileHash string) (*model.Dicom, error) {
+    row := d.db.QueryRow(`
+        SELECT `+dicomColumns+`
+        FROM `+dicomTables+`
+        WHERE (dicom.sop_instance_uid = ? AND dicom.sop_instance_uid != '') OR dicom.file_hash = ?
+        ORDER BY dicom.id
+        LIMIT 1
+    `, sopInstanceUID, fileHash)
+    dicom, err := scanDicom(row)
+    if err == sql.ErrNoRows {
+        return nil, nil
+    }
+    if err != nil {
+        d.logger.Printf("Error getting DICOM by fingerprint: %v", err)
+        return nil, err
+    }
+    return dicom, nil
+}
+
 func (d *Database) GetTagsByDicomUUID(uuid string) ([]model.Tag, error) {
     var tags []model.Tag
 
     // Select all tags associated with the DICOM UUID
     query := `
-        SELECT tags.id, tags.Tag, tags.VR, tags.Value, tags.Name
-        FROM dicomTags
-        JOIN tags ON dicomTags.tagId = tags.id
-        JOIN dicom ON dicomTags.dicomId = dicom.id
-        WHERE dicom.uuid = ?
+        SELECT ` + tagColumns + `
+        FROM instance_tags
+        JOIN tag_definitions ON tag_definitions.id = instance_tags.definition_ref
+        WHERE instance_tags.dicom_id = (SELECT id FROM dicom WHERE uuid = ?)
+        ORDER BY instance_tags.id
     `
 
     rows, err := d.db.Query(query, uuid)
@@ -136,12 +812,12 @@ func (d *Database) GetTagsByDicomUUID(uuid string) ([]model.Tag, error) {
 
     // Iterate through the rows and populate the tags slice
     for rows.Next() {
-        var tag model.Tag
-        if err := rows.Scan(&tag.ID, &tag.Tag, &tag.VR, &tag.Value, &tag.Name); err != nil {
+        tag, err := scanTag(rows)
+        if err != nil {
             d.logger.Printf("Error scanning tag row: %v", err)
             return nil, err
         }
-        tags = append(tags, tag)
+        tags = append(tags, *tag)
     }
     if err := rows.Err(); err != nil {
         d.logger.Printf("Error iterating over tag rows: %v", err)
@@ -151,3 +827,204 @@ func (d *Database) GetTagsByDicomUUID(uuid string) ([]model.Tag, error) {
     return tags, nil
 }
 
+// tagColumns are selected from instance_tags joined to tag_definitions by every query returning model.Tag,
+// see scanTag
+const tagColumns = `instance_tags.id, tag_definitions.tag, tag_definitions.vr, COALESCE(instance_tags.value, ''),
+            tag_definitions.name, instance_tags.path, instance_tags.item, tag_definitions.raw_vr,
+            COALESCE(instance_tags.tag_values, ''), COALESCE(instance_tags.bulk_data_uri, '')`
+
+// scanTag reads a row selected with tagColumns
+func scanTag(row scanner) (*model.Tag, error) {
+    var tag model.Tag
+    var values string
+    if err := row.Scan(&tag.ID, &tag.Tag, &tag.VR, &tag.Value, &tag.Name, &tag.Path, &tag.Item, &tag.RawVR, &values, &tag.BulkDataURI); err != nil {
+        return nil, err
+    }
+    tag.Values = model.DecodeTagValues(tag.RawVR, values)
+    return &tag, nil
+}
+
+// SearchTags returns the DICOM files whose top level tags match every predicate of the query, in the
+// order they were stored, with their top level tags listed in query.Include
+func (d *Database) SearchTags(query model.TagQuery) ([]model.SearchMatch, error) {
+    var conditions []string
+    var args []interface{}
+    for _, predicate := range query.Predicates {
+        condition, value, err := d.tagCondition(predicate)
+        if err != nil {
+            d.logger.Printf("Error building tag search: %v", err)
+            return nil, err
+        }
+        // Each predicate selects the files with a matching tag, which the indexes on instance_tags find
+        // without going through every file
+        in := "IN"
+        if predicate.Negate {
+            in = "NOT IN"
+        }
+        conditions = append(conditions, `dicom.id `+in+` (
+            SELECT instance_tags.dicom_id
+            FROM instance_tags
+            JOIN tag_definitions ON tag_definitions.id = instance_tags.definition_ref
+            WHERE tag_definitions.tag = ? AND instance_tags.path = '' AND `+condition+`
+        )`)
+        args = append(args, predicate.Tag)
+        args = append(args, value...)
+    }
+
+    where := ""
+    if len(conditions) > 0 {
+        where = "WHERE " + strings.Join(conditions, " AND ")
+    }
+    limit := query.Limit
+    if limit <= 0 {
+        limit = math.MaxInt32
+    }
+    rows, err := d.db.Query(`
+        SELECT dicom.id, dicom.uuid
+        FROM dicom
+        `+where+`
+        ORDER BY dicom.id
+        LIMIT ? OFFSET ?
+    `, append(args, limit, query.Offset)...)
+    if err != nil {
+        d.logger.Printf("Error searching tags: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    matches := []model.SearchMatch{}
+    byID := map[int64]int{}
+    for rows.Next() {
+        var id int64
+        var match model.SearchMatch
+        if err := rows.Scan(&id, &match.ID); err != nil {
+            d.logger.Printf("Error scanning search row: %v", err)
+            return nil, err
+        }
+        byID[id] = len(matches)
+        matches = append(matches, match)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over search rows: %v", err)
+        return nil, err
+    }
+    rows.Close()
+
+    if len(matches) == 0 || len(query.Include) == 0 {
+        return matches, nil
+    }
+
+    args = args[:0]
+    for id := range byID {
+        args = append(args, id)
+    }
+    for _, t := range query.Include {
+        args = append(args, t)
+    }
+    rows, err = d.db.Query(`
+        SELECT instance_tags.dicom_id, `+tagColumns+`
+        FROM instance_tags
+        JOIN tag_definitions ON tag_definitions.id = instance_tags.definition_ref
+        WHERE instance_tags.dicom_id IN (?`+strings.Repeat(", ?", len(byID)-1)+`)
+            AND tag_definitions.tag IN (?`+strings.Repeat(", ?", len(query.Include)-1)+`)
+            AND instance_tags.path = ''
+        ORDER BY instance_tags.id
+    `, args...)
+    if err != nil {
+        d.logger.Printf("Error getting the tags of search matches: %v", err)
+        return nil, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var dicomID int64
+        var tag model.Tag
+        var values string
+        if err := rows.Scan(append([]interface{}{&dicomID}, &tag.ID, &tag.Tag, &tag.VR, &tag.Value, &tag.Name, &tag.Path, &tag.Item, &tag.RawVR, &values, &tag.BulkDataURI)...); err != nil {
+            d.logger.Printf("Error scanning tag row: %v", err)
+            return nil, err
+        }
+        tag.Values = model.DecodeTagValues(tag.RawVR, values)
+        match := &matches[byID[dicomID]]
+        match.Tags = append(match.Tags, tag)
+    }
+    if err := rows.Err(); err != nil {
+        d.logger.Printf("Error iterating over tag rows: %v", err)
+        return nil, err
+    }
+
+    return matches, nil
+}
+
+// tagCondition is the condition on instance_tags of a predicate, with its parameters
+func (d *Database) tagCondition(predicate model.TagPredicate) (string, []interface{}, error) {
+    if predicate.Operator == model.TagPresent {
+        return "1 = 1", nil, nil
+    }
+
+    var column string
+    var value interface{}
+    switch v := predicate.Value.(type) {
+    case string:
+        column, value = "instance_tags.text_value", v
+    case float64:
+        column, value = "instance_tags.number_value", v
+    case time.Time:
+        column, value = "instance_tags.time_value", v.UTC().Format(model.TimestampLayout)
+    default:
+        return "", nil, fmt.Errorf("unsupported value %v for tag %s", predicate.Value, predicate.Tag)
+    }
+
+    switch predicate.Operator {
+    case model.TagEqual:
+        if column == "instance_tags.text_value" {
+            // The beginning of the text is what PostgreSQL indexes
+            return column + " = ? AND substr(" + column + ", 1, 256) = substr(?, 1, 256)", []interface{}{value, value}, nil
+        }
+        return column + " = ?", []interface{}{value}, nil
+    case model.TagLess, model.TagLessOrEqual, model.TagGreater, model.TagGreaterOrEqual:
+        return column + " " + string(predicate.Operator) + " ?", []interface{}{value}, nil
+    case model.TagWildcard:
+        text, ok := value.(string)
+        if !ok {
+            return "", nil, fmt.Errorf("wildcard on a value that is not text for tag %s", predicate.Tag)
+        }
+        return "instance_tags.text_value " + d.db.dialect.caseInsensitiveLike + ` ? ESCAPE '\'`, []interface{}{likePattern(text)}, nil
+    }
+    return "", nil, fmt.Errorf("unsupported operator %s for tag %s", predicate.Operator, predicate.Tag)
+}
+
+// DeleteTagsByDicomUUID removes every tag stored for the DICOM file, their definitions are kept
+func (d *Database) DeleteTagsByDicomUUID(uuid string) error {
+    _, err := d.db.Exec("DELETE FROM instance_tags WHERE dicom_id IN (SELECT id FROM dicom WHERE uuid = ?)", uuid)
+    if err != nil {
+        d.logger.Printf("Error deleting tags: %v", err)
+        return err
+    }
+
+    return nil
+}
+
+// DeleteDicom removes a DICOM file with its tags and its place in the hierarchy. The patient, study and
+// series it was filed under are kept
+func (d *Database) DeleteDicom(uuid string) error {
+    tx, err := d.db.Begin()
+    if err != nil {
+        d.logger.Printf("Error starting transaction: %v", err)
+        return err
+    }
+    defer tx.Rollback()
+
+    for _, query := range []string{
+        "DELETE FROM instance_tags WHERE dicom_id IN (SELECT id FROM dicom WHERE uuid = ?)",
+        "DELETE FROM instances WHERE dicom_id IN (SELECT id FROM dicom WHERE uuid = ?)",
+        "DELETE FROM dicom WHERE uuid = ?",
+    } {
+        if _, err := tx.Exec(query, uuid); err != nil {
+            d.logger.Printf("Error deleting DICOM: %v", err)
+            return err
+        }
+    }
+
+    return tx.Commit()
+}
@@ -8,10 +8,21 @@ import (
 type MockRepository struct {
     CloseFunc          func() error
     InsertDicomFunc    func(imageURL string, uuid string) (int64, error)
-    InsertTagFunc      func(tag model.Tag) (int64, error)
-    InsertDicomTagFunc func(dicomID, tagID int64) (int64, error)
+    InsertTagsFunc     func(dicomID int64, tags []model.Tag) error
+    SetDicomFingerprintFunc func(uuid string, sopInstanceUID string, fileHash string) error
+    SetDicomFileURLFunc func(uuid string, fileURL string) error
+    SetDicomAttributesFunc func(uuid string, attributes model.DicomAttributes) error
     GetDicomByUUIDFunc func(uuid string) (*model.Dicom, error)
+    GetDicomByFingerprintFunc func(sopInstanceUID string, fileHash string) (*model.Dicom, error)
+    GetDicomInstancesFunc func(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error)
+    SearchHierarchyFunc func(query model.HierarchyQuery) ([][]model.Dicom, error)
+    GetPatientsFunc func() ([]model.Patient, error)
+    GetStudiesFunc func(patientID string) ([]model.Study, error)
+    GetSeriesFunc func(studyInstanceUID string) ([]model.Series, error)
     GetTagsByDicomUUIDFunc func(uuid string) ([]model.Tag, error)
+    SearchTagsFunc func(query model.TagQuery) ([]model.SearchMatch, error)
+    DeleteTagsByDicomUUIDFunc func(uuid string) error
+    DeleteDicomFunc func(uuid string) error
 }
 
 func (m *MockRepository) Close() error {
@@ -28,18 +39,32 @@ func (m *MockRepository) InsertDicom(imageURL string, uuid string) (int64, error
     return 0, nil
 }
 
-func (m *MockRepository) InsertTag(tag model.Tag) (int64, error) {
-    if m.InsertTagFunc != nil {
-        return m.InsertTagFunc(tag)
+func (m *MockRepository) InsertTags(dicomID int64, tags []model.Tag) error {
+    if m.InsertTagsFunc != nil {
+        return m.InsertTagsFunc(dicomID, tags)
     }
-    return 0, nil
+    return nil
 }
 
-func (m *MockRepository) InsertDicomTag(dicomID, tagID int64) (int64, error) {
-    if m.InsertDicomTagFunc != nil {
-        return m.InsertDicomTagFunc(dicomID, tagID)
+func (m *MockRepository) SetDicomFingerprint(uuid string, sopInstanceUID string, fileHash string) error {
+    if m.SetDicomFingerprintFunc != nil {
+        return m.SetDicomFingerprintFunc(uuid, sopInstanceUID, fileHash)
     }
-    return 0, nil
+    return nil
+}
+
+func (m *MockRepository) SetDicomFileURL(uuid string, fileURL string) error {
+    if m.SetDicomFileURLFunc != nil {
+        return m.SetDicomFileURLFunc(uuid, fileURL)
+    }
+    return nil
+}
+
+func (m *MockRepository) SetDicomAttributes(uuid string, attributes model.DicomAttributes) error {
+    if m.SetDicomAttributesFunc != nil {
+        return m.SetDicomAttributesFunc(uuid, attributes)
+    }
+    return nil
 }
 
 func (m *MockRepository) GetDicomByUUID(uuid string) (*model.Dicom, error) {
@@ -55,3 +80,66 @@ func (m *MockRepository) GetTagsByDicomUUID(uuid string) ([]model.Tag, error) {
     }
     return nil, nil
 }
+
+func (m *MockRepository) GetDicomByFingerprint(sopInstanceUID string, fileHash string) (*model.Dicom, error) {
+    if m.GetDicomByFingerprintFunc != nil {
+        return m.GetDicomByFingerprintFunc(sopInstanceUID, fileHash)
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) GetDicomInstances(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error) {
+    if m.GetDicomInstancesFunc != nil {
+        return m.GetDicomInstancesFunc(studyInstanceUID, seriesInstanceUID, sopInstanceUID)
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) SearchHierarchy(query model.HierarchyQuery) ([][]model.Dicom, error) {
+    if m.SearchHierarchyFunc != nil {
+        return m.SearchHierarchyFunc(query)
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) GetPatients() ([]model.Patient, error) {
+    if m.GetPatientsFunc != nil {
+        return m.GetPatientsFunc()
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) GetStudies(patientID string) ([]model.Study, error) {
+    if m.GetStudiesFunc != nil {
+        return m.GetStudiesFunc(patientID)
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) GetSeries(studyInstanceUID string) ([]model.Series, error) {
+    if m.GetSeriesFunc != nil {
+        return m.GetSeriesFunc(studyInstanceUID)
+    }
+    return nil, nil
+}
+
+func (m *MockRepository) DeleteTagsByDicomUUID(uuid string) error {
+    if m.DeleteTagsByDicomUUIDFunc != nil {
+        return m.DeleteTagsByDicomUUIDFunc(uuid)
+    }
+    return nil
+}
+
+func (m *MockRepository) DeleteDicom(uuid string) error {
+    if m.DeleteDicomFunc != nil {
+        return m.DeleteDicomFunc(uuid)
+    }
+    return nil
+}
+
+func (m *MockRepository) SearchTags(query model.TagQuery) ([]model.SearchMatch, error) {
+    if m.SearchTagsFunc != nil {
+        return m.SearchTagsFunc(query)
+    }
+    return nil, nil
+}
@@ -1,9 +1,14 @@
 package sql
 
 import (
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid" // Import the uuid package
 	"dicom/api/model"
@@ -18,7 +23,7 @@ func init() {
 	logger := log.New(os.Stdout, "test ", log.LstdFlags)
 
 	// Initialize a test database instance
-	db, err := NewSqlDatabase(logger)
+	db, err := NewSqlDatabase(DriverSQLite, "", logger)
 	if err != nil {
 		log.Fatalf("Error initializing test database: %v", err)
 	}
@@ -61,16 +66,13 @@ func TestInsertAndRetrieveTags(t *testing.T) {
 		VR:    "TestVR",
 		Value: "TestValue",
 		Name:  "TestName",
+		Path:  "(0008,1140)[1]",
+		Item:  1,
 	}
 
-	tagID, err := testDB.InsertTag(tag)
+	err = testDB.InsertTags(dicomId, []model.Tag{tag})
 	if err != nil {
-		t.Errorf("InsertTag failed: %v", err)
-	}
-
-	_, err = testDB.InsertDicomTag(dicomId, tagID)
-	if err != nil {
-		t.Errorf("InsertDicomTag failed: %v", err)
+		t.Errorf("InsertTags failed: %v", err)
 	}
 
 	tags, err := testDB.GetTagsByDicomUUID(dicomUUID)
@@ -85,10 +87,10 @@ func TestInsertAndRetrieveTags(t *testing.T) {
 
 	// Validate the retrieved tag details
 	retrievedTag := tags[0]
-	if retrievedTag.ID != tagID {
-		t.Errorf("Retrieved tag ID doesn't match: expected %d, got %d", tagID, retrievedTag.ID)
+	if retrievedTag.ID == 0 {
+		t.Errorf("Retrieved tag has no ID")
 	}
-	if retrievedTag.Tag != tag.Tag || retrievedTag.VR != tag.VR || retrievedTag.Value != tag.Value || retrievedTag.Name != tag.Name {
+	if retrievedTag.Tag != tag.Tag || retrievedTag.VR != tag.VR || retrievedTag.Value != tag.Value || retrievedTag.Name != tag.Name || retrievedTag.Path != tag.Path || retrievedTag.Item != tag.Item {
 		t.Errorf("Retrieved tag details don't match: expected %+v, got %+v", tag, retrievedTag)
 	}
 }
@@ -116,6 +118,508 @@ func TestInsertDicom_Error(t *testing.T) {
 	}
 }
 
+func TestInsertDicom_NoImage(t *testing.T) {
+	// Files whose images are rendered from their original have no image URL, however many there are
+	var uuids []string
+	for i := 0; i < 2; i++ {
+		dicomUUID := uuid.New().String()
+		if _, err := testDB.InsertDicom("", dicomUUID); err != nil {
+			t.Fatalf("InsertDicom failed: %v", err)
+		}
+		uuids = append(uuids, dicomUUID)
+	}
+
+	dicom, err := testDB.GetDicomByUUID(uuids[1])
+	if err != nil || dicom.ImageURL != "" {
+		t.Errorf("Expected no image URL, got %+v, %v", dicom, err)
+	}
+}
+
+func TestDicomFingerprint(t *testing.T) {
+	imageURL := "test4_image_url_" + uuid.New().String()
+	dicomUUID := uuid.New().String()
+	sopInstanceUID := "1.2.3." + uuid.New().String()
+	fileHash := uuid.New().String()
+
+	dicomID, err := testDB.InsertDicom(imageURL, dicomUUID)
+	if err != nil {
+		t.Errorf("InsertDicom failed: %v", err)
+	}
+	if err := testDB.SetDicomFingerprint(dicomUUID, sopInstanceUID, fileHash); err != nil {
+		t.Errorf("SetDicomFingerprint failed: %v", err)
+	}
+	if err := testDB.SetDicomFileURL(dicomUUID, "test4_file_url"); err != nil {
+		t.Errorf("SetDicomFileURL failed: %v", err)
+	}
+
+	// Either the SOP instance UID or the hash is enough to recognize the file
+	for _, fingerprint := range [][2]string{{sopInstanceUID, "other_hash"}, {"", fileHash}} {
+		dicom, err := testDB.GetDicomByFingerprint(fingerprint[0], fingerprint[1])
+		if err != nil {
+			t.Errorf("GetDicomByFingerprint failed: %v", err)
+		}
+		if dicom == nil || dicom.ID != dicomID || dicom.UUID != dicomUUID || dicom.FileURL != "test4_file_url" {
+			t.Errorf("Expected DICOM %s for fingerprint %v, got %+v", dicomUUID, fingerprint, dicom)
+		}
+	}
+
+	dicom, err := testDB.GetDicomByFingerprint("", "unknown_hash")
+	if err != nil || dicom != nil {
+		t.Errorf("Expected no DICOM for an unknown fingerprint, got %+v, %v", dicom, err)
+	}
+}
+
+func TestDeleteTagsByDicomUUID(t *testing.T) {
+	imageURL := "test5_image_url_" + uuid.New().String()
+	dicomUUID := uuid.New().String()
+
+	dicomID, err := testDB.InsertDicom(imageURL, dicomUUID)
+	if err != nil {
+		t.Errorf("InsertDicom failed: %v", err)
+	}
+	if err := testDB.InsertTags(dicomID, []model.Tag{{Tag: "TestTag", VR: "TestVR", Value: "TestValue", Name: "TestName"}}); err != nil {
+		t.Errorf("InsertTags failed: %v", err)
+	}
+
+	if err := testDB.DeleteTagsByDicomUUID(dicomUUID); err != nil {
+		t.Errorf("DeleteTagsByDicomUUID failed: %v", err)
+	}
+
+	tags, err := testDB.GetTagsByDicomUUID(dicomUUID)
+	if err != nil {
+		t.Errorf("GetTagsByDicomUUID failed: %v", err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("Expected tags to be deleted, got %d", len(tags))
+	}
+}
+
+func TestDicomFingerprint_Unique(t *testing.T) {
+	sopInstanceUID := "1.2.3." + uuid.New().String()
+	fileHash := uuid.New().String()
+
+	var dicomUUIDs []string
+	for i := 0; i < 2; i++ {
+		dicomUUID := uuid.New().String()
+		if _, err := testDB.InsertDicom("test5_image_url_"+dicomUUID, dicomUUID); err != nil {
+			t.Errorf("InsertDicom failed: %v", err)
+		}
+		dicomUUIDs = append(dicomUUIDs, dicomUUID)
+	}
+	if err := testDB.SetDicomFingerprint(dicomUUIDs[0], sopInstanceUID, fileHash); err != nil {
+		t.Errorf("SetDicomFingerprint failed: %v", err)
+	}
+
+	// A copy ingested at the same time is only caught when its fingerprint is recorded
+	for _, fingerprint := range [][2]string{{sopInstanceUID, "other_hash_" + fileHash}, {"", fileHash}} {
+		if err := testDB.SetDicomFingerprint(dicomUUIDs[1], fingerprint[0], fingerprint[1]); err != ErrDuplicateFingerprint {
+			t.Errorf("Expected ErrDuplicateFingerprint for %v, got %v", fingerprint, err)
+		}
+	}
+
+	// Files without a SOP instance UID are told apart by their hash only
+	if err := testDB.SetDicomFingerprint(dicomUUIDs[0], "", fileHash); err != nil {
+		t.Errorf("SetDicomFingerprint failed: %v", err)
+	}
+	if err := testDB.SetDicomFingerprint(dicomUUIDs[1], "", "other_hash_"+fileHash); err != nil {
+		t.Errorf("SetDicomFingerprint failed for a file without SOP instance UID: %v", err)
+	}
+}
+
+func TestInsertTags_Replaces(t *testing.T) {
+	dicomUUID := uuid.New().String()
+	dicomID, err := testDB.InsertDicom("test5_image_url_"+dicomUUID, dicomUUID)
+	if err != nil {
+		t.Errorf("InsertDicom failed: %v", err)
+	}
+
+	for _, value := range []string{"OldValue", "NewValue"} {
+		if err := testDB.InsertTags(dicomID, []model.Tag{{Tag: "TestTag", VR: "TestVR", Value: value, Name: "TestName"}}); err != nil {
+			t.Errorf("InsertTags failed: %v", err)
+		}
+	}
+
+	tags, err := testDB.GetTagsByDicomUUID(dicomUUID)
+	if err != nil {
+		t.Errorf("GetTagsByDicomUUID failed: %v", err)
+	}
+	if len(tags) != 1 || tags[0].Value != "NewValue" {
+		t.Errorf("Expected the tags to be replaced, got %+v", tags)
+	}
+}
+
+func TestDeleteDicom(t *testing.T) {
+	dicomUUID := uuid.New().String()
+	dicomID, err := testDB.InsertDicom("test5_image_url_"+dicomUUID, dicomUUID)
+	if err != nil {
+		t.Errorf("InsertDicom failed: %v", err)
+	}
+	if err := testDB.InsertTags(dicomID, []model.Tag{{Tag: "TestTag", VR: "TestVR", Value: "TestValue", Name: "TestName"}}); err != nil {
+		t.Errorf("InsertTags failed: %v", err)
+	}
+	studyInstanceUID := "1.2.3." + uuid.New().String()
+	if err := testDB.SetDicomAttributes(dicomUUID, model.DicomAttributes{StudyInstanceUID: studyInstanceUID, SeriesInstanceUID: studyInstanceUID + ".1"}); err != nil {
+		t.Errorf("SetDicomAttributes failed: %v", err)
+	}
+
+	if err := testDB.DeleteDicom(dicomUUID); err != nil {
+		t.Errorf("DeleteDicom failed: %v", err)
+	}
+
+	if _, err := testDB.GetDicomByUUID(dicomUUID); err == nil {
+		t.Error("Expected the DICOM to be deleted")
+	}
+	instances, err := testDB.GetDicomInstances(studyInstanceUID, "", "")
+	if err != nil || len(instances) != 0 {
+		t.Errorf("Expected no instances left in the study, got %+v, %v", instances, err)
+	}
+	tags, err := testDB.GetTagsByDicomUUID(dicomUUID)
+	if err != nil || len(tags) != 0 {
+		t.Errorf("Expected the tags to be deleted, got %+v, %v", tags, err)
+	}
+}
+
+func TestDicomAttributes(t *testing.T) {
+	studyInstanceUID := "1.2.3." + uuid.New().String()
+	attributes := model.DicomAttributes{
+		StudyInstanceUID:  studyInstanceUID,
+		SeriesInstanceUID: studyInstanceUID + ".1",
+		PatientName:       "DOE^JANE",
+		Modality:          "MR",
+	}
+
+	var uuids []string
+	for i := 0; i < 2; i++ {
+		dicomUUID := uuid.New().String()
+		if _, err := testDB.InsertDicom("test6_image_url_"+dicomUUID, dicomUUID); err != nil {
+			t.Errorf("InsertDicom failed: %v", err)
+		}
+		if err := testDB.SetDicomAttributes(dicomUUID, attributes); err != nil {
+			t.Errorf("SetDicomAttributes failed: %v", err)
+		}
+		uuids = append(uuids, dicomUUID)
+	}
+
+	dicom, err := testDB.GetDicomByUUID(uuids[0])
+	if err != nil {
+		t.Errorf("GetDicomByUUID failed: %v", err)
+	}
+	if dicom == nil || dicom.DicomAttributes != attributes {
+		t.Errorf("Expected attributes %+v, got %+v", attributes, dicom)
+	}
+
+	instances, err := testDB.GetDicomInstances(studyInstanceUID, "", "")
+	if err != nil {
+		t.Errorf("GetDicomInstances failed: %v", err)
+	}
+	if len(instances) != 2 || instances[0].UUID != uuids[0] || instances[1].UUID != uuids[1] {
+		t.Errorf("Expected instances %v, got %+v", uuids, instances)
+	}
+
+	instances, err = testDB.GetDicomInstances(studyInstanceUID, "unknown", "")
+	if err != nil || len(instances) != 0 {
+		t.Errorf("Expected no instances for an unknown series, got %+v, %v", instances, err)
+	}
+}
+
+func TestDicomHierarchy(t *testing.T) {
+	patientID := "patient_" + uuid.New().String()
+	studyInstanceUID := "1.2.3." + uuid.New().String()
+	series := []string{studyInstanceUID + ".1", studyInstanceUID + ".2"}
+
+	// Two series of one study, the second file brings an updated patient name
+	for i, seriesInstanceUID := range series {
+		dicomUUID := uuid.New().String()
+		if _, err := testDB.InsertDicom("test7_image_url_"+dicomUUID, dicomUUID); err != nil {
+			t.Errorf("InsertDicom failed: %v", err)
+		}
+		if err := testDB.SetDicomFingerprint(dicomUUID, seriesInstanceUID+".1", dicomUUID); err != nil {
+			t.Errorf("SetDicomFingerprint failed: %v", err)
+		}
+		attributes := model.DicomAttributes{
+			StudyInstanceUID:  studyInstanceUID,
+			SeriesInstanceUID: seriesInstanceUID,
+			PatientID:         patientID,
+			PatientName:       []string{"DOE^JANE", "DOE^JANE^M"}[i],
+			StudyDescription:  "Chest",
+			Modality:          []string{"CT", "SR"}[i],
+		}
+		if err := testDB.SetDicomAttributes(dicomUUID, attributes); err != nil {
+			t.Errorf("SetDicomAttributes failed: %v", err)
+		}
+	}
+
+	patients, err := testDB.GetPatients()
+	if err != nil {
+		t.Errorf("GetPatients failed: %v", err)
+	}
+	found := false
+	for _, patient := range patients {
+		if patient.PatientID == patientID {
+			found = patient.PatientName == "DOE^JANE^M"
+		}
+	}
+	if !found {
+		t.Errorf("Expected patient %s with the latest name, got %+v", patientID, patients)
+	}
+
+	studies, err := testDB.GetStudies(patientID)
+	if err != nil {
+		t.Errorf("GetStudies failed: %v", err)
+	}
+	if len(studies) != 1 || studies[0].StudyInstanceUID != studyInstanceUID || studies[0].StudyDescription != "Chest" {
+		t.Errorf("Expected study %s, got %+v", studyInstanceUID, studies)
+	}
+
+	seriesOfStudy, err := testDB.GetSeries(studyInstanceUID)
+	if err != nil {
+		t.Errorf("GetSeries failed: %v", err)
+	}
+	if len(seriesOfStudy) != 2 || seriesOfStudy[0].SeriesInstanceUID != series[0] || seriesOfStudy[1].Modality != "SR" {
+		t.Errorf("Expected series %v, got %+v", series, seriesOfStudy)
+	}
+
+	instances, err := testDB.GetDicomInstances(studyInstanceUID, series[1], "")
+	if err != nil {
+		t.Errorf("GetDicomInstances failed: %v", err)
+	}
+	if len(instances) != 1 || instances[0].PatientName != "DOE^JANE^M" || instances[0].SOPInstanceUID != series[1]+".1" {
+		t.Errorf("Expected one instance of series %s, got %+v", series[1], instances)
+	}
+
+	instances, err = testDB.GetDicomInstances(studyInstanceUID, "", series[0]+".1")
+	if err != nil || len(instances) != 1 || instances[0].SeriesInstanceUID != series[0] {
+		t.Errorf("Expected the instance %s.1, got %+v, %v", series[0], instances, err)
+	}
+	instances, err = testDB.GetDicomInstances(studyInstanceUID, series[1], series[0]+".1")
+	if err != nil || len(instances) != 0 {
+		t.Errorf("Expected no instance %s.1 in series %s, got %+v, %v", series[0], series[1], instances, err)
+	}
+}
+
+func TestTagValues(t *testing.T) {
+	dicomUUID := uuid.New().String()
+	dicomID, err := testDB.InsertDicom("test8_image_url_"+dicomUUID, dicomUUID)
+	if err != nil {
+		t.Errorf("InsertDicom failed: %v", err)
+	}
+
+	studyDate := time.Date(2013, 12, 9, 0, 0, 0, 0, time.UTC)
+	values := map[string][]interface{}{
+		"DA": {studyDate},
+		"PN": {model.PersonName{Family: "NAYYAR", Given: "HARSH"}, nil},
+		"DS": {0.1, 0.2},
+		"US": {int64(1184)},
+		"CS": {"ORIGINAL", "PRIMARY"},
+	}
+	var tags []model.Tag
+	for vr, tagValues := range values {
+		tags = append(tags, model.Tag{Tag: "TestTag", VR: "TestVR", Value: "TestValue", Name: "TestName", RawVR: vr, Values: tagValues})
+	}
+	if err := testDB.InsertTags(dicomID, tags); err != nil {
+		t.Errorf("InsertTags failed: %v", err)
+	}
+
+	tags, err = testDB.GetTagsByDicomUUID(dicomUUID)
+	if err != nil {
+		t.Errorf("GetTagsByDicomUUID failed: %v", err)
+	}
+	if len(tags) != len(values) {
+		t.Fatalf("Expected %d tags, got %d", len(values), len(tags))
+	}
+	for _, tag := range tags {
+		if !reflect.DeepEqual(tag.Values, values[tag.RawVR]) {
+			t.Errorf("Expected %s values %v, got %#v", tag.RawVR, values[tag.RawVR], tag.Values)
+		}
+	}
+}
+
+func TestInsertTags_SharedDefinitions(t *testing.T) {
+	tag := model.Tag{Tag: "(0008,0060)", VR: "VRString", Name: "Modality", RawVR: "CS-" + uuid.New().String()}
+	for _, modality := range []string{"CT", "MR"} {
+		dicomUUID := uuid.New().String()
+		dicomID, err := testDB.InsertDicom("test9_image_url_"+dicomUUID, dicomUUID)
+		if err != nil {
+			t.Errorf("InsertDicom failed: %v", err)
+		}
+		tag.Value = modality
+		if err := testDB.InsertTags(dicomID, []model.Tag{tag, tag}); err != nil {
+			t.Errorf("InsertTags failed: %v", err)
+		}
+
+		tags, err := testDB.GetTagsByDicomUUID(dicomUUID)
+		if err != nil {
+			t.Errorf("GetTagsByDicomUUID failed: %v", err)
+		}
+		if len(tags) != 2 || tags[0].Value != modality || tags[0].Name != tag.Name || tags[0].RawVR != tag.RawVR {
+			t.Errorf("Unexpected tags for %s: %+v", modality, tags)
+		}
+	}
+
+	var definitions int
+	if err := testDB.db.QueryRow("SELECT COUNT(*) FROM tag_definitions WHERE raw_vr = ?", tag.RawVR).Scan(&definitions); err != nil {
+		t.Fatalf("Counting tag definitions failed: %v", err)
+	}
+	if definitions != 1 {
+		t.Errorf("Expected the tags to share 1 definition, got %d", definitions)
+	}
+}
+
+func TestSearchTags(t *testing.T) {
+	// A study description unique to the test keeps files stored by other tests out of the matches
+	study := uuid.New().String()
+	files := []struct {
+		manufacturer   string
+		sliceThickness float64
+		studyDate      time.Time
+	}{
+		{"SIEMENS", 1.5, time.Date(2013, 12, 9, 0, 0, 0, 0, time.UTC)},
+		{"GE MEDICAL SYSTEMS", 3, time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"", 5, time.Date(2021, 6, 30, 0, 0, 0, 0, time.UTC)},
+	}
+	var uuids []string
+	for _, file := range files {
+		dicomUUID := uuid.New().String()
+		dicomID, err := testDB.InsertDicom("test10_image_url_"+dicomUUID, dicomUUID)
+		if err != nil {
+			t.Fatalf("InsertDicom failed: %v", err)
+		}
+		tags := []model.Tag{
+			{Tag: "(0008,0020)", VR: "VRDate", Name: "StudyDate", RawVR: "DA", Values: []interface{}{file.studyDate}},
+			{Tag: "(0008,1030)", VR: "VRString", Name: "StudyDescription", RawVR: "LO", Values: []interface{}{study}},
+			{Tag: "(0018,0050)", VR: "VRString", Name: "SliceThickness", RawVR: "DS", Values: []interface{}{file.sliceThickness}},
+			// A nested tag is not a top level one
+			{Tag: "(0008,0070)", VR: "VRString", Name: "Manufacturer", RawVR: "LO", Path: "(0008,1140)[0]", Values: []interface{}{"PHILIPS"}},
+		}
+		if file.manufacturer != "" {
+			tags = append(tags, model.Tag{Tag: "(0008,0070)", VR: "VRString", Name: "Manufacturer", RawVR: "LO", Values: []interface{}{file.manufacturer}})
+		}
+		if err := testDB.InsertTags(dicomID, tags); err != nil {
+			t.Fatalf("InsertTags failed: %v", err)
+		}
+		uuids = append(uuids, dicomUUID)
+	}
+
+	inStudy := model.TagPredicate{Tag: "(0008,1030)", Operator: model.TagEqual, Value: study}
+	tests := []struct {
+		name      string
+		predicate model.TagPredicate
+		expected  []string
+	}{
+		{"Equal", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagEqual, Value: "SIEMENS"}, uuids[:1]},
+		{"EqualIsExact", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagEqual, Value: "siemens"}, nil},
+		{"Wildcard", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagWildcard, Value: "ge*sys?ems"}, uuids[1:2]},
+		{"WildcardEscapes", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagWildcard, Value: "GE_*"}, nil},
+		{"Nested", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagEqual, Value: "PHILIPS"}, nil},
+		{"Number", model.TagPredicate{Tag: "(0018,0050)", Operator: model.TagGreaterOrEqual, Value: 3.0}, uuids[1:]},
+		{"Date", model.TagPredicate{Tag: "(0008,0020)", Operator: model.TagLess, Value: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)}, uuids[:1]},
+		{"Present", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagPresent}, uuids[:2]},
+		{"Negate", model.TagPredicate{Tag: "(0008,0070)", Operator: model.TagPresent, Negate: true}, uuids[2:]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := testDB.SearchTags(model.TagQuery{Predicates: []model.TagPredicate{inStudy, test.predicate}})
+			if err != nil {
+				t.Fatalf("SearchTags failed: %v", err)
+			}
+			var ids []string
+			for _, match := range matches {
+				ids = append(ids, match.ID)
+			}
+			if !reflect.DeepEqual(ids, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, ids)
+			}
+		})
+	}
+
+	matches, err := testDB.SearchTags(model.TagQuery{Predicates: []model.TagPredicate{inStudy}, Include: []string{"(0008,0070)"}, Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatalf("SearchTags failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].ID != uuids[1] || len(matches[0].Tags) != 1 || !reflect.DeepEqual(matches[0].Tags[0].Values, []interface{}{"GE MEDICAL SYSTEMS"}) {
+		t.Errorf("Unexpected page of matches: %+v", matches)
+	}
+}
+
+func TestSearchHierarchy(t *testing.T) {
+	// A study description unique to the test keeps files stored by other tests out of the matches
+	description := uuid.New().String()
+	studies := []string{"1.2.3." + uuid.New().String(), "1.2.3." + uuid.New().String()}
+	files := []struct {
+		study    int
+		series   string
+		modality string
+	}{
+		{0, ".1", "MR"},
+		{0, ".1", "MR"},
+		{0, ".2", "SR"},
+		{1, ".1", "CT"},
+	}
+	for _, file := range files {
+		dicomUUID := uuid.New().String()
+		if _, err := testDB.InsertDicom("test11_image_url_"+dicomUUID, dicomUUID); err != nil {
+			t.Fatalf("InsertDicom failed: %v", err)
+		}
+		attributes := model.DicomAttributes{
+			StudyInstanceUID:  studies[file.study],
+			SeriesInstanceUID: studies[file.study] + file.series,
+			PatientID:         "patient_" + studies[file.study],
+			PatientName:       []string{"DOE^JOHN", "ROE^JANE"}[file.study],
+			StudyDate:         []string{"20240115", "20240401"}[file.study],
+			StudyTime:         []string{"093015", "140000"}[file.study],
+			StudyDescription:  description,
+			Modality:          file.modality,
+		}
+		if err := testDB.SetDicomAttributes(dicomUUID, attributes); err != nil {
+			t.Fatalf("SetDicomAttributes failed: %v", err)
+		}
+	}
+
+	inTest := model.AttributePredicate{Attribute: "StudyDescription", Match: model.AttributeEqual, Values: []string{description}}
+	tests := []struct {
+		name      string
+		predicate model.AttributePredicate
+		expected  []string
+	}{
+		{"ValueList", model.AttributePredicate{Attribute: "Modality", Match: model.AttributeEqual, Values: []string{"CT", "MR"}}, studies},
+		{"LowerLevel", model.AttributePredicate{Attribute: "Modality", Match: model.AttributeEqual, Values: []string{"SR"}}, studies[:1]},
+		{"Range", model.AttributePredicate{Attribute: "StudyDate", Match: model.AttributeRange, Values: []string{"20240101", "20240301"}}, studies[:1]},
+		{"OpenRange", model.AttributePredicate{Attribute: "StudyDate", Match: model.AttributeRange, Values: []string{"20240301", ""}}, studies[1:]},
+		{"RangePrecision", model.AttributePredicate{Attribute: "StudyTime", Match: model.AttributeRange, Values: []string{"", "0930"}}, studies[:1]},
+		{"Wildcard", model.AttributePredicate{Attribute: "Modality", Match: model.AttributeWildcard, Values: []string{"M?"}}, studies[:1]},
+		{"WildcardCase", model.AttributePredicate{Attribute: "Modality", Match: model.AttributeWildcard, Values: []string{"m?"}}, nil},
+		{"IgnoreCase", model.AttributePredicate{Attribute: "PatientName", Match: model.AttributeWildcard, Values: []string{"doe*"}, IgnoreCase: true}, studies[:1]},
+		{"Fuzzy", model.AttributePredicate{Attribute: "PatientName", Match: model.AttributeWildcard, Values: []string{"jan"}, IgnoreCase: true, Fuzzy: true}, studies[1:]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := testDB.SearchHierarchy(model.HierarchyQuery{Level: model.StudyLevel, Predicates: []model.AttributePredicate{inTest, test.predicate}, Limit: 10})
+			if err != nil {
+				t.Fatalf("SearchHierarchy failed: %v", err)
+			}
+			var uids []string
+			for _, instances := range matches {
+				uids = append(uids, instances[0].StudyInstanceUID)
+			}
+			if !reflect.DeepEqual(uids, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, uids)
+			}
+		})
+	}
+
+	matches, err := testDB.SearchHierarchy(model.HierarchyQuery{Level: model.SeriesLevel, Predicates: []model.AttributePredicate{inTest}, Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("SearchHierarchy failed: %v", err)
+	}
+	if len(matches) != 2 || len(matches[0]) != 1 || matches[0][0].SeriesInstanceUID != studies[0]+".2" || matches[1][0].SeriesInstanceUID != studies[1]+".1" {
+		t.Errorf("Unexpected page of series: %+v", matches)
+	}
+
+	if _, err := testDB.SearchHierarchy(model.HierarchyQuery{Level: model.StudyLevel}); err == nil {
+		t.Error("Expected an error for a search without a limit")
+	}
+}
+
 func TestGetDicomByUUID_Error(t *testing.T) {
 	// Attempt to retrieve a DICOM with a non-existing UUID
 	_, err := testDB.GetDicomByUUID("non_existing_uuid")
@@ -131,3 +635,63 @@ func TestCloseDatabase(t *testing.T) {
 		t.Errorf("Close method failed: %v", err)
 	}
 }
+
+func TestConcurrentIngest(t *testing.T) {
+	// Workers ingesting files of the same study at once, as the job and import workers do
+	db, err := NewSqlDatabase(DriverSQLite, filepath.Join(t.TempDir(), "dicom.db"), log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("NewSqlDatabase failed: %v", err)
+	}
+	defer db.Close()
+
+	studyInstanceUID := "1.2.3." + uuid.New().String()
+	errs := make(chan error, 8*20)
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				errs <- ingestTestFile(db, studyInstanceUID)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Concurrent ingest failed: %v", err)
+		}
+	}
+	instances, err := db.GetDicomInstances(studyInstanceUID, "", "")
+	if err != nil || len(instances) != 8*20 {
+		t.Errorf("Expected %d instances, got %d, %v", 8*20, len(instances), err)
+	}
+}
+
+// ingestTestFile stores a file of the study the way an ingest does
+func ingestTestFile(db *Database, studyInstanceUID string) error {
+	dicomUUID := uuid.New().String()
+	dicomID, err := db.InsertDicom("output/image_"+dicomUUID+".png", dicomUUID)
+	if err != nil {
+		return err
+	}
+	if err := db.SetDicomFileURL(dicomUUID, "output/dicom_"+dicomUUID+".dcm"); err != nil {
+		return err
+	}
+	tags := []model.Tag{{Tag: "(0008,0060)", VR: "VRString", Name: "Modality", RawVR: "CS", Values: []interface{}{"CT"}}}
+	if err := db.InsertTags(dicomID, tags); err != nil {
+		return err
+	}
+	attributes := model.DicomAttributes{
+		StudyInstanceUID:  studyInstanceUID,
+		SeriesInstanceUID: studyInstanceUID + ".1",
+		PatientID:         "patient_" + studyInstanceUID,
+		Modality:          "CT",
+	}
+	if err := db.SetDicomAttributes(dicomUUID, attributes); err != nil {
+		return err
+	}
+	return db.SetDicomFingerprint(dicomUUID, studyInstanceUID+"."+dicomUUID, dicomUUID)
+}
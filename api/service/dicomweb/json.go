@@ -0,0 +1,268 @@
+package dicomweb
+
+import (
+    "encoding/base64"
+    "fmt"
+    "math"
+    "mime"
+    "strconv"
+    "strings"
+    "time"
+
+    "dicom/api/model"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Key formats a tag the way DICOM JSON keys attributes
+func Key(t tag.Tag) string {
+    return fmt.Sprintf("%04X%04X", t.Group, t.Element)
+}
+
+// UIAttribute builds a UI attribute, empty UIDs leave the attribute without a value
+func UIAttribute(uids ...string) model.DicomJSONAttribute {
+    return stringAttribute("UI", uids)
+}
+
+// URAttribute builds a UR attribute
+func URAttribute(url string) model.DicomJSONAttribute {
+    return stringAttribute("UR", []string{url})
+}
+
+// USAttribute builds a US attribute
+func USAttribute(values ...int) model.DicomJSONAttribute {
+    attribute := model.DicomJSONAttribute{VR: "US"}
+    for _, value := range values {
+        attribute.Value = append(attribute.Value, value)
+    }
+    return attribute
+}
+
+// ISAttribute builds an IS attribute, which DICOM JSON encodes as numbers
+func ISAttribute(values ...int) model.DicomJSONAttribute {
+    attribute := model.DicomJSONAttribute{VR: "IS"}
+    for _, value := range values {
+        attribute.Value = append(attribute.Value, value)
+    }
+    return attribute
+}
+
+// PNAttribute builds a PN attribute from names in their DICOM form, the components of each name
+// group go to the alphabetic, ideographic and phonetic representations
+func PNAttribute(names ...string) model.DicomJSONAttribute {
+    attribute := model.DicomJSONAttribute{VR: "PN"}
+    for _, name := range names {
+        if name == "" {
+            continue
+        }
+        groups := strings.SplitN(name, "=", 3)
+        for len(groups) < 3 {
+            groups = append(groups, "")
+        }
+        attribute.Value = append(attribute.Value, model.DicomJSONPersonName{
+            Alphabetic:  groups[0],
+            Ideographic: groups[1],
+            Phonetic:    groups[2],
+        })
+    }
+    return attribute
+}
+
+// SQAttribute builds a sequence of the items
+func SQAttribute(items ...model.DicomJSON) model.DicomJSONAttribute {
+    attribute := model.DicomJSONAttribute{VR: "SQ"}
+    for _, item := range items {
+        attribute.Value = append(attribute.Value, item)
+    }
+    return attribute
+}
+
+func stringAttribute(vr string, values []string) model.DicomJSONAttribute {
+    attribute := model.DicomJSONAttribute{VR: vr}
+    for _, value := range values {
+        if value != "" {
+            attribute.Value = append(attribute.Value, value)
+        }
+    }
+    return attribute
+}
+
+// DatasetJSON converts the elements of a dataset to DICOM JSON, leaving out the file meta information.
+// Pixel data and binary values larger than bulkDataThreshold bytes get a BulkDataURI below bulkDataURL, or
+// no value when bulkDataURL is empty
+func DatasetJSON(elements []*dicom.Element, bulkDataURL string, bulkDataThreshold int) model.DicomJSON {
+    result := model.DicomJSON{}
+    for _, element := range elements {
+        if element.Tag.Group == 0x0002 {
+            continue
+        }
+        result[Key(element.Tag)] = elementJSON(element, bulkDataURL, bulkDataThreshold)
+    }
+    return result
+}
+
+func elementJSON(element *dicom.Element, bulkDataURL string, bulkDataThreshold int) model.DicomJSONAttribute {
+    vr := element.RawValueRepresentation
+    if vr == "" {
+        vr = "UN"
+    }
+    bulkDataURI := ""
+    if bulkDataURL != "" {
+        bulkDataURI = bulkDataURL + "/" + Key(element.Tag)
+    }
+
+    switch value := element.Value.GetValue().(type) {
+    case []string:
+        switch vr {
+        case "PN":
+            return PNAttribute(value...)
+        case "IS", "DS":
+            attribute := model.DicomJSONAttribute{VR: vr}
+            for _, number := range value {
+                number = strings.TrimSpace(number)
+                if parsed, err := strconv.ParseFloat(number, 64); err == nil && !math.IsInf(parsed, 0) && !math.IsNaN(parsed) {
+                    attribute.Value = append(attribute.Value, parsed)
+                } else if number != "" {
+                    attribute.Value = append(attribute.Value, number)
+                }
+            }
+            return attribute
+        }
+        trimmed := make([]string, 0, len(value))
+        for _, s := range value {
+            trimmed = append(trimmed, strings.TrimRight(s, " \x00"))
+        }
+        return stringAttribute(vr, trimmed)
+    case []int:
+        attribute := model.DicomJSONAttribute{VR: vr}
+        for _, number := range value {
+            if vr == "AT" {
+                attribute.Value = append(attribute.Value, fmt.Sprintf("%08X", uint32(number)))
+            } else {
+                attribute.Value = append(attribute.Value, number)
+            }
+        }
+        return attribute
+    case []float64:
+        attribute := model.DicomJSONAttribute{VR: vr}
+        for _, number := range value {
+            // JSON has no representation for these
+            if math.IsInf(number, 0) || math.IsNaN(number) {
+                attribute.Value = append(attribute.Value, nil)
+            } else {
+                attribute.Value = append(attribute.Value, number)
+            }
+        }
+        return attribute
+    case []byte:
+        if len(value) > bulkDataThreshold {
+            return model.DicomJSONAttribute{VR: vr, BulkDataURI: bulkDataURI}
+        }
+        return model.DicomJSONAttribute{VR: vr, InlineBinary: base64.StdEncoding.EncodeToString(value)}
+    case []*dicom.SequenceItemValue:
+        items := make([]model.DicomJSON, 0, len(value))
+        for _, item := range value {
+            // Bulkdata is only linked for attributes of the top level
+            elements, _ := item.GetValue().([]*dicom.Element)
+            items = append(items, DatasetJSON(elements, "", bulkDataThreshold))
+        }
+        return SQAttribute(items...)
+    case dicom.PixelDataInfo:
+        return model.DicomJSONAttribute{VR: vr, BulkDataURI: bulkDataURI}
+    }
+
+    return model.DicomJSONAttribute{VR: vr}
+}
+
+// TagsJSON converts the tags stored for a DICOM file to DICOM JSON, leaving out the file meta information.
+// The items of sequences are nested from the Path of their tags, and bulk data is linked below baseURL
+func TagsJSON(tags []model.Tag, baseURL string) model.DicomJSON {
+    byPath := make(map[string][]model.Tag)
+    for _, t := range tags {
+        byPath[t.Path] = append(byPath[t.Path], t)
+    }
+    return tagsJSON(byPath, "", baseURL)
+}
+
+func tagsJSON(byPath map[string][]model.Tag, path string, baseURL string) model.DicomJSON {
+    result := model.DicomJSON{}
+    for _, t := range byPath[path] {
+        key := strings.NewReplacer("(", "", ",", "", ")", "").Replace(t.Tag)
+        parsed, err := ParseTag(key)
+        if err != nil || (path == "" && parsed.Group == 0x0002) {
+            continue
+        }
+        result[Key(parsed)] = tagJSON(byPath, path, t, baseURL)
+    }
+    return result
+}
+
+func tagJSON(byPath map[string][]model.Tag, path string, t model.Tag, baseURL string) model.DicomJSONAttribute {
+    vr := t.RawVR
+    if vr == "" {
+        vr = "UN"
+    }
+
+    switch {
+    case t.BulkDataURI != "":
+        return model.DicomJSONAttribute{VR: vr, BulkDataURI: baseURL + t.BulkDataURI}
+    case vr == "SQ":
+        count, _ := strconv.Atoi(t.Value)
+        items := make([]model.DicomJSON, 0, count)
+        for i := 0; i < count; i++ {
+            items = append(items, tagsJSON(byPath, model.ItemPath(path, t.Tag, i), baseURL))
+        }
+        return SQAttribute(items...)
+    case model.BinaryVR(vr):
+        // Binary values small enough to be stored with the tags are base64 encoded, see the processor
+        attribute := model.DicomJSONAttribute{VR: vr}
+        if len(t.Values) == 1 {
+            attribute.InlineBinary, _ = t.Values[0].(string)
+        }
+        return attribute
+    case vr == "PN":
+        names := make([]string, 0, len(t.Values))
+        for _, value := range t.Values {
+            switch name := value.(type) {
+            case model.PersonName:
+                names = append(names, name.String())
+            case string:
+                names = append(names, name)
+            }
+        }
+        return PNAttribute(names...)
+    }
+
+    attribute := model.DicomJSONAttribute{VR: vr}
+    empty := true
+    for _, value := range t.Values {
+        switch v := value.(type) {
+        case time.Time:
+            value = model.TagText(vr, []interface{}{v})
+        case int64:
+            if vr == "AT" {
+                value = fmt.Sprintf("%08X", uint32(v))
+            }
+        case float64:
+            // JSON has no representation for these
+            if math.IsInf(v, 0) || math.IsNaN(v) {
+                value = nil
+            }
+        }
+        // Empty values of multi-valued attributes are null
+        if value != nil {
+            empty = false
+        }
+        attribute.Value = append(attribute.Value, value)
+    }
+    if empty {
+        attribute.Value = nil
+    }
+    return attribute
+}
+
+func isDicomMediaType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    return err == nil && mediaType == "application/dicom"
+}
@@ -0,0 +1,71 @@
+package dicomweb
+
+import (
+    "reflect"
+    "testing"
+    "time"
+
+    "dicom/api/model"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+func TestTagsJSON(t *testing.T) {
+    tags := []model.Tag{
+        {Tag: "(0002,0010)", VR: "VRString", RawVR: "UI", Value: "[1.2.840.10008.1.2.1]", Values: []interface{}{"1.2.840.10008.1.2.1"}},
+        {Tag: "(0008,0020)", VR: "VRDate", RawVR: "DA", Value: "[20240102]", Values: []interface{}{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}},
+        {Tag: "(0010,0010)", VR: "VRPersonName", RawVR: "PN", Value: "[Doe^John]", Values: []interface{}{model.ParsePersonName("Doe^John")}},
+        {Tag: "(0018,0050)", VR: "VRStringList", RawVR: "DS", Value: "[2.5]", Values: []interface{}{2.5}},
+        {Tag: "(0020,0013)", VR: "VRStringList", RawVR: "IS", Value: "[7]", Values: []interface{}{int64(7)}},
+        {Tag: "(0028,0002)", VR: "VRUInt16List", RawVR: "US", Value: "[1]", Values: []interface{}{int64(1)}},
+        {Tag: "(0029,0010)", VR: "VRBytes", RawVR: "OB", Value: "[0 1 255]", Values: []interface{}{"AAH/"}},
+        {Tag: "(0008,1140)", VR: "VRSequence", RawVR: "SQ", Value: "2"},
+        {Tag: "(0008,1155)", VR: "VRString", RawVR: "UI", Value: "[1.2.3]", Values: []interface{}{"1.2.3"}, Path: "(0008,1140)[0]"},
+        {Tag: "(0008,1155)", VR: "VRString", RawVR: "UI", Value: "[4.5.6]", Values: []interface{}{"4.5.6"}, Path: "(0008,1140)[1]"},
+        {Tag: "(7FE0,0010)", VR: "VRPixelData", RawVR: "OW", BulkDataURI: "/dicom/mock_uuid/bulkdata/7FE00010"},
+    }
+
+    result := TagsJSON(tags, "http://localhost")
+
+    if _, ok := result[Key(tag.TransferSyntaxUID)]; ok {
+        t.Error("Expected the file meta information to be left out")
+    }
+    expected := map[tag.Tag]model.DicomJSONAttribute{
+        tag.StudyDate:              {VR: "DA", Value: []interface{}{"20240102"}},
+        tag.PatientName:            PNAttribute("Doe^John"),
+        tag.SliceThickness:         {VR: "DS", Value: []interface{}{2.5}},
+        tag.InstanceNumber:         {VR: "IS", Value: []interface{}{int64(7)}},
+        tag.SamplesPerPixel:        {VR: "US", Value: []interface{}{int64(1)}},
+        {Group: 0x0029, Element: 0x0010}: {VR: "OB", InlineBinary: "AAH/"},
+        tag.ReferencedImageSequence: SQAttribute(
+            model.DicomJSON{Key(tag.ReferencedSOPInstanceUID): UIAttribute("1.2.3")},
+            model.DicomJSON{Key(tag.ReferencedSOPInstanceUID): UIAttribute("4.5.6")},
+        ),
+        tag.PixelData: {VR: "OW", BulkDataURI: "http://localhost/dicom/mock_uuid/bulkdata/7FE00010"},
+    }
+    if len(result) != len(expected) {
+        t.Errorf("Expected %d attributes, got %d", len(expected), len(result))
+    }
+    for tg, attribute := range expected {
+        if actual := result[Key(tg)]; !reflect.DeepEqual(actual, attribute) {
+            t.Errorf("Expected %s to be %+v, got %+v", Key(tg), attribute, actual)
+        }
+    }
+}
+
+func TestDatasetJSON_BulkDataThreshold(t *testing.T) {
+    element, err := dicom.NewElement(tag.ICCProfile, []byte{0, 1, 255})
+    if err != nil {
+        t.Fatalf("Error creating element: %v", err)
+    }
+
+    inline := DatasetJSON([]*dicom.Element{element}, "http://localhost/bulkdata", 3)
+    if attribute := inline[Key(tag.ICCProfile)]; attribute.InlineBinary != "AAH/" || attribute.BulkDataURI != "" {
+        t.Errorf("Expected the value inlined up to the threshold, got %+v", attribute)
+    }
+    linked := DatasetJSON([]*dicom.Element{element}, "http://localhost/bulkdata", 2)
+    if attribute := linked[Key(tag.ICCProfile)]; attribute.InlineBinary != "" || attribute.BulkDataURI != "http://localhost/bulkdata/00282000" {
+        t.Errorf("Expected the value linked over the threshold, got %+v", attribute)
+    }
+}
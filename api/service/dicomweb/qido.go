@@ -0,0 +1,329 @@
+package dicomweb
+
+import (
+    "errors"
+    "fmt"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+
+    "dicom/api/model"
+
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// QueryLevel is the level of the DICOM information model a search returns
+type QueryLevel = model.HierarchyLevel
+
+const (
+    StudyLevel    = model.StudyLevel
+    SeriesLevel   = model.SeriesLevel
+    InstanceLevel = model.InstanceLevel
+)
+
+const (
+    // DefaultQueryLimit is the number of matches returned when a search does not set a limit
+    DefaultQueryLimit = 100
+    // MaxQueryLimit is the largest number of matches a search returns at once, larger limits are lowered
+    // to it
+    MaxQueryLimit = 1000
+)
+
+// ErrInvalidQuery is returned for a search with malformed parameters
+var ErrInvalidQuery = errors.New("invalid query")
+
+// queryAttribute is an attribute a search can match on and return
+type queryAttribute struct {
+    Keyword string
+    Tag     tag.Tag
+    VR      string
+    Level   QueryLevel
+    // Default attributes are returned without being asked for in includefield
+    Default bool
+    Value   func(*model.Dicom) string
+}
+
+// modalitiesInStudy is matched against the modality of every instance of the study
+var modalitiesInStudy = &queryAttribute{"ModalitiesInStudy", tag.ModalitiesInStudy, "CS", StudyLevel, true, func(d *model.Dicom) string { return d.Modality }}
+
+// field names the field of model.Dicom the attribute is searched on, its keyword but for
+// ModalitiesInStudy
+func (a *queryAttribute) field() string {
+    if a == modalitiesInStudy {
+        return "Modality"
+    }
+    return a.Keyword
+}
+
+var seriesInstanceUID = &queryAttribute{"SeriesInstanceUID", tag.SeriesInstanceUID, "UI", SeriesLevel, true, func(d *model.Dicom) string { return d.SeriesInstanceUID }}
+
+var queryAttributes = []*queryAttribute{
+    {"StudyDate", tag.StudyDate, "DA", StudyLevel, true, func(d *model.Dicom) string { return d.StudyDate }},
+    {"StudyTime", tag.StudyTime, "TM", StudyLevel, true, func(d *model.Dicom) string { return d.StudyTime }},
+    {"AccessionNumber", tag.AccessionNumber, "SH", StudyLevel, true, func(d *model.Dicom) string { return d.AccessionNumber }},
+    modalitiesInStudy,
+    {"ReferringPhysicianName", tag.ReferringPhysicianName, "PN", StudyLevel, true, func(d *model.Dicom) string { return d.ReferringPhysicianName }},
+    {"PatientName", tag.PatientName, "PN", StudyLevel, true, func(d *model.Dicom) string { return d.PatientName }},
+    {"PatientID", tag.PatientID, "LO", StudyLevel, true, func(d *model.Dicom) string { return d.PatientID }},
+    {"PatientBirthDate", tag.PatientBirthDate, "DA", StudyLevel, true, func(d *model.Dicom) string { return d.PatientBirthDate }},
+    {"PatientSex", tag.PatientSex, "CS", StudyLevel, true, func(d *model.Dicom) string { return d.PatientSex }},
+    {"StudyInstanceUID", tag.StudyInstanceUID, "UI", StudyLevel, true, func(d *model.Dicom) string { return d.StudyInstanceUID }},
+    {"StudyID", tag.StudyID, "SH", StudyLevel, true, func(d *model.Dicom) string { return d.StudyID }},
+    {"StudyDescription", tag.StudyDescription, "LO", StudyLevel, false, func(d *model.Dicom) string { return d.StudyDescription }},
+    {"Modality", tag.Modality, "CS", SeriesLevel, true, func(d *model.Dicom) string { return d.Modality }},
+    seriesInstanceUID,
+    {"SeriesNumber", tag.SeriesNumber, "IS", SeriesLevel, true, func(d *model.Dicom) string { return d.SeriesNumber }},
+    {"SeriesDescription", tag.SeriesDescription, "LO", SeriesLevel, false, func(d *model.Dicom) string { return d.SeriesDescription }},
+    {"SOPClassUID", tag.SOPClassUID, "UI", InstanceLevel, true, func(d *model.Dicom) string { return d.SOPClassUID }},
+    {"SOPInstanceUID", tag.SOPInstanceUID, "UI", InstanceLevel, true, func(d *model.Dicom) string { return d.SOPInstanceUID }},
+    {"InstanceNumber", tag.InstanceNumber, "IS", InstanceLevel, true, func(d *model.Dicom) string { return d.InstanceNumber }},
+}
+
+// Query is a QIDO-RS search. StudyInstanceUID and SeriesInstanceUID come from the path and restrict the
+// search to one study or series
+type Query struct {
+    Level             QueryLevel
+    StudyInstanceUID  string
+    SeriesInstanceUID string
+    Params            url.Values
+}
+
+// SearchResult holds the matches of a search and warnings about the parts of the query that were ignored
+type SearchResult struct {
+    Matches  []model.DicomJSON
+    Warnings []string
+}
+
+// group is a study, series or instance matched by a search, with all the instances it contains
+type group struct {
+    instances []model.Dicom
+}
+
+// Search runs a QIDO-RS search, results link to where they can be retrieved below baseURL
+func (w *DicomWeb) Search(query Query, baseURL string) (*SearchResult, error) {
+    result := &SearchResult{Matches: []model.DicomJSON{}}
+
+    limit, offset, err := pagination(query.Params)
+    if err != nil {
+        return nil, err
+    }
+    if limit > MaxQueryLimit {
+        result.Warnings = append(result.Warnings, fmt.Sprintf("Limit lowered to %d", MaxQueryLimit))
+        limit = MaxQueryLimit
+    }
+    fuzzy := strings.EqualFold(query.Params.Get("fuzzymatching"), "true")
+
+    var predicates []model.AttributePredicate
+    included := map[*queryAttribute]bool{}
+    keys := make([]string, 0, len(query.Params))
+    for key := range query.Params {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        values := query.Params[key]
+        switch key {
+        case "limit", "offset", "fuzzymatching":
+            if len(values) > 1 {
+                result.Warnings = append(result.Warnings, "Only the first value of "+key+" is used")
+            }
+            continue
+        case "includefield":
+            for _, value := range values {
+                for _, field := range strings.Split(value, ",") {
+                    field = strings.TrimSpace(field)
+                    if field == "all" {
+                        for _, attribute := range queryAttributes {
+                            included[attribute] = true
+                        }
+                        continue
+                    }
+                    attribute := findQueryAttribute(field)
+                    if attribute == nil {
+                        result.Warnings = append(result.Warnings, "Unsupported include field "+field)
+                        continue
+                    }
+                    included[attribute] = true
+                }
+            }
+            continue
+        }
+
+        attribute := findQueryAttribute(key)
+        if attribute == nil {
+            result.Warnings = append(result.Warnings, "Unsupported query attribute "+key)
+            continue
+        }
+        if len(values) > 1 {
+            result.Warnings = append(result.Warnings, "Only the first value of "+key+" is used")
+        }
+        if predicate, ok := attributePredicate(attribute, values[0], fuzzy); ok {
+            predicates = append(predicates, predicate)
+        }
+        // Matched attributes are returned, unless they belong to a level below the one searched
+        if attribute.Level <= query.Level {
+            included[attribute] = true
+        }
+    }
+
+    if query.StudyInstanceUID != "" {
+        predicates = append(predicates, model.AttributePredicate{Attribute: "StudyInstanceUID", Match: model.AttributeEqual, Values: []string{query.StudyInstanceUID}})
+    }
+    if query.SeriesInstanceUID != "" {
+        predicates = append(predicates, model.AttributePredicate{Attribute: "SeriesInstanceUID", Match: model.AttributeEqual, Values: []string{query.SeriesInstanceUID}})
+    }
+
+    // One more match is asked for to tell whether the results are complete
+    matched, err := w.sql.SearchHierarchy(model.HierarchyQuery{Level: query.Level, Predicates: predicates, Limit: limit + 1, Offset: offset})
+    if err != nil {
+        w.logger.Printf("Error searching DICOM instances: %v", err)
+        return nil, err
+    }
+    if len(matched) > limit {
+        matched = matched[:limit]
+        result.Warnings = append(result.Warnings, fmt.Sprintf("More than %d matches, the next ones start at offset %d", limit, offset+limit))
+    }
+
+    for _, instances := range matched {
+        g := &group{instances: instances}
+        result.Matches = append(result.Matches, g.response(query, included, baseURL))
+    }
+
+    return result, nil
+}
+
+// attributePredicate turns the value of a query parameter into a predicate following the matching
+// rules of PS3.4 section C.2.2.2. It returns false for universal matching
+func attributePredicate(attribute *queryAttribute, value string, fuzzy bool) (model.AttributePredicate, bool) {
+    predicate := model.AttributePredicate{Attribute: attribute.field(), Match: model.AttributeEqual, Values: []string{value}}
+    if value == "" || value == "*" {
+        return predicate, false
+    }
+
+    switch attribute.VR {
+    case "UI":
+        // List of UID matching
+        predicate.Values = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\\' })
+        return predicate, len(predicate.Values) > 0
+    case "DA", "TM":
+        if from, to, ok := strings.Cut(value, "-"); ok {
+            predicate.Match, predicate.Values = model.AttributeRange, []string{from, to}
+        }
+        return predicate, true
+    case "PN":
+        predicate.Match, predicate.IgnoreCase, predicate.Fuzzy = model.AttributeWildcard, true, fuzzy
+        return predicate, true
+    case "CS":
+        // Code strings take a list of values, e.g. CT\MR
+        predicate.Values = strings.Split(value, `\`)
+    }
+
+    for _, v := range predicate.Values {
+        if strings.ContainsAny(v, "*?") {
+            predicate.Match = model.AttributeWildcard
+        }
+    }
+    return predicate, true
+}
+
+// pagination reads the limit and offset of a search, the limit is DefaultQueryLimit when not set
+func pagination(params url.Values) (int, int, error) {
+    parse := func(name string) (int, error) {
+        value := params.Get(name)
+        if value == "" {
+            return 0, nil
+        }
+        number, err := strconv.Atoi(value)
+        if err != nil || number < 0 {
+            return 0, fmt.Errorf("%w: %s must be a non-negative integer", ErrInvalidQuery, name)
+        }
+        return number, nil
+    }
+
+    limit, err := parse("limit")
+    if err != nil {
+        return 0, 0, err
+    }
+    if params.Get("limit") == "" {
+        limit = DefaultQueryLimit
+    } else if limit == 0 {
+        return 0, 0, fmt.Errorf("%w: limit must be a positive integer", ErrInvalidQuery)
+    }
+    offset, err := parse("offset")
+    if err != nil {
+        return 0, 0, err
+    }
+    return limit, offset, nil
+}
+
+// findQueryAttribute looks an attribute up by keyword or by its tag written as 8 hex digits
+func findQueryAttribute(key string) *queryAttribute {
+    for _, attribute := range queryAttributes {
+        if attribute.Keyword == key || strings.EqualFold(Key(attribute.Tag), key) {
+            return attribute
+        }
+    }
+    return nil
+}
+
+func (g *group) response(query Query, included map[*queryAttribute]bool, baseURL string) model.DicomJSON {
+    first := &g.instances[0]
+    response := model.DicomJSON{}
+
+    for _, attribute := range queryAttributes {
+        // Attributes of the levels above are returned when the search spans several of them
+        returned := attribute.Level == query.Level ||
+            attribute.Level == StudyLevel && query.StudyInstanceUID == "" ||
+            attribute.Level == SeriesLevel && query.Level == InstanceLevel && query.SeriesInstanceUID == ""
+        if !included[attribute] && !(attribute.Default && returned) {
+            continue
+        }
+        if attribute == modalitiesInStudy {
+            response[Key(attribute.Tag)] = stringAttribute(attribute.VR, g.distinct(attribute))
+            continue
+        }
+        response[Key(attribute.Tag)] = queryValueAttribute(attribute.VR, attribute.Value(first))
+    }
+
+    switch query.Level {
+    case StudyLevel:
+        response[Key(tag.NumberOfStudyRelatedSeries)] = ISAttribute(len(g.distinct(seriesInstanceUID)))
+        response[Key(tag.NumberOfStudyRelatedInstances)] = ISAttribute(len(g.instances))
+        response[Key(retrieveURLTag)] = URAttribute(baseURL + "/studies/" + first.StudyInstanceUID)
+    case SeriesLevel:
+        response[Key(tag.NumberOfSeriesRelatedInstances)] = ISAttribute(len(g.instances))
+        response[Key(retrieveURLTag)] = URAttribute(baseURL + "/studies/" + first.StudyInstanceUID + "/series/" + first.SeriesInstanceUID)
+    case InstanceLevel:
+        response[Key(retrieveURLTag)] = URAttribute(InstanceURL(baseURL, first.StudyInstanceUID, first.SeriesInstanceUID, first.SOPInstanceUID))
+    }
+
+    return response
+}
+
+// distinct returns the different values the attribute takes in the group
+func (g *group) distinct(attribute *queryAttribute) []string {
+    var values []string
+    seen := map[string]bool{}
+    for i := range g.instances {
+        value := attribute.Value(&g.instances[i])
+        if value != "" && !seen[value] {
+            seen[value] = true
+            values = append(values, value)
+        }
+    }
+    return values
+}
+
+func queryValueAttribute(vr string, value string) model.DicomJSONAttribute {
+    switch vr {
+    case "PN":
+        return PNAttribute(value)
+    case "IS":
+        if number, err := strconv.Atoi(value); err == nil {
+            return ISAttribute(number)
+        }
+    }
+    return stringAttribute(vr, []string{value})
+}
@@ -0,0 +1,200 @@
+package dicomweb
+
+import (
+    "errors"
+    "log"
+    "net/url"
+    "reflect"
+    "testing"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+var testInstances = []model.Dicom{
+    {UUID: "a", SOPInstanceUID: "1.1.1.1", DicomAttributes: model.DicomAttributes{StudyInstanceUID: "1.1", SeriesInstanceUID: "1.1.1", PatientID: "P1", PatientName: "DOE^JOHN", StudyDate: "20240115", Modality: "MR", InstanceNumber: "1"}},
+    {UUID: "b", SOPInstanceUID: "1.1.1.2", DicomAttributes: model.DicomAttributes{StudyInstanceUID: "1.1", SeriesInstanceUID: "1.1.1", PatientID: "P1", PatientName: "DOE^JOHN", StudyDate: "20240115", Modality: "MR", InstanceNumber: "2"}},
+    {UUID: "c", SOPInstanceUID: "1.1.2.1", DicomAttributes: model.DicomAttributes{StudyInstanceUID: "1.1", SeriesInstanceUID: "1.1.2", PatientID: "P1", PatientName: "DOE^JOHN", StudyDate: "20240115", Modality: "SR", InstanceNumber: "1"}},
+    {UUID: "d", SOPInstanceUID: "2.1.1.1", DicomAttributes: model.DicomAttributes{StudyInstanceUID: "2.1", SeriesInstanceUID: "2.1.1", PatientID: "P2", PatientName: "ROE^JANE", StudyDate: "20240401", Modality: "CT", InstanceNumber: "1"}},
+}
+
+// newSearchDicomWeb searches testInstances, the matching is left to the database so every study, series
+// or instance matches up to the limit. The query the database received is kept in received
+func newSearchDicomWeb(received *model.HierarchyQuery) *DicomWeb {
+    mockSQLRepo := &sql.MockRepository{
+        SearchHierarchyFunc: func(query model.HierarchyQuery) ([][]model.Dicom, error) {
+            *received = query
+            var matches [][]model.Dicom
+            byKey := map[string]int{}
+            for _, instance := range testInstances {
+                key := instance.StudyInstanceUID
+                switch query.Level {
+                case SeriesLevel:
+                    key = instance.SeriesInstanceUID
+                case InstanceLevel:
+                    key = instance.UUID
+                }
+                i, ok := byKey[key]
+                if !ok {
+                    i = len(matches)
+                    byKey[key] = i
+                    matches = append(matches, nil)
+                }
+                matches[i] = append(matches[i], instance)
+            }
+            if len(matches) > query.Limit {
+                matches = matches[:query.Limit]
+            }
+            return matches, nil
+        },
+    }
+    return NewDicomWeb(nil, mockSQLRepo, &blob.MockRepository{}, nil, log.Default())
+}
+
+func search(t *testing.T, query Query) (*SearchResult, model.HierarchyQuery) {
+    var received model.HierarchyQuery
+    result, err := newSearchDicomWeb(&received).Search(query, "http://localhost")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    return result, received
+}
+
+func TestDicomWeb_Search_Studies(t *testing.T) {
+    params := url.Values{"StudyDate": {"20240101-20240301"}, "ModalitiesInStudy": {"SR"}, "limit": {"5"}, "offset": {"1"}}
+    result, received := search(t, Query{Level: StudyLevel, Params: params})
+
+    expected := model.HierarchyQuery{
+        Level: StudyLevel,
+        Predicates: []model.AttributePredicate{
+            {Attribute: "Modality", Match: model.AttributeEqual, Values: []string{"SR"}},
+            {Attribute: "StudyDate", Match: model.AttributeRange, Values: []string{"20240101", "20240301"}},
+        },
+        // One more match is asked for to tell whether there are more
+        Limit:  6,
+        Offset: 1,
+    }
+    if !reflect.DeepEqual(received, expected) {
+        t.Errorf("Expected query %+v, got %+v", expected, received)
+    }
+    if len(result.Matches) != 2 {
+        t.Fatalf("Expected 2 studies, got %d", len(result.Matches))
+    }
+    study := result.Matches[0]
+    if uid := study[Key(tag.StudyInstanceUID)].Value; len(uid) != 1 || uid[0] != "1.1" {
+        t.Errorf("Unexpected study instance UID %v", uid)
+    }
+    if modalities := study[Key(tag.ModalitiesInStudy)].Value; len(modalities) != 2 {
+        t.Errorf("Expected 2 modalities in study, got %v", modalities)
+    }
+    if series := study[Key(tag.NumberOfStudyRelatedSeries)].Value; series[0] != 2 {
+        t.Errorf("Expected 2 series, got %v", series)
+    }
+    if instances := study[Key(tag.NumberOfStudyRelatedInstances)].Value; instances[0] != 3 {
+        t.Errorf("Expected 3 instances, got %v", instances)
+    }
+    if retrieveURL := study[Key(retrieveURLTag)].Value; retrieveURL[0] != "http://localhost/studies/1.1" {
+        t.Errorf("Unexpected retrieve URL %v", retrieveURL)
+    }
+    name := study[Key(tag.PatientName)].Value[0].(model.DicomJSONPersonName)
+    if name.Alphabetic != "DOE^JOHN" {
+        t.Errorf("Unexpected patient name %+v", name)
+    }
+}
+
+func TestDicomWeb_Search_Predicates(t *testing.T) {
+    tests := []struct {
+        params   url.Values
+        expected []model.AttributePredicate
+    }{
+        {url.Values{}, nil},
+        {url.Values{"PatientName": {"*"}, "StudyDate": {""}}, nil},
+        {url.Values{"PatientName": {"doe*"}}, []model.AttributePredicate{{Attribute: "PatientName", Match: model.AttributeWildcard, Values: []string{"doe*"}, IgnoreCase: true}}},
+        {url.Values{"PatientName": {"JOH"}, "fuzzymatching": {"true"}}, []model.AttributePredicate{{Attribute: "PatientName", Match: model.AttributeWildcard, Values: []string{"JOH"}, IgnoreCase: true, Fuzzy: true}}},
+        {url.Values{"00100020": {"P?"}}, []model.AttributePredicate{{Attribute: "PatientID", Match: model.AttributeWildcard, Values: []string{"P?"}}}},
+        {url.Values{"PatientID": {"P1"}}, []model.AttributePredicate{{Attribute: "PatientID", Match: model.AttributeEqual, Values: []string{"P1"}}}},
+        {url.Values{"StudyDate": {"20240301-"}}, []model.AttributePredicate{{Attribute: "StudyDate", Match: model.AttributeRange, Values: []string{"20240301", ""}}}},
+        {url.Values{"StudyDate": {"20240301"}}, []model.AttributePredicate{{Attribute: "StudyDate", Match: model.AttributeEqual, Values: []string{"20240301"}}}},
+        {url.Values{"StudyInstanceUID": {"1.1\\2.1,3.1"}}, []model.AttributePredicate{{Attribute: "StudyInstanceUID", Match: model.AttributeEqual, Values: []string{"1.1", "2.1", "3.1"}}}},
+        {url.Values{"Modality": {"CT\\MR"}}, []model.AttributePredicate{{Attribute: "Modality", Match: model.AttributeEqual, Values: []string{"CT", "MR"}}}},
+        {url.Values{"ModalitiesInStudy": {"CT\\M?"}}, []model.AttributePredicate{{Attribute: "Modality", Match: model.AttributeWildcard, Values: []string{"CT", "M?"}}}},
+    }
+
+    for _, test := range tests {
+        _, received := search(t, Query{Level: StudyLevel, Params: test.params})
+        if !reflect.DeepEqual(received.Predicates, test.expected) {
+            t.Errorf("Expected predicates %+v for %v, got %+v", test.expected, test.params, received.Predicates)
+        }
+    }
+}
+
+func TestDicomWeb_Search_Series(t *testing.T) {
+    result, received := search(t, Query{Level: SeriesLevel, StudyInstanceUID: "1.1", Params: url.Values{}})
+
+    inStudy := []model.AttributePredicate{{Attribute: "StudyInstanceUID", Match: model.AttributeEqual, Values: []string{"1.1"}}}
+    if received.Level != SeriesLevel || !reflect.DeepEqual(received.Predicates, inStudy) {
+        t.Errorf("Expected a search of the series of study 1.1, got %+v", received)
+    }
+    if len(result.Matches) == 0 {
+        t.Fatal("Expected series, got none")
+    }
+    series := result.Matches[0]
+    if _, ok := series[Key(tag.PatientName)]; ok {
+        t.Error("Expected study attributes to be left out below a study")
+    }
+    if instances := series[Key(tag.NumberOfSeriesRelatedInstances)].Value; instances[0] != 2 {
+        t.Errorf("Expected 2 instances, got %v", instances)
+    }
+    if retrieveURL := series[Key(retrieveURLTag)].Value; retrieveURL[0] != "http://localhost/studies/1.1/series/1.1.1" {
+        t.Errorf("Unexpected retrieve URL %v", retrieveURL)
+    }
+}
+
+func TestDicomWeb_Search_IncludeField(t *testing.T) {
+    params := url.Values{"includefield": {"StudyDescription,Unknown"}, "Unsupported": {"x"}}
+    result, _ := search(t, Query{Level: StudyLevel, Params: params})
+
+    if _, ok := result.Matches[0][Key(tag.StudyDescription)]; !ok {
+        t.Error("Expected the included field in the result")
+    }
+    if len(result.Warnings) != 2 {
+        t.Errorf("Expected 2 warnings, got %v", result.Warnings)
+    }
+}
+
+func TestDicomWeb_Search_InvalidLimit(t *testing.T) {
+    for _, limit := range []string{"-1", "0", "x"} {
+        _, err := newSearchDicomWeb(&model.HierarchyQuery{}).Search(Query{Level: StudyLevel, Params: url.Values{"limit": {limit}}}, "")
+        if !errors.Is(err, ErrInvalidQuery) {
+            t.Errorf("Expected ErrInvalidQuery for limit %s, got %v", limit, err)
+        }
+    }
+}
+
+func TestDicomWeb_Search_Limit(t *testing.T) {
+    tests := []struct {
+        params   url.Values
+        limit    int
+        matches  int
+        warnings []string
+    }{
+        {url.Values{}, DefaultQueryLimit + 1, 4, nil},
+        {url.Values{"limit": {"5000"}}, MaxQueryLimit + 1, 4, []string{"Limit lowered to 1000"}},
+        {url.Values{"limit": {"3"}}, 4, 3, []string{"More than 3 matches, the next ones start at offset 3"}},
+        {url.Values{"limit": {"4"}}, 5, 4, nil},
+        {url.Values{"PatientID": {"P1", "P2"}}, DefaultQueryLimit + 1, 4, []string{"Only the first value of PatientID is used"}},
+    }
+
+    for _, test := range tests {
+        result, received := search(t, Query{Level: InstanceLevel, Params: test.params})
+        if received.Limit != test.limit {
+            t.Errorf("Expected a limit of %d for %v, got %d", test.limit, test.params, received.Limit)
+        }
+        if len(result.Matches) != test.matches || !reflect.DeepEqual(result.Warnings, test.warnings) {
+            t.Errorf("Expected %d matches and warnings %q for %v, got %d and %q", test.matches, test.warnings, test.params, len(result.Matches), result.Warnings)
+        }
+    }
+}
@@ -0,0 +1,219 @@
+package dicomweb
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "io"
+    "log"
+    "mime/multipart"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+    "dicom/api/service/renderer"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Failure reasons of a store instances response (PS3.18 section 10.5.3 and PS3.4 annex B)
+const (
+    FailureProcessing       = 0x0110
+    FailureDuplicate        = 0x0111
+    FailureStudyMismatch    = 0xA900
+    FailureCannotUnderstand = 0xC000
+)
+
+// retrieveURLTag is missing from the tag dictionary of the dicom library
+var retrieveURLTag = tag.Tag{Group: 0x0008, Element: 0x1190}
+
+// ErrNoInstances is returned for a store request without any part
+var ErrNoInstances = errors.New("no instances in request")
+
+type Web interface {
+    StoreInstances(body *multipart.Reader, studyInstanceUID string) (*StoreResult, error)
+    Search(query Query, baseURL string) (*SearchResult, error)
+    Instances(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error)
+    OpenInstance(instance *model.Dicom) (io.ReadCloser, error)
+    TransferSyntax(instance *model.Dicom) (string, error)
+    Metadata(instances []model.Dicom, baseURL string) ([]model.DicomJSON, error)
+    Frames(instance *model.Dicom, numbers []int) (*BulkData, error)
+    BulkData(instance *model.Dicom, t tag.Tag) (*BulkData, error)
+    Render(instance *model.Dicom, frame int, options renderer.Options) (image.Image, error)
+}
+
+// DicomWeb implements the DICOMweb services on top of the ingest pipeline and the repositories
+type DicomWeb struct {
+    ingester *ingester.DicomIngester
+    sql      sql.Repository
+    blob     blob.Repository
+    renderer *renderer.DicomRenderer
+    logger   *log.Logger
+
+    bulkDataThreshold int
+}
+
+func NewDicomWeb(dicomIngester *ingester.DicomIngester, sqlRepo sql.Repository, blobRepo blob.Repository, dicomRenderer *renderer.DicomRenderer, logger *log.Logger) *DicomWeb {
+    return &DicomWeb{
+        ingester: dicomIngester,
+        sql:      sqlRepo,
+        blob:     blobRepo,
+        renderer: dicomRenderer,
+        logger:   logger,
+
+        bulkDataThreshold: processor.DefaultBulkDataThreshold,
+    }
+}
+
+// SetBulkDataThreshold changes the size in bytes above which binary values are linked as bulkdata instead
+// of being inlined in metadata, it should match the threshold of the processor storing the tags
+func (w *DicomWeb) SetBulkDataThreshold(threshold int) {
+    w.bulkDataThreshold = threshold
+}
+
+// StoredInstance is the outcome of storing one instance, FailureReason is 0 when it was stored
+type StoredInstance struct {
+    ID                string
+    StudyInstanceUID  string
+    SeriesInstanceUID string
+    SOPClassUID       string
+    SOPInstanceUID    string
+    FailureReason     uint16
+}
+
+// StoreResult lists the instances of a store request that were stored and those that failed
+type StoreResult struct {
+    Referenced []StoredInstance
+    Failed     []StoredInstance
+}
+
+// StoreInstances ingests every part of a STOW-RS multipart/related body. When studyInstanceUID is set,
+// instances of other studies are refused
+func (w *DicomWeb) StoreInstances(body *multipart.Reader, studyInstanceUID string) (*StoreResult, error) {
+    result := &StoreResult{
+        Referenced: []StoredInstance{},
+        Failed:     []StoredInstance{},
+    }
+
+    for {
+        part, err := body.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            w.logger.Printf("Error reading store request: %v", err)
+            return nil, err
+        }
+
+        instance := w.storeInstance(part, studyInstanceUID)
+        part.Close()
+        if instance.FailureReason != 0 {
+            result.Failed = append(result.Failed, instance)
+        } else {
+            result.Referenced = append(result.Referenced, instance)
+        }
+    }
+
+    if len(result.Referenced)+len(result.Failed) == 0 {
+        return nil, ErrNoInstances
+    }
+
+    w.logger.Printf("Stored %d instances, %d failed", len(result.Referenced), len(result.Failed))
+    return result, nil
+}
+
+func (w *DicomWeb) storeInstance(part *multipart.Part, studyInstanceUID string) StoredInstance {
+    var instance StoredInstance
+
+    if contentType := part.Header.Get("Content-Type"); contentType != "" && !isDicomMediaType(contentType) {
+        instance.FailureReason = FailureCannotUnderstand
+        return instance
+    }
+
+    data, err := io.ReadAll(part)
+    if err != nil {
+        w.logger.Printf("Error reading instance: %v", err)
+        instance.FailureReason = FailureProcessing
+        return instance
+    }
+
+    // The header tells which instance this is before anything is stored
+    header, err := parser.ParseDataset(data, dicom.SkipPixelData())
+    if err != nil {
+        w.logger.Printf("Error parsing instance: %v", err)
+        instance.FailureReason = FailureCannotUnderstand
+        return instance
+    }
+    instance.StudyInstanceUID = parser.FirstString(&header, tag.StudyInstanceUID)
+    instance.SeriesInstanceUID = parser.FirstString(&header, tag.SeriesInstanceUID)
+    instance.SOPClassUID = parser.FirstString(&header, tag.SOPClassUID)
+    instance.SOPInstanceUID = parser.FirstString(&header, tag.SOPInstanceUID)
+
+    if studyInstanceUID != "" && instance.StudyInstanceUID != studyInstanceUID {
+        instance.FailureReason = FailureStudyMismatch
+        return instance
+    }
+
+    uuid, err := w.ingester.IngestFile(bytes.NewReader(data), nil)
+    if err != nil {
+        var ingestErr *ingester.Error
+        switch {
+        case errors.Is(err, parser.ErrDuplicate):
+            instance.FailureReason = FailureDuplicate
+        case errors.As(err, &ingestErr):
+            instance.FailureReason = FailureProcessing
+        default:
+            instance.FailureReason = FailureCannotUnderstand
+        }
+        return instance
+    }
+
+    instance.ID = uuid
+    return instance
+}
+
+// StoreResponse builds the DICOM JSON response of a store request, pointing to where the stored
+// instances can be retrieved below baseURL
+func StoreResponse(result *StoreResult, studyInstanceUID string, baseURL string) model.DicomJSON {
+    response := model.DicomJSON{}
+
+    if studyInstanceUID != "" {
+        response[Key(retrieveURLTag)] = URAttribute(baseURL + "/studies/" + studyInstanceUID)
+    }
+
+    referenced := make([]model.DicomJSON, 0, len(result.Referenced))
+    for _, instance := range result.Referenced {
+        item := model.DicomJSON{
+            Key(tag.ReferencedSOPClassUID):    UIAttribute(instance.SOPClassUID),
+            Key(tag.ReferencedSOPInstanceUID): UIAttribute(instance.SOPInstanceUID),
+            Key(retrieveURLTag):              URAttribute(InstanceURL(baseURL, instance.StudyInstanceUID, instance.SeriesInstanceUID, instance.SOPInstanceUID)),
+        }
+        referenced = append(referenced, item)
+    }
+    if len(referenced) > 0 {
+        response[Key(tag.ReferencedSOPSequence)] = SQAttribute(referenced...)
+    }
+
+    failed := make([]model.DicomJSON, 0, len(result.Failed))
+    for _, instance := range result.Failed {
+        failed = append(failed, model.DicomJSON{
+            Key(tag.ReferencedSOPClassUID):    UIAttribute(instance.SOPClassUID),
+            Key(tag.ReferencedSOPInstanceUID): UIAttribute(instance.SOPInstanceUID),
+            Key(tag.FailureReason):            USAttribute(int(instance.FailureReason)),
+        })
+    }
+    if len(failed) > 0 {
+        response[Key(tag.FailedSOPSequence)] = SQAttribute(failed...)
+    }
+
+    return response
+}
+
+// InstanceURL is the WADO-RS URL of an instance
+func InstanceURL(baseURL string, studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) string {
+    return baseURL + "/studies/" + studyInstanceUID + "/series/" + seriesInstanceUID + "/instances/" + sopInstanceUID
+}
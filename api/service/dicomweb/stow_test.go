@@ -0,0 +1,127 @@
+package dicomweb
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "log"
+    "mime/multipart"
+    "net/textproto"
+    "os"
+    "testing"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+    "dicom/api/service/renderer"
+
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const (
+    testStudyInstanceUID = "1.2.840.114202.4.3505441013.825017129.509896760.4230310936"
+    testSOPInstanceUID   = "1.2.826.0.1.3680043.2.1074.5931521980486637439720462877894121211"
+)
+
+func newTestDicomWeb(sqlRepo *sql.MockRepository) *DicomWeb {
+    mockBlobRepo := &blob.MockRepository{
+        WritePngToFileFunc: func(image.Image, string) error {
+            return nil
+        },
+    }
+    dicomIngester := ingester.NewDicomIngester(
+        parser.NewDicomParser(sqlRepo, &blob.MockRepository{}, log.Default()),
+        processor.NewDicomProcessor(sqlRepo, mockBlobRepo, log.Default()),
+        log.Default(),
+    )
+    return NewDicomWeb(dicomIngester, sqlRepo, mockBlobRepo, renderer.NewDicomRenderer(log.Default()), log.Default())
+}
+
+// multipartBody builds a multipart/related body with one application/dicom part per file
+func multipartBody(t *testing.T, files ...[]byte) *multipart.Reader {
+    var body bytes.Buffer
+    writer := multipart.NewWriter(&body)
+    for _, file := range files {
+        part, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/dicom"}})
+        if err != nil {
+            t.Fatalf("Failed to create part: %v", err)
+        }
+        part.Write(file)
+    }
+    writer.Close()
+
+    return multipart.NewReader(&body, writer.Boundary())
+}
+
+func TestDicomWeb_StoreInstances(t *testing.T) {
+    data, err := os.ReadFile("../parser/test_file.dcm")
+    if err != nil {
+        t.Fatalf("Failed to read test file: %v", err)
+    }
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+        },
+    }
+
+    result, err := newTestDicomWeb(mockSQLRepo).StoreInstances(multipartBody(t, data, []byte("not a dicom file")), "")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(result.Referenced) != 1 || len(result.Failed) != 1 {
+        t.Fatalf("Expected one stored and one failed instance, got %+v", result)
+    }
+    stored := result.Referenced[0]
+    if stored.ID == "" || stored.SOPInstanceUID != testSOPInstanceUID || stored.StudyInstanceUID != testStudyInstanceUID {
+        t.Errorf("Unexpected stored instance %+v", stored)
+    }
+    if result.Failed[0].FailureReason != FailureCannotUnderstand {
+        t.Errorf("Expected failure reason %04X, got %04X", FailureCannotUnderstand, result.Failed[0].FailureReason)
+    }
+
+    response := StoreResponse(result, "", "http://localhost:8000")
+    referenced := response[Key(tag.ReferencedSOPSequence)].Value
+    if len(referenced) != 1 {
+        t.Fatalf("Expected one referenced SOP, got %v", referenced)
+    }
+    retrieveURL := referenced[0].(model.DicomJSON)[Key(retrieveURLTag)].Value[0]
+    if retrieveURL != InstanceURL("http://localhost:8000", stored.StudyInstanceUID, stored.SeriesInstanceUID, stored.SOPInstanceUID) {
+        t.Errorf("Unexpected retrieve URL %v", retrieveURL)
+    }
+    failed := response[Key(tag.FailedSOPSequence)].Value
+    if len(failed) != 1 || failed[0].(model.DicomJSON)[Key(tag.FailureReason)].Value[0] != FailureCannotUnderstand {
+        t.Errorf("Unexpected failed SOP sequence %v", failed)
+    }
+}
+
+func TestDicomWeb_StoreInstances_StudyMismatch(t *testing.T) {
+    data, err := os.ReadFile("../parser/test_file.dcm")
+    if err != nil {
+        t.Fatalf("Failed to read test file: %v", err)
+    }
+    inserted := false
+    mockSQLRepo := &sql.MockRepository{
+        InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+            inserted = true
+            return 1, nil
+        },
+    }
+
+    result, err := newTestDicomWeb(mockSQLRepo).StoreInstances(multipartBody(t, data), "1.2.3")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(result.Failed) != 1 || result.Failed[0].FailureReason != FailureStudyMismatch {
+        t.Errorf("Expected the instance of another study to fail, got %+v", result)
+    }
+    if inserted {
+        t.Error("Expected the instance of another study not to be stored")
+    }
+
+    if _, err := newTestDicomWeb(mockSQLRepo).StoreInstances(multipartBody(t), ""); !errors.Is(err, ErrNoInstances) {
+        t.Errorf("Expected ErrNoInstances for an empty request, got %v", err)
+    }
+}
@@ -0,0 +1,271 @@
+package dicomweb
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    "io"
+    "strconv"
+    "strings"
+
+    "dicom/api/model"
+    "dicom/api/service/parser"
+    "dicom/api/service/renderer"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// Transfer syntaxes that decide how frames are returned
+const (
+    implicitVRLittleEndian = "1.2.840.10008.1.2"
+    explicitVRLittleEndian = "1.2.840.10008.1.2.1"
+)
+
+// frameMediaTypes are the media types of the frames of files with compressed pixel data, by transfer
+// syntax (PS3.18 section 8.7.3.5)
+var frameMediaTypes = map[string]string{
+    "1.2.840.10008.1.2.4.50":  "image/jpeg",
+    "1.2.840.10008.1.2.4.51":  "image/jpeg",
+    "1.2.840.10008.1.2.4.57":  "image/jpeg",
+    "1.2.840.10008.1.2.4.70":  "image/jpeg",
+    "1.2.840.10008.1.2.4.80":  "image/jls",
+    "1.2.840.10008.1.2.4.81":  "image/jls",
+    "1.2.840.10008.1.2.4.90":  "image/jp2",
+    "1.2.840.10008.1.2.4.91":  "image/jp2",
+    "1.2.840.10008.1.2.4.92":  "image/jpx",
+    "1.2.840.10008.1.2.4.93":  "image/jpx",
+    "1.2.840.10008.1.2.4.100": "video/mpeg",
+    "1.2.840.10008.1.2.4.102": "video/mp4",
+    "1.2.840.10008.1.2.5":     "image/x-dicom-rle",
+}
+
+// ErrNotFound is returned when nothing stored matches a retrieve request
+var ErrNotFound = errors.New("not found")
+
+// BulkData is binary content of an instance, pixel data comes as one part per frame
+type BulkData struct {
+    MediaType         string
+    TransferSyntaxUID string
+    Parts             [][]byte
+}
+
+// Instances returns the stored instances of a study, series or single instance, empty UIDs match any
+func (w *DicomWeb) Instances(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error) {
+    instances, err := w.sql.GetDicomInstances(studyInstanceUID, seriesInstanceUID, sopInstanceUID)
+    if err != nil {
+        w.logger.Printf("Error getting DICOM instances: %v", err)
+        return nil, err
+    }
+    if len(instances) == 0 {
+        return nil, ErrNotFound
+    }
+
+    return instances, nil
+}
+
+// OpenInstance opens the original Part 10 file of an instance, the caller closes it
+func (w *DicomWeb) OpenInstance(instance *model.Dicom) (io.ReadCloser, error) {
+    // Files ingested before the originals were kept only have their image and tags
+    if instance.FileURL == "" {
+        return nil, fmt.Errorf("%w: original of %s was not kept", ErrNotFound, instance.UUID)
+    }
+
+    file, err := w.blob.OpenFile(instance.FileURL)
+    if err != nil {
+        w.logger.Printf("Error opening DICOM file: %v", err)
+        return nil, err
+    }
+    return file, nil
+}
+
+// TransferSyntax returns the transfer syntax the original file of an instance is encoded in, only its
+// file meta information is read
+func (w *DicomWeb) TransferSyntax(instance *model.Dicom) (string, error) {
+    file, err := w.OpenInstance(instance)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+
+    transferSyntax, err := parser.ReadTransferSyntax(file)
+    if err != nil {
+        w.logger.Printf("Error reading DICOM file meta information: %v", err)
+        return "", err
+    }
+    return transferSyntax, nil
+}
+
+// Metadata returns the DICOM JSON of every instance, binary values too large to be inlined are linked
+// through the bulkdata resource of the instance below baseURL
+func (w *DicomWeb) Metadata(instances []model.Dicom, baseURL string) ([]model.DicomJSON, error) {
+    metadata := make([]model.DicomJSON, 0, len(instances))
+    for i := range instances {
+        instance := &instances[i]
+        dataset, err := w.parseInstance(instance, dicom.SkipPixelData())
+        if err != nil {
+            return nil, err
+        }
+
+        instanceURL := InstanceURL(baseURL, instance.StudyInstanceUID, instance.SeriesInstanceUID, instance.SOPInstanceUID)
+        metadata = append(metadata, DatasetJSON(dataset.Elements, instanceURL+"/bulkdata", w.bulkDataThreshold))
+    }
+    return metadata, nil
+}
+
+// Frames returns the frames of an instance, numbered from 1, in the transfer syntax of the stored file
+func (w *DicomWeb) Frames(instance *model.Dicom, numbers []int) (*BulkData, error) {
+    dataset, err := w.parseInstance(instance, dicom.SkipProcessingPixelDataValue())
+    if err != nil {
+        return nil, err
+    }
+
+    frames, err := pixelDataFrames(dataset)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, number := range numbers {
+        if number < 1 || number > len(frames.Parts) {
+            return nil, fmt.Errorf("%w: frame %d of %s", ErrNotFound, number, instance.SOPInstanceUID)
+        }
+    }
+    if len(numbers) > 0 {
+        parts := make([][]byte, 0, len(numbers))
+        for _, number := range numbers {
+            parts = append(parts, frames.Parts[number-1])
+        }
+        frames.Parts = parts
+    }
+
+    return frames, nil
+}
+
+// BulkData returns the value of a binary attribute of an instance, pixel data is returned frame by frame
+func (w *DicomWeb) BulkData(instance *model.Dicom, t tag.Tag) (*BulkData, error) {
+    if t == tag.PixelData {
+        return w.Frames(instance, nil)
+    }
+
+    dataset, err := w.parseInstance(instance, dicom.SkipPixelData())
+    if err != nil {
+        return nil, err
+    }
+
+    element, err := dataset.FindElementByTag(t)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %s in %s", ErrNotFound, t, instance.SOPInstanceUID)
+    }
+    value, ok := element.Value.GetValue().([]byte)
+    if !ok {
+        return nil, fmt.Errorf("%w: %s in %s is not binary", ErrNotFound, t, instance.SOPInstanceUID)
+    }
+
+    return &BulkData{
+        MediaType:         "application/octet-stream",
+        TransferSyntaxUID: explicitVRLittleEndian,
+        Parts:             [][]byte{value},
+    }, nil
+}
+
+// Render renders a frame, numbered from 1, of an instance for display
+func (w *DicomWeb) Render(instance *model.Dicom, frame int, options renderer.Options) (image.Image, error) {
+    dataset, err := w.parseInstance(instance)
+    if err != nil {
+        return nil, err
+    }
+
+    img, err := w.renderer.Render(dataset, frame, options)
+    if errors.Is(err, renderer.ErrNoFrame) {
+        return nil, fmt.Errorf("%w: %v of %s", ErrNotFound, err, instance.SOPInstanceUID)
+    }
+    return img, err
+}
+
+// ParseFrameNumbers parses the comma separated frame list of a frames request
+func ParseFrameNumbers(list string) ([]int, error) {
+    var numbers []int
+    for _, value := range strings.Split(list, ",") {
+        number, err := strconv.Atoi(strings.TrimSpace(value))
+        if err != nil || number < 1 {
+            return nil, fmt.Errorf("%w: invalid frame number %q", ErrInvalidQuery, value)
+        }
+        numbers = append(numbers, number)
+    }
+    return numbers, nil
+}
+
+// ParseTag parses a tag written as 8 hex digits, the way DICOM JSON keys attributes
+func ParseTag(value string) (tag.Tag, error) {
+    number, err := strconv.ParseUint(value, 16, 32)
+    if err != nil || len(value) != 8 {
+        return tag.Tag{}, fmt.Errorf("%w: invalid tag %q", ErrInvalidQuery, value)
+    }
+    return tag.Tag{Group: uint16(number >> 16), Element: uint16(number)}, nil
+}
+
+func (w *DicomWeb) parseInstance(instance *model.Dicom, opts ...dicom.ParseOption) (*dicom.Dataset, error) {
+    file, err := w.OpenInstance(instance)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    // The file is parsed as it is read, the pixel data it skips is never held in memory
+    dataset, err := parser.ParseDatasetFrom(file, -1, opts...)
+    if err != nil {
+        w.logger.Printf("Error parsing DICOM file: %v", err)
+        return nil, err
+    }
+    return &dataset, nil
+}
+
+// pixelDataFrames splits the pixel data of a dataset parsed without processing native pixel data
+func pixelDataFrames(dataset *dicom.Dataset) (*BulkData, error) {
+    element, err := dataset.FindElementByTag(tag.PixelData)
+    if err != nil {
+        return nil, fmt.Errorf("%w: no pixel data", ErrNotFound)
+    }
+    info := dicom.MustGetPixelDataInfo(element.Value)
+    transferSyntax := parser.FirstString(dataset, tag.TransferSyntaxUID)
+
+    if info.IsEncapsulated {
+        frames := &BulkData{MediaType: "application/octet-stream", TransferSyntaxUID: transferSyntax}
+        if mediaType, ok := frameMediaTypes[transferSyntax]; ok {
+            frames.MediaType = mediaType
+        }
+        for _, frame := range info.Frames {
+            frames.Parts = append(frames.Parts, frame.EncapsulatedData.Data)
+        }
+        return frames, nil
+    }
+
+    // Native pixel data is the same in both little endian transfer syntaxes
+    if transferSyntax == implicitVRLittleEndian {
+        transferSyntax = explicitVRLittleEndian
+    }
+    frames := &BulkData{MediaType: "application/octet-stream", TransferSyntaxUID: transferSyntax}
+
+    count := 1
+    if value, err := strconv.Atoi(parser.FirstString(dataset, tag.NumberOfFrames)); err == nil && value > 0 {
+        count = value
+    }
+    data := info.UnprocessedValueData
+    size := len(data) / count
+    if rows, columns := parser.FirstInt(dataset, tag.Rows), parser.FirstInt(dataset, tag.Columns); rows > 0 && columns > 0 {
+        samples := parser.FirstInt(dataset, tag.SamplesPerPixel)
+        if samples == 0 {
+            samples = 1
+        }
+        bits := rows * columns * samples * parser.FirstInt(dataset, tag.BitsAllocated)
+        size = (bits + 7) / 8
+    }
+    if size == 0 || size*count > len(data) {
+        return nil, errors.New("pixel data is shorter than its frames")
+    }
+
+    for i := 0; i < count; i++ {
+        frames.Parts = append(frames.Parts, data[i*size:(i+1)*size])
+    }
+    return frames, nil
+}
@@ -0,0 +1,143 @@
+package dicomweb
+
+import (
+    "errors"
+    "io"
+    "log"
+    "os"
+    "testing"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/renderer"
+
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+func newRetrieveDicomWeb() *DicomWeb {
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomInstancesFunc: func(studyInstanceUID string, seriesInstanceUID string, sopInstanceUID string) ([]model.Dicom, error) {
+            if sopInstanceUID != "" && sopInstanceUID != testSOPInstanceUID {
+                return nil, nil
+            }
+            return []model.Dicom{{
+                UUID:            "mock_uuid",
+                FileURL:         "../parser/test_file.dcm",
+                SOPInstanceUID:  testSOPInstanceUID,
+                DicomAttributes: model.DicomAttributes{StudyInstanceUID: testStudyInstanceUID, SeriesInstanceUID: testStudyInstanceUID},
+            }}, nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        OpenFileFunc: func(path string) (io.ReadCloser, error) {
+            return os.Open(path)
+        },
+    }
+    return NewDicomWeb(nil, mockSQLRepo, mockBlobRepo, renderer.NewDicomRenderer(log.Default()), log.Default())
+}
+
+func TestDicomWeb_Instances(t *testing.T) {
+    dicomWeb := newRetrieveDicomWeb()
+
+    instances, err := dicomWeb.Instances(testStudyInstanceUID, testStudyInstanceUID, testSOPInstanceUID)
+    if err != nil || len(instances) != 1 {
+        t.Fatalf("Expected 1 instance, got %v, %v", instances, err)
+    }
+
+    _, err = dicomWeb.Instances(testStudyInstanceUID, testStudyInstanceUID, "1.2.3")
+    if !errors.Is(err, ErrNotFound) {
+        t.Errorf("Expected ErrNotFound, got %v", err)
+    }
+
+    _, err = dicomWeb.OpenInstance(&model.Dicom{UUID: "old_uuid"})
+    if !errors.Is(err, ErrNotFound) {
+        t.Errorf("Expected ErrNotFound for an instance without original, got %v", err)
+    }
+}
+
+func TestDicomWeb_Metadata(t *testing.T) {
+    dicomWeb := newRetrieveDicomWeb()
+    instances, _ := dicomWeb.Instances("", "", "")
+
+    metadata, err := dicomWeb.Metadata(instances, "http://localhost")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(metadata) != 1 {
+        t.Fatalf("Expected metadata of 1 instance, got %d", len(metadata))
+    }
+
+    instance := metadata[0]
+    if _, ok := instance[Key(tag.TransferSyntaxUID)]; ok {
+        t.Error("Expected the file meta information to be left out")
+    }
+    if uid := instance[Key(tag.SOPInstanceUID)].Value; len(uid) != 1 || uid[0] != testSOPInstanceUID {
+        t.Errorf("Unexpected SOP instance UID %v", uid)
+    }
+    expected := InstanceURL("http://localhost", testStudyInstanceUID, testStudyInstanceUID, testSOPInstanceUID) + "/bulkdata/7FE00010"
+    if pixelData := instance[Key(tag.PixelData)]; pixelData.BulkDataURI != expected {
+        t.Errorf("Expected pixel data bulk data URI %s, got %+v", expected, pixelData)
+    }
+}
+
+func TestDicomWeb_Frames(t *testing.T) {
+    dicomWeb := newRetrieveDicomWeb()
+    instances, _ := dicomWeb.Instances("", "", "")
+
+    frames, err := dicomWeb.Frames(&instances[0], []int{1})
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if frames.MediaType != "application/octet-stream" || frames.TransferSyntaxUID != explicitVRLittleEndian {
+        t.Errorf("Unexpected frame encoding %s %s", frames.MediaType, frames.TransferSyntaxUID)
+    }
+    // 2 bytes for each of the 1184 x 1537 pixels
+    if len(frames.Parts) != 1 || len(frames.Parts[0]) != 2*1184*1537 {
+        t.Errorf("Unexpected frames %d", len(frames.Parts))
+    }
+
+    _, err = dicomWeb.Frames(&instances[0], []int{2})
+    if !errors.Is(err, ErrNotFound) {
+        t.Errorf("Expected ErrNotFound for a missing frame, got %v", err)
+    }
+}
+
+func TestDicomWeb_Render(t *testing.T) {
+    dicomWeb := newRetrieveDicomWeb()
+    instances, _ := dicomWeb.Instances("", "", "")
+
+    img, err := dicomWeb.Render(&instances[0], 1, renderer.Options{Viewport: &renderer.Viewport{Width: 128, Height: 128}})
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if bounds := img.Bounds(); bounds.Dx() != 99 || bounds.Dy() != 128 {
+        t.Errorf("Expected the frame to fit the viewport keeping its aspect ratio, got %v", bounds)
+    }
+
+    if _, err := dicomWeb.Render(&instances[0], 2, renderer.Options{}); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Expected ErrNotFound for a missing frame, got %v", err)
+    }
+}
+
+func TestParseFrameNumbers(t *testing.T) {
+    numbers, err := ParseFrameNumbers("1,3")
+    if err != nil || len(numbers) != 2 || numbers[1] != 3 {
+        t.Errorf("Unexpected frame numbers %v, %v", numbers, err)
+    }
+    for _, list := range []string{"", "0", "1,a"} {
+        if _, err := ParseFrameNumbers(list); !errors.Is(err, ErrInvalidQuery) {
+            t.Errorf("Expected ErrInvalidQuery for %q, got %v", list, err)
+        }
+    }
+}
+
+func TestParseTag(t *testing.T) {
+    parsed, err := ParseTag("7FE00010")
+    if err != nil || parsed != tag.PixelData {
+        t.Errorf("Unexpected tag %v, %v", parsed, err)
+    }
+    if _, err := ParseTag("7FE0"); !errors.Is(err, ErrInvalidQuery) {
+        t.Errorf("Expected ErrInvalidQuery, got %v", err)
+    }
+}
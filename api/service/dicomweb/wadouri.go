@@ -0,0 +1,148 @@
+package dicomweb
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "net/url"
+    "strconv"
+    "strings"
+
+    "dicom/api/service/renderer"
+)
+
+// MediaTypeDICOM is the media type of Part 10 files
+const MediaTypeDICOM = "application/dicom"
+
+// ErrNotAcceptable is returned when none of the content types asked for can be returned
+var ErrNotAcceptable = errors.New("not acceptable")
+
+// URIRequest is a legacy WADO-URI request (PS3.18 section 9) for one instance, either as its original
+// file or rendered
+type URIRequest struct {
+    StudyInstanceUID  string
+    SeriesInstanceUID string
+    SOPInstanceUID    string
+    ContentType       string
+    // TransferSyntaxUID is the transfer syntax the original file is asked for in, empty means any
+    TransferSyntaxUID string
+    Frame             int
+    Quality           int
+    Options           renderer.Options
+}
+
+// ParseURIRequest parses the query parameters of a WADO-URI request. Rendering parameters are only
+// allowed for images, and the content type defaults to JPEG
+func ParseURIRequest(params url.Values) (*URIRequest, error) {
+    if requestType := params.Get("requestType"); requestType != "WADO" {
+        return nil, fmt.Errorf("%w: requestType must be WADO, got %q", ErrInvalidQuery, requestType)
+    }
+
+    request := &URIRequest{
+        StudyInstanceUID:  params.Get("studyUID"),
+        SeriesInstanceUID: params.Get("seriesUID"),
+        SOPInstanceUID:    params.Get("objectUID"),
+        TransferSyntaxUID: params.Get("transferSyntax"),
+        Frame:             1,
+        Quality:           renderer.DefaultQuality,
+    }
+    if request.StudyInstanceUID == "" || request.SeriesInstanceUID == "" || request.SOPInstanceUID == "" {
+        return nil, fmt.Errorf("%w: studyUID, seriesUID and objectUID are required", ErrInvalidQuery)
+    }
+
+    contentType, err := uriContentType(params.Get("contentType"))
+    if err != nil {
+        return nil, err
+    }
+    request.ContentType = contentType
+
+    rows, err := uriNumber(params, "rows")
+    if err != nil {
+        return nil, err
+    }
+    columns, err := uriNumber(params, "columns")
+    if err != nil {
+        return nil, err
+    }
+    frame, err := uriNumber(params, "frameNumber")
+    if err != nil {
+        return nil, err
+    }
+    quality, err := uriNumber(params, "imageQuality")
+    if err != nil || quality > 100 {
+        return nil, fmt.Errorf("%w: imageQuality must be from 1 to 100", ErrInvalidQuery)
+    }
+
+    center, width := params.Get("windowCenter"), params.Get("windowWidth")
+    if (center == "") != (width == "") {
+        return nil, fmt.Errorf("%w: windowCenter and windowWidth go together", ErrInvalidQuery)
+    }
+
+    if request.ContentType == MediaTypeDICOM {
+        if rows != 0 || columns != 0 || frame != 0 || quality != 0 || center != "" {
+            return nil, fmt.Errorf("%w: rendering parameters do not apply to %s", ErrInvalidQuery, MediaTypeDICOM)
+        }
+        return request, nil
+    }
+    if request.TransferSyntaxUID != "" {
+        return nil, fmt.Errorf("%w: transferSyntax only applies to %s", ErrInvalidQuery, MediaTypeDICOM)
+    }
+
+    if frame != 0 {
+        request.Frame = frame
+    }
+    if quality != 0 {
+        request.Quality = quality
+    }
+    if rows != 0 || columns != 0 {
+        // A missing dimension does not limit the size, the other one keeps the aspect ratio
+        viewport := &renderer.Viewport{Width: math.MaxInt32, Height: math.MaxInt32}
+        if columns != 0 {
+            viewport.Width = columns
+        }
+        if rows != 0 {
+            viewport.Height = rows
+        }
+        request.Options.Viewport = viewport
+    }
+    if center != "" {
+        window, err := renderer.ParseWindow(center + "," + width)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+        }
+        request.Options.Window = window
+    }
+    return request, nil
+}
+
+// uriContentType picks the first supported type of a comma separated contentType list
+func uriContentType(list string) (string, error) {
+    if list == "" {
+        return renderer.MediaTypeJPEG, nil
+    }
+    for _, contentType := range strings.Split(list, ",") {
+        contentType = strings.TrimSpace(contentType)
+        if contentType == MediaTypeDICOM {
+            return contentType, nil
+        }
+        for _, mediaType := range renderer.MediaTypes {
+            if contentType == mediaType {
+                return contentType, nil
+            }
+        }
+    }
+    return "", fmt.Errorf("%w: contentType %s, expected %s or %s", ErrNotAcceptable, list, MediaTypeDICOM, strings.Join(renderer.MediaTypes, ", "))
+}
+
+// uriNumber parses a positive integer parameter, zero when missing
+func uriNumber(params url.Values, name string) (int, error) {
+    value := params.Get(name)
+    if value == "" {
+        return 0, nil
+    }
+    number, err := strconv.Atoi(value)
+    if err != nil || number < 1 {
+        return 0, fmt.Errorf("%w: invalid %s %q", ErrInvalidQuery, name, value)
+    }
+    return number, nil
+}
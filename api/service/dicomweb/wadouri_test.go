@@ -0,0 +1,74 @@
+package dicomweb
+
+import (
+    "errors"
+    "net/url"
+    "testing"
+
+    "dicom/api/service/renderer"
+)
+
+func uriParams(extra string) url.Values {
+    params, _ := url.ParseQuery("requestType=WADO&studyUID=1.2&seriesUID=1.2.3&objectUID=1.2.3.4" + extra)
+    return params
+}
+
+func TestParseURIRequest(t *testing.T) {
+    request, err := ParseURIRequest(uriParams(""))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if request.ContentType != renderer.MediaTypeJPEG || request.Frame != 1 || request.SOPInstanceUID != "1.2.3.4" {
+        t.Errorf("Expected the first frame as JPEG by default, got %+v", request)
+    }
+
+    request, err = ParseURIRequest(uriParams("&contentType=image/png&rows=256&windowCenter=40&windowWidth=400&frameNumber=2&imageQuality=90"))
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if request.ContentType != renderer.MediaTypePNG || request.Frame != 2 || request.Quality != 90 {
+        t.Errorf("Unexpected request %+v", request)
+    }
+    if viewport := request.Options.Viewport; viewport == nil || viewport.Height != 256 || viewport.Width < 256 {
+        t.Errorf("Expected the rows to limit the height only, got %+v", viewport)
+    }
+    if window := request.Options.Window; window == nil || window.Center != 40 || window.Width != 400 {
+        t.Errorf("Unexpected window %+v", window)
+    }
+
+    request, err = ParseURIRequest(uriParams("&contentType=image/tiff,application/dicom&transferSyntax=1.2.840.10008.1.2.1"))
+    if err != nil || request.ContentType != MediaTypeDICOM || request.TransferSyntaxUID != "1.2.840.10008.1.2.1" {
+        t.Errorf("Expected the first supported content type, got %+v, %v", request, err)
+    }
+}
+
+func TestParseURIRequest_Invalid(t *testing.T) {
+    for _, extra := range []string{
+        "&rows=0",
+        "&columns=abc",
+        "&windowCenter=40",
+        "&imageQuality=101",
+        "&contentType=application/dicom&rows=256",
+        "&transferSyntax=1.2.840.10008.1.2.1",
+    } {
+        if _, err := ParseURIRequest(uriParams(extra)); !errors.Is(err, ErrInvalidQuery) {
+            t.Errorf("Expected ErrInvalidQuery for %q, got %v", extra, err)
+        }
+    }
+
+    params := uriParams("")
+    params.Set("requestType", "WADO-RS")
+    if _, err := ParseURIRequest(params); !errors.Is(err, ErrInvalidQuery) {
+        t.Errorf("Expected ErrInvalidQuery for another request type, got %v", err)
+    }
+
+    params = uriParams("")
+    params.Del("objectUID")
+    if _, err := ParseURIRequest(params); !errors.Is(err, ErrInvalidQuery) {
+        t.Errorf("Expected ErrInvalidQuery without objectUID, got %v", err)
+    }
+
+    if _, err := ParseURIRequest(uriParams("&contentType=image/tiff")); !errors.Is(err, ErrNotAcceptable) {
+        t.Errorf("Expected ErrNotAcceptable, got %v", err)
+    }
+}
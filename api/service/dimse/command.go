@@ -0,0 +1,159 @@
+package dimse
+
+import (
+    "encoding/binary"
+    "errors"
+    "sort"
+)
+
+// Command fields of the DIMSE-C services (PS3.7 section 9.3)
+const (
+    commandCStoreRQ  = 0x0001
+    commandCStoreRSP = 0x8001
+    commandCEchoRQ   = 0x0030
+    commandCEchoRSP  = 0x8030
+)
+
+// responseBit is set in the command field of every response
+const responseBit = 0x8000
+
+// noDataset is the CommandDataSetType of a message without a dataset
+const noDataset = 0x0101
+
+// Statuses of a DIMSE response (PS3.7 annex C and PS3.4 annex B)
+const (
+    statusSuccess               = 0x0000
+    statusDuplicateSOPInstance  = 0x0111
+    statusSOPClassNotSupported  = 0x0122
+    statusUnrecognizedOperation = 0x0211
+    statusOutOfResources        = 0xA700
+    statusCannotUnderstand      = 0xC000
+)
+
+// Elements of the command group
+const (
+    elementGroupLength               = 0x0000
+    elementAffectedSOPClassUID       = 0x0002
+    elementCommandField              = 0x0100
+    elementMessageID                 = 0x0110
+    elementMessageIDBeingRespondedTo = 0x0120
+    elementPriority                  = 0x0700
+    elementCommandDataSetType        = 0x0800
+    elementStatus                    = 0x0900
+    elementAffectedSOPInstanceUID    = 0x1000
+)
+
+// command is a DIMSE command set, which is always encoded in implicit VR little endian
+type command struct {
+    Field                     uint16
+    MessageID                 uint16
+    MessageIDBeingRespondedTo uint16
+    AffectedSOPClassUID       string
+    AffectedSOPInstanceUID    string
+    Priority                  uint16
+    HasDataset                bool
+    Status                    uint16
+}
+
+func decodeCommand(data []byte) (*command, error) {
+    c := &command{}
+    for pos := 0; pos < len(data); {
+        if pos+8 > len(data) {
+            return nil, errors.New("command element header too short")
+        }
+        group := binary.LittleEndian.Uint16(data[pos:])
+        element := binary.LittleEndian.Uint16(data[pos+2:])
+        length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+        if group != 0x0000 {
+            return nil, errors.New("command contains an element outside of the command group")
+        }
+        if pos+8+length > len(data) {
+            return nil, errors.New("command element length exceeds command")
+        }
+        value := data[pos+8 : pos+8+length]
+        pos += 8 + length
+
+        switch element {
+        case elementAffectedSOPClassUID:
+            c.AffectedSOPClassUID = trimUID(value)
+        case elementAffectedSOPInstanceUID:
+            c.AffectedSOPInstanceUID = trimUID(value)
+        case elementCommandField, elementMessageID, elementMessageIDBeingRespondedTo, elementPriority, elementCommandDataSetType, elementStatus:
+            if len(value) != 2 {
+                return nil, errors.New("invalid US command element")
+            }
+            number := binary.LittleEndian.Uint16(value)
+            switch element {
+            case elementCommandField:
+                c.Field = number
+            case elementMessageID:
+                c.MessageID = number
+            case elementMessageIDBeingRespondedTo:
+                c.MessageIDBeingRespondedTo = number
+            case elementPriority:
+                c.Priority = number
+            case elementCommandDataSetType:
+                c.HasDataset = number != noDataset
+            case elementStatus:
+                c.Status = number
+            }
+        }
+    }
+
+    return c, nil
+}
+
+func (c *command) encode() []byte {
+    elements := map[uint16][]byte{}
+    us := func(element uint16, number uint16) {
+        value := make([]byte, 2)
+        binary.LittleEndian.PutUint16(value, number)
+        elements[element] = value
+    }
+    ui := func(element uint16, uid string) {
+        if uid != "" {
+            elements[element] = uidValue(uid)
+        }
+    }
+
+    ui(elementAffectedSOPClassUID, c.AffectedSOPClassUID)
+    us(elementCommandField, c.Field)
+    if c.Field&responseBit != 0 {
+        us(elementMessageIDBeingRespondedTo, c.MessageIDBeingRespondedTo)
+        us(elementStatus, c.Status)
+    } else {
+        us(elementMessageID, c.MessageID)
+        if c.Field == commandCStoreRQ {
+            us(elementPriority, c.Priority)
+        }
+    }
+    if c.HasDataset {
+        // Any value other than 0101H means a dataset follows
+        us(elementCommandDataSetType, 0x0000)
+    } else {
+        us(elementCommandDataSetType, noDataset)
+    }
+    ui(elementAffectedSOPInstanceUID, c.AffectedSOPInstanceUID)
+
+    keys := make([]int, 0, len(elements))
+    for element := range elements {
+        keys = append(keys, int(element))
+    }
+    sort.Ints(keys)
+
+    var body []byte
+    for _, element := range keys {
+        body = appendCommandElement(body, uint16(element), elements[uint16(element)])
+    }
+
+    groupLength := make([]byte, 4)
+    binary.LittleEndian.PutUint32(groupLength, uint32(len(body)))
+    return append(appendCommandElement(nil, elementGroupLength, groupLength), body...)
+}
+
+func appendCommandElement(data []byte, element uint16, value []byte) []byte {
+    header := make([]byte, 8)
+    binary.LittleEndian.PutUint16(header[2:], element)
+    binary.LittleEndian.PutUint32(header[4:], uint32(len(value)))
+    return append(append(data, header...), value...)
+}
@@ -0,0 +1,65 @@
+package dimse
+
+import (
+    "bytes"
+    "encoding/binary"
+)
+
+// Identify this service in the file meta information of received files and in associations.
+// The class UID is derived from a UUID as allowed by PS3.5 section B.2
+const (
+    implementationClassUID    = "2.25.850189744237016987512290021744617078"
+    implementationVersionName = "DICOM_SERVICE_1"
+)
+
+// part10 wraps a dataset received over the network into a DICOM Part 10 file: the preamble, the magic
+// word and the file meta information describing the dataset, which keeps the transfer syntax it was
+// sent in
+func part10(sopClassUID string, sopInstanceUID string, transferSyntax string, dataset []byte) []byte {
+    var meta []byte
+    meta = appendMetaElement(meta, 0x0001, "OB", []byte{0x00, 0x01})
+    meta = appendMetaElement(meta, 0x0002, "UI", uidValue(sopClassUID))
+    meta = appendMetaElement(meta, 0x0003, "UI", uidValue(sopInstanceUID))
+    meta = appendMetaElement(meta, 0x0010, "UI", uidValue(transferSyntax))
+    meta = appendMetaElement(meta, 0x0012, "UI", uidValue(implementationClassUID))
+    meta = appendMetaElement(meta, 0x0013, "SH", []byte(implementationVersionName+" "))
+
+    groupLength := make([]byte, 4)
+    binary.LittleEndian.PutUint32(groupLength, uint32(len(meta)))
+
+    var file bytes.Buffer
+    file.Grow(128 + 4 + 12 + len(meta) + len(dataset))
+    file.Write(make([]byte, 128))
+    file.WriteString("DICM")
+    file.Write(appendMetaElement(nil, 0x0000, "UL", groupLength))
+    file.Write(meta)
+    file.Write(dataset)
+
+    return file.Bytes()
+}
+
+// appendMetaElement encodes an element of the file meta information group in explicit VR little endian
+func appendMetaElement(data []byte, element uint16, vr string, value []byte) []byte {
+    header := make([]byte, 4, 12)
+    binary.LittleEndian.PutUint16(header, 0x0002)
+    binary.LittleEndian.PutUint16(header[2:], element)
+    header = append(header, vr...)
+
+    if vr == "OB" {
+        header = append(header, 0, 0, 0, 0, 0, 0)
+        binary.LittleEndian.PutUint32(header[8:], uint32(len(value)))
+    } else {
+        header = append(header, 0, 0)
+        binary.LittleEndian.PutUint16(header[6:], uint16(len(value)))
+    }
+
+    return append(append(data, header...), value...)
+}
+
+func uidValue(uid string) []byte {
+    value := []byte(uid)
+    if len(value)%2 != 0 {
+        value = append(value, 0)
+    }
+    return value
+}
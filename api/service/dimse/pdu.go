@@ -0,0 +1,330 @@
+package dimse
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// PDU types of the DICOM Upper Layer protocol (PS3.8 section 9.3)
+const (
+    pduAssociateRQ = 0x01
+    pduAssociateAC = 0x02
+    pduAssociateRJ = 0x03
+    pduDataTF      = 0x04
+    pduReleaseRQ   = 0x05
+    pduReleaseRP   = 0x06
+    pduAbort       = 0x07
+)
+
+// Item types found in the variable part of A-ASSOCIATE-RQ and A-ASSOCIATE-AC PDUs
+const (
+    itemApplicationContext        = 0x10
+    itemPresentationContextRQ     = 0x20
+    itemPresentationContextAC     = 0x21
+    itemAbstractSyntax            = 0x30
+    itemTransferSyntax            = 0x40
+    itemUserInformation           = 0x50
+    itemMaxLength                 = 0x51
+    itemImplementationClassUID    = 0x52
+    itemImplementationVersionName = 0x55
+)
+
+// Results of a presentation context in an A-ASSOCIATE-AC PDU
+const (
+    contextAccepted                  = 0
+    contextAbstractSyntaxUnsupported = 3
+    contextTransferSyntaxUnsupported = 4
+)
+
+// Result, source and reason of an A-ASSOCIATE-RJ PDU
+const (
+    rejectPermanent                 = 1
+    rejectSourceServiceUser         = 1
+    rejectSourceServiceProviderACSE = 2
+    rejectReasonApplicationContext  = 2
+    rejectReasonCallingAETitle      = 3
+    rejectReasonCalledAETitle       = 7
+    rejectReasonProtocolVersion     = 2
+)
+
+// Source and reason of an A-ABORT PDU
+const (
+    abortSourceServiceProvider = 2
+    abortReasonNotSpecified    = 0
+    abortReasonUnexpectedPDU   = 2
+)
+
+// applicationContextName is the only application context defined by DICOM
+const applicationContextName = "1.2.840.10008.3.1.1.1"
+
+var errPDUTooLarge = errors.New("PDU exceeds the maximum length")
+
+type pdu struct {
+    Type byte
+    Data []byte
+}
+
+// readPDU reads the next PDU, refusing any longer than maxLength so a peer cannot make us allocate
+// arbitrary amounts of memory
+func readPDU(r io.Reader, maxLength uint32) (*pdu, error) {
+    header := make([]byte, 6)
+    if _, err := io.ReadFull(r, header); err != nil {
+        return nil, err
+    }
+
+    length := binary.BigEndian.Uint32(header[2:])
+    if length > maxLength {
+        return nil, fmt.Errorf("%w: %d bytes", errPDUTooLarge, length)
+    }
+
+    data := make([]byte, length)
+    if _, err := io.ReadFull(r, data); err != nil {
+        return nil, err
+    }
+
+    return &pdu{Type: header[0], Data: data}, nil
+}
+
+func writePDU(w io.Writer, pduType byte, data []byte) error {
+    buf := make([]byte, 6+len(data))
+    buf[0] = pduType
+    binary.BigEndian.PutUint32(buf[2:], uint32(len(data)))
+    copy(buf[6:], data)
+
+    _, err := w.Write(buf)
+    return err
+}
+
+// associate is the content of an A-ASSOCIATE-RQ or A-ASSOCIATE-AC PDU
+type associate struct {
+    CalledAETitle             string
+    CallingAETitle            string
+    ApplicationContext        string
+    Contexts                  []presentationContext
+    MaxLength                 uint32
+    ImplementationClassUID    string
+    ImplementationVersionName string
+}
+
+// presentationContext pairs an abstract syntax with the transfer syntaxes proposed for it, or with
+// the single transfer syntax accepted for it
+type presentationContext struct {
+    ID               byte
+    AbstractSyntax   string
+    TransferSyntaxes []string
+    Result           byte
+}
+
+func decodeAssociate(data []byte) (*associate, error) {
+    if len(data) < 68 {
+        return nil, errors.New("associate PDU too short")
+    }
+    if binary.BigEndian.Uint16(data)&1 == 0 {
+        return nil, errors.New("unsupported protocol version")
+    }
+
+    a := &associate{
+        CalledAETitle:  strings.TrimSpace(string(data[4:20])),
+        CallingAETitle: strings.TrimSpace(string(data[20:36])),
+    }
+
+    err := walkItems(data[68:], func(itemType byte, value []byte) error {
+        switch itemType {
+        case itemApplicationContext:
+            a.ApplicationContext = trimUID(value)
+        case itemPresentationContextRQ, itemPresentationContextAC:
+            if len(value) < 4 {
+                return errors.New("presentation context item too short")
+            }
+            context := presentationContext{ID: value[0], Result: value[2]}
+            err := walkItems(value[4:], func(subType byte, subValue []byte) error {
+                switch subType {
+                case itemAbstractSyntax:
+                    context.AbstractSyntax = trimUID(subValue)
+                case itemTransferSyntax:
+                    context.TransferSyntaxes = append(context.TransferSyntaxes, trimUID(subValue))
+                }
+                return nil
+            })
+            if err != nil {
+                return err
+            }
+            a.Contexts = append(a.Contexts, context)
+        case itemUserInformation:
+            return walkItems(value, func(subType byte, subValue []byte) error {
+                switch subType {
+                case itemMaxLength:
+                    if len(subValue) == 4 {
+                        a.MaxLength = binary.BigEndian.Uint32(subValue)
+                    }
+                case itemImplementationClassUID:
+                    a.ImplementationClassUID = trimUID(subValue)
+                case itemImplementationVersionName:
+                    a.ImplementationVersionName = strings.TrimSpace(string(subValue))
+                }
+                return nil
+            })
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return a, nil
+}
+
+// encode builds the body of an A-ASSOCIATE-RQ or A-ASSOCIATE-AC PDU
+func (a *associate) encode(pduType byte) []byte {
+    data := make([]byte, 68)
+    binary.BigEndian.PutUint16(data, 1)
+    copy(data[4:20], padAETitle(a.CalledAETitle))
+    copy(data[20:36], padAETitle(a.CallingAETitle))
+
+    data = appendItem(data, itemApplicationContext, []byte(a.ApplicationContext))
+
+    for _, context := range a.Contexts {
+        itemType := byte(itemPresentationContextRQ)
+        value := []byte{context.ID, 0, 0, 0}
+        if pduType == pduAssociateAC {
+            itemType = itemPresentationContextAC
+            value[2] = context.Result
+        } else {
+            value = appendItem(value, itemAbstractSyntax, []byte(context.AbstractSyntax))
+        }
+        for _, transferSyntax := range context.TransferSyntaxes {
+            value = appendItem(value, itemTransferSyntax, []byte(transferSyntax))
+        }
+        data = appendItem(data, itemType, value)
+    }
+
+    maxLength := make([]byte, 4)
+    binary.BigEndian.PutUint32(maxLength, a.MaxLength)
+    userInformation := appendItem(nil, itemMaxLength, maxLength)
+    userInformation = appendItem(userInformation, itemImplementationClassUID, []byte(a.ImplementationClassUID))
+    if a.ImplementationVersionName != "" {
+        userInformation = appendItem(userInformation, itemImplementationVersionName, []byte(a.ImplementationVersionName))
+    }
+    data = appendItem(data, itemUserInformation, userInformation)
+
+    return data
+}
+
+// pdv is one presentation data value of a P-DATA-TF PDU, a fragment of a command or a dataset
+type pdv struct {
+    ContextID byte
+    Command   bool
+    Last      bool
+    Data      []byte
+}
+
+func decodePDVs(data []byte) ([]pdv, error) {
+    var pdvs []pdv
+    for pos := 0; pos < len(data); {
+        if pos+6 > len(data) {
+            return nil, errors.New("PDV header too short")
+        }
+        length := int(binary.BigEndian.Uint32(data[pos:]))
+        if length < 2 || pos+4+length > len(data) {
+            return nil, errors.New("invalid PDV length")
+        }
+
+        control := data[pos+5]
+        pdvs = append(pdvs, pdv{
+            ContextID: data[pos+4],
+            Command:   control&0x01 != 0,
+            Last:      control&0x02 != 0,
+            Data:      data[pos+6 : pos+4+length],
+        })
+        pos += 4 + length
+    }
+
+    return pdvs, nil
+}
+
+func (p pdv) encode() []byte {
+    data := make([]byte, 6+len(p.Data))
+    binary.BigEndian.PutUint32(data, uint32(2+len(p.Data)))
+    data[4] = p.ContextID
+    if p.Command {
+        data[5] |= 0x01
+    }
+    if p.Last {
+        data[5] |= 0x02
+    }
+    copy(data[6:], p.Data)
+    return data
+}
+
+// writeMessage sends a DIMSE message as P-DATA-TF PDUs, splitting the command and the dataset into
+// fragments that fit the maximum PDU length of the peer. A maxLength of 0 means unlimited
+func writeMessage(w io.Writer, contextID byte, command []byte, dataset []byte, maxLength uint32) error {
+    fragmentSize := len(command) + len(dataset)
+    if maxLength > 6 && int(maxLength-6) < fragmentSize {
+        fragmentSize = int(maxLength - 6)
+    }
+    if fragmentSize == 0 {
+        fragmentSize = 1
+    }
+
+    send := func(data []byte, isCommand bool) error {
+        for {
+            n := len(data)
+            if n > fragmentSize {
+                n = fragmentSize
+            }
+            fragment := pdv{ContextID: contextID, Command: isCommand, Last: n == len(data), Data: data[:n]}
+            if err := writePDU(w, pduDataTF, fragment.encode()); err != nil {
+                return err
+            }
+            if fragment.Last {
+                return nil
+            }
+            data = data[n:]
+        }
+    }
+
+    if err := send(command, true); err != nil {
+        return err
+    }
+    if dataset != nil {
+        return send(dataset, false)
+    }
+    return nil
+}
+
+// walkItems calls fn with the type and value of every item in data
+func walkItems(data []byte, fn func(itemType byte, value []byte) error) error {
+    for pos := 0; pos < len(data); {
+        if pos+4 > len(data) {
+            return errors.New("item header too short")
+        }
+        length := int(binary.BigEndian.Uint16(data[pos+2:]))
+        if pos+4+length > len(data) {
+            return errors.New("item length exceeds PDU")
+        }
+        if err := fn(data[pos], data[pos+4:pos+4+length]); err != nil {
+            return err
+        }
+        pos += 4 + length
+    }
+    return nil
+}
+
+func appendItem(data []byte, itemType byte, value []byte) []byte {
+    header := []byte{itemType, 0, 0, 0}
+    binary.BigEndian.PutUint16(header[2:], uint16(len(value)))
+    return append(append(data, header...), value...)
+}
+
+func padAETitle(aeTitle string) []byte {
+    return []byte(fmt.Sprintf("%-16s", aeTitle))
+}
+
+// trimUID drops the padding a UID may carry to reach an even length
+func trimUID(value []byte) string {
+    return strings.TrimRight(string(value), "\x00 ")
+}
@@ -0,0 +1,453 @@
+package dimse
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "strings"
+    "sync"
+    "time"
+
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+
+    "github.com/suyashkumar/dicom/pkg/uid"
+)
+
+const (
+    // maxPDULength is the largest PDU we accept, announced to peers during association negotiation
+    maxPDULength = 1 << 20
+    // DefaultMaxDatasetSize is the largest dataset received in one message unless configured otherwise
+    DefaultMaxDatasetSize = 1 << 30
+    // associateTimeout bounds how long a new connection may take to request an association
+    associateTimeout = 30 * time.Second
+    // idleTimeout closes associations that stay silent for too long
+    idleTimeout = 5 * time.Minute
+    // storageSOPClassPrefix is shared by the UIDs of the storage SOP classes
+    storageSOPClassPrefix = "1.2.840.10008.5.1.4.1.1."
+)
+
+// transferSyntaxes lists the transfer syntaxes accepted for received datasets, the preferred one first.
+// Explicit VR big endian is left out as the parser does not decode its pixel data
+var transferSyntaxes = []string{
+    uid.ExplicitVRLittleEndian,
+    uid.ImplicitVRLittleEndian,
+    "1.2.840.10008.1.2.4.50", // JPEG Baseline (Process 1)
+}
+
+var ErrServerClosed = errors.New("DICOM server closed")
+
+type Server interface {
+    Serve(listener net.Listener) error
+    Close() error
+}
+
+// verificationTransferSyntaxes are accepted for C-ECHO, which never carries a dataset
+var verificationTransferSyntaxes = []string{
+    uid.ExplicitVRLittleEndian,
+    uid.ImplicitVRLittleEndian,
+}
+
+// DicomServer is a DICOM Upper Layer listener acting as a C-STORE SCP: modalities associate with it and
+// push instances, which are ingested through the same pipeline as uploaded files. It also answers C-ECHO
+// so devices can verify connectivity
+type DicomServer struct {
+    ingester *ingester.DicomIngester
+    aeTitle  string
+    // AE titles allowed to associate, every AE title is allowed when nil
+    callingAETitles map[string]bool
+    calledAETitles  map[string]bool
+    // maxDatasetSize bounds the dataset of a message, whose fragments are held in memory until the last
+    maxDatasetSize  int64
+    listener        net.Listener
+    conns           map[net.Conn]struct{}
+    closed          bool
+    mu              sync.Mutex
+    wg              sync.WaitGroup
+    logger          *log.Logger
+}
+
+func NewDicomServer(dicomIngester *ingester.DicomIngester, aeTitle string, logger *log.Logger) *DicomServer {
+    return &DicomServer{
+        ingester:       dicomIngester,
+        aeTitle:        aeTitle,
+        maxDatasetSize: DefaultMaxDatasetSize,
+        conns:          map[net.Conn]struct{}{},
+        logger:         logger,
+    }
+}
+
+// SetMaxDatasetSize changes the size in bytes above which a received dataset aborts the association
+func (s *DicomServer) SetMaxDatasetSize(size int64) {
+    s.maxDatasetSize = size
+}
+
+// SetAllowedAETitles restricts associations to the calling AE titles of known devices and to the called
+// AE titles this server answers to. An empty list allows any AE title
+func (s *DicomServer) SetAllowedAETitles(calling []string, called []string) {
+    s.callingAETitles = aeTitleSet(calling)
+    s.calledAETitles = aeTitleSet(called)
+}
+
+func aeTitleSet(aeTitles []string) map[string]bool {
+    if len(aeTitles) == 0 {
+        return nil
+    }
+
+    set := make(map[string]bool, len(aeTitles))
+    for _, aeTitle := range aeTitles {
+        set[strings.TrimSpace(aeTitle)] = true
+    }
+    return set
+}
+
+// Serve accepts associations on the listener until the server is closed
+func (s *DicomServer) Serve(listener net.Listener) error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return ErrServerClosed
+    }
+    s.listener = listener
+    s.mu.Unlock()
+
+    s.logger.Printf("DICOM server %s listening on %s", s.aeTitle, listener.Addr())
+
+    for {
+        conn, err := listener.Accept()
+        if err != nil {
+            s.mu.Lock()
+            closed := s.closed
+            s.mu.Unlock()
+            if closed {
+                return ErrServerClosed
+            }
+            s.logger.Printf("Error accepting DICOM connection: %v", err)
+            return err
+        }
+
+        s.mu.Lock()
+        if s.closed {
+            s.mu.Unlock()
+            conn.Close()
+            return ErrServerClosed
+        }
+        s.conns[conn] = struct{}{}
+        s.wg.Add(1)
+        s.mu.Unlock()
+
+        go func() {
+            defer s.wg.Done()
+            defer func() {
+                s.mu.Lock()
+                delete(s.conns, conn)
+                s.mu.Unlock()
+                conn.Close()
+            }()
+
+            s.handle(conn)
+        }()
+    }
+}
+
+// Close stops accepting associations, aborts the open ones and waits for them to finish
+func (s *DicomServer) Close() error {
+    s.mu.Lock()
+    if s.closed {
+        s.mu.Unlock()
+        return nil
+    }
+    s.closed = true
+
+    var err error
+    if s.listener != nil {
+        err = s.listener.Close()
+    }
+    for conn := range s.conns {
+        conn.Close()
+    }
+    s.mu.Unlock()
+
+    s.wg.Wait()
+    return err
+}
+
+// association is the state of one connection after its association was accepted
+type association struct {
+    conn           net.Conn
+    callingAETitle string
+    // Accepted presentation contexts by id
+    abstractSyntaxes map[byte]string
+    transferSyntaxes map[byte]string
+    peerMaxLength    uint32
+}
+
+// handle negotiates the association of a new connection, then serves its DIMSE messages until it is
+// released or aborted
+func (s *DicomServer) handle(conn net.Conn) {
+    conn.SetReadDeadline(time.Now().Add(associateTimeout))
+    request, err := readPDU(conn, maxPDULength)
+    if err != nil {
+        s.logger.Printf("Error reading association request: %v", err)
+        return
+    }
+    if request.Type != pduAssociateRQ {
+        abort(conn, abortSourceServiceProvider, abortReasonUnexpectedPDU)
+        return
+    }
+
+    assoc, err := s.negotiate(conn, request.Data)
+    if err != nil {
+        s.logger.Printf("Error negotiating association: %v", err)
+        return
+    }
+    if assoc == nil {
+        return
+    }
+    s.logger.Printf("Association accepted from %s at %s", assoc.callingAETitle, conn.RemoteAddr())
+
+    // Fragments of the message being received, every fragment of a message is on the presentation
+    // context of its first one
+    var current *command
+    var contextID byte
+    var receiving bool
+    var commandData, datasetData []byte
+
+    for {
+        conn.SetReadDeadline(time.Now().Add(idleTimeout))
+        message, err := readPDU(conn, maxPDULength)
+        if err != nil {
+            if err != io.EOF {
+                s.logger.Printf("Error reading from %s: %v", assoc.callingAETitle, err)
+            }
+            if errors.Is(err, errPDUTooLarge) {
+                abort(conn, abortSourceServiceProvider, abortReasonNotSpecified)
+            }
+            return
+        }
+
+        switch message.Type {
+        case pduDataTF:
+            pdvs, err := decodePDVs(message.Data)
+            if err != nil {
+                s.logger.Printf("Error decoding data from %s: %v", assoc.callingAETitle, err)
+                abort(conn, abortSourceServiceProvider, abortReasonNotSpecified)
+                return
+            }
+
+            for _, value := range pdvs {
+                if _, ok := assoc.transferSyntaxes[value.ContextID]; !ok || (current == nil) != value.Command ||
+                    (receiving && value.ContextID != contextID) {
+                    s.logger.Printf("Unexpected data from %s on presentation context %d", assoc.callingAETitle, value.ContextID)
+                    abort(conn, abortSourceServiceProvider, abortReasonUnexpectedPDU)
+                    return
+                }
+                if !receiving {
+                    contextID, receiving = value.ContextID, true
+                }
+
+                if value.Command {
+                    commandData = append(commandData, value.Data...)
+                    if !value.Last {
+                        continue
+                    }
+                    current, err = decodeCommand(commandData)
+                    if err != nil {
+                        s.logger.Printf("Error decoding command from %s: %v", assoc.callingAETitle, err)
+                        abort(conn, abortSourceServiceProvider, abortReasonNotSpecified)
+                        return
+                    }
+                    commandData = nil
+                    if current.HasDataset {
+                        continue
+                    }
+                } else {
+                    if int64(len(datasetData))+int64(len(value.Data)) > s.maxDatasetSize {
+                        s.logger.Printf("Dataset from %s exceeds %d bytes", assoc.callingAETitle, s.maxDatasetSize)
+                        abort(conn, abortSourceServiceProvider, abortReasonNotSpecified)
+                        return
+                    }
+                    datasetData = append(datasetData, value.Data...)
+                    if !value.Last {
+                        continue
+                    }
+                }
+
+                if err := s.dispatch(assoc, contextID, current, datasetData); err != nil {
+                    s.logger.Printf("Error responding to %s: %v", assoc.callingAETitle, err)
+                    return
+                }
+                current, datasetData, receiving = nil, nil, false
+            }
+        case pduReleaseRQ:
+            writePDU(conn, pduReleaseRP, make([]byte, 4))
+            s.logger.Printf("Association released by %s", assoc.callingAETitle)
+            return
+        case pduAbort:
+            s.logger.Printf("Association aborted by %s", assoc.callingAETitle)
+            return
+        default:
+            abort(conn, abortSourceServiceProvider, abortReasonUnexpectedPDU)
+            return
+        }
+    }
+}
+
+// negotiate answers an association request. It returns nil when the association was rejected
+func (s *DicomServer) negotiate(conn net.Conn, data []byte) (*association, error) {
+    request, err := decodeAssociate(data)
+    if err != nil {
+        reject(conn, rejectSourceServiceProviderACSE, rejectReasonProtocolVersion)
+        return nil, err
+    }
+    if request.ApplicationContext != applicationContextName {
+        s.logger.Printf("Rejecting association from %s: unsupported application context %s", request.CallingAETitle, request.ApplicationContext)
+        return nil, reject(conn, rejectSourceServiceUser, rejectReasonApplicationContext)
+    }
+    if s.callingAETitles != nil && !s.callingAETitles[request.CallingAETitle] {
+        s.logger.Printf("Rejecting association from unknown calling AE title %s", request.CallingAETitle)
+        return nil, reject(conn, rejectSourceServiceUser, rejectReasonCallingAETitle)
+    }
+    if s.calledAETitles != nil && !s.calledAETitles[request.CalledAETitle] {
+        s.logger.Printf("Rejecting association from %s: unknown called AE title %s", request.CallingAETitle, request.CalledAETitle)
+        return nil, reject(conn, rejectSourceServiceUser, rejectReasonCalledAETitle)
+    }
+
+    assoc := &association{
+        conn:             conn,
+        callingAETitle:   request.CallingAETitle,
+        abstractSyntaxes: map[byte]string{},
+        transferSyntaxes: map[byte]string{},
+        peerMaxLength:    request.MaxLength,
+    }
+
+    response := &associate{
+        CalledAETitle:             request.CalledAETitle,
+        CallingAETitle:            request.CallingAETitle,
+        ApplicationContext:        applicationContextName,
+        MaxLength:                 maxPDULength,
+        ImplementationClassUID:    implementationClassUID,
+        ImplementationVersionName: implementationVersionName,
+    }
+
+    for _, proposed := range request.Contexts {
+        accepted := presentationContext{ID: proposed.ID, Result: contextAccepted}
+
+        supported := transferSyntaxes
+        if proposed.AbstractSyntax == uid.VerificationSOPClass {
+            supported = verificationTransferSyntaxes
+        }
+        transferSyntax := chooseTransferSyntax(supported, proposed.TransferSyntaxes)
+
+        switch {
+        case proposed.AbstractSyntax != uid.VerificationSOPClass && !isStorageSOPClass(proposed.AbstractSyntax):
+            accepted.Result = contextAbstractSyntaxUnsupported
+        case transferSyntax == "":
+            accepted.Result = contextTransferSyntaxUnsupported
+        default:
+            assoc.abstractSyntaxes[proposed.ID] = proposed.AbstractSyntax
+            assoc.transferSyntaxes[proposed.ID] = transferSyntax
+        }
+
+        // The transfer syntax of a context that was not accepted is not significant but has to be present
+        if transferSyntax == "" {
+            transferSyntax = uid.ImplicitVRLittleEndian
+        }
+        accepted.TransferSyntaxes = []string{transferSyntax}
+        response.Contexts = append(response.Contexts, accepted)
+    }
+
+    if err := writePDU(conn, pduAssociateAC, response.encode(pduAssociateAC)); err != nil {
+        return nil, err
+    }
+
+    return assoc, nil
+}
+
+func isStorageSOPClass(sopClassUID string) bool {
+    return strings.HasPrefix(sopClassUID, storageSOPClassPrefix)
+}
+
+// chooseTransferSyntax picks the supported transfer syntax we prefer among the proposed ones
+func chooseTransferSyntax(supportedSyntaxes []string, proposed []string) string {
+    for _, supported := range supportedSyntaxes {
+        for _, transferSyntax := range proposed {
+            if transferSyntax == supported {
+                return supported
+            }
+        }
+    }
+    return ""
+}
+
+// dispatch runs a complete DIMSE request and sends its response
+func (s *DicomServer) dispatch(assoc *association, contextID byte, request *command, dataset []byte) error {
+    if request.Field&responseBit != 0 {
+        // We never send requests, so there is nothing a response could answer
+        return nil
+    }
+
+    response := &command{
+        Field:                     request.Field | responseBit,
+        MessageIDBeingRespondedTo: request.MessageID,
+        AffectedSOPClassUID:       request.AffectedSOPClassUID,
+        AffectedSOPInstanceUID:    request.AffectedSOPInstanceUID,
+    }
+
+    switch request.Field {
+    case commandCStoreRQ:
+        response.Status = s.store(assoc, contextID, request, dataset)
+    case commandCEchoRQ:
+        response.Status = s.echo(assoc, contextID)
+    default:
+        response.Status = statusUnrecognizedOperation
+    }
+
+    return writeMessage(assoc.conn, contextID, response.encode(), nil, assoc.peerMaxLength)
+}
+
+// echo answers a C-ECHO request, which only verifies that the association works
+func (s *DicomServer) echo(assoc *association, contextID byte) uint16 {
+    if assoc.abstractSyntaxes[contextID] != uid.VerificationSOPClass {
+        return statusSOPClassNotSupported
+    }
+
+    s.logger.Printf("Echo from %s", assoc.callingAETitle)
+    return statusSuccess
+}
+
+// store ingests the dataset of a C-STORE request and returns the status to respond with
+func (s *DicomServer) store(assoc *association, contextID byte, request *command, dataset []byte) uint16 {
+    if !isStorageSOPClass(request.AffectedSOPClassUID) || request.AffectedSOPClassUID != assoc.abstractSyntaxes[contextID] {
+        return statusSOPClassNotSupported
+    }
+
+    file := part10(request.AffectedSOPClassUID, request.AffectedSOPInstanceUID, assoc.transferSyntaxes[contextID], dataset)
+    uuid, err := s.ingester.IngestFile(bytes.NewReader(file), nil)
+    if err != nil {
+        s.logger.Printf("Error storing %s from %s: %v", request.AffectedSOPInstanceUID, assoc.callingAETitle, err)
+
+        var ingestErr *ingester.Error
+        switch {
+        case errors.Is(err, parser.ErrDuplicate):
+            return statusDuplicateSOPInstance
+        case errors.As(err, &ingestErr):
+            return statusOutOfResources
+        default:
+            return statusCannotUnderstand
+        }
+    }
+
+    s.logger.Printf("Stored %s from %s as %s", request.AffectedSOPInstanceUID, assoc.callingAETitle, uuid)
+    return statusSuccess
+}
+
+func reject(conn net.Conn, source byte, reason byte) error {
+    return writePDU(conn, pduAssociateRJ, []byte{0, rejectPermanent, source, reason})
+}
+
+func abort(conn net.Conn, source byte, reason byte) error {
+    return writePDU(conn, pduAbort, []byte{0, 0, source, reason})
+}
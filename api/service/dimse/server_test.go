@@ -0,0 +1,389 @@
+package dimse
+
+import (
+    "encoding/binary"
+    "image"
+    "log"
+    "net"
+    "os"
+    "testing"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+
+    "github.com/suyashkumar/dicom/pkg/uid"
+)
+
+const (
+    testSOPClassUID    = "1.2.840.10008.5.1.4.1.1.1.1.1"
+    testSOPInstanceUID = "1.2.826.0.1.3680043.2.1074.5931521980486637439720462877894121211"
+)
+
+// testSCU is a minimal SCU used to echo and push files to the server under test
+type testSCU struct {
+    t    *testing.T
+    conn net.Conn
+}
+
+func newTestServer(t *testing.T, inserted *int) *DicomServer {
+    mockSQLRepo := &sql.MockRepository{
+        InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+            *inserted++
+            return 1, nil
+        },
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        WritePngToFileFunc: func(image.Image, string) error {
+            return nil
+        },
+    }
+    dicomIngester := ingester.NewDicomIngester(
+        parser.NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default()),
+        processor.NewDicomProcessor(mockSQLRepo, mockBlobRepo, log.Default()),
+        log.Default(),
+    )
+
+    return NewDicomServer(dicomIngester, "DICOM", log.Default())
+}
+
+// serveTestServer starts the server on a free port and returns its address
+func serveTestServer(t *testing.T, server *DicomServer) string {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Failed to listen: %v", err)
+    }
+    go server.Serve(listener)
+    t.Cleanup(func() { server.Close() })
+
+    return listener.Addr().String()
+}
+
+func testAssociate(contexts ...presentationContext) *associate {
+    return &associate{
+        CalledAETitle:          "DICOM",
+        CallingAETitle:         "TESTSCU",
+        ApplicationContext:     applicationContextName,
+        Contexts:               contexts,
+        MaxLength:              16384,
+        ImplementationClassUID: implementationClassUID,
+    }
+}
+
+// associateSCU requests an association and returns the PDU the server answered with
+func associateSCU(t *testing.T, addr string, request *associate) (*testSCU, *pdu) {
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatalf("Failed to connect: %v", err)
+    }
+    t.Cleanup(func() { conn.Close() })
+
+    if err := writePDU(conn, pduAssociateRQ, request.encode(pduAssociateRQ)); err != nil {
+        t.Fatalf("Failed to send association request: %v", err)
+    }
+
+    response, err := readPDU(conn, maxPDULength)
+    if err != nil {
+        t.Fatalf("Failed to read association response: %v", err)
+    }
+    return &testSCU{t: t, conn: conn}, response
+}
+
+// store sends a C-STORE request, fragmented into small PDUs, and returns the response
+func (c *testSCU) store(contextID byte, dataset []byte) *command {
+    request := &command{
+        Field:                  commandCStoreRQ,
+        MessageID:              1,
+        AffectedSOPClassUID:    testSOPClassUID,
+        AffectedSOPInstanceUID: testSOPInstanceUID,
+        HasDataset:             true,
+    }
+    if err := writeMessage(c.conn, contextID, request.encode(), dataset, 4096); err != nil {
+        c.t.Fatalf("Failed to send C-STORE request: %v", err)
+    }
+    return c.response()
+}
+
+// echo sends a C-ECHO request and returns the response
+func (c *testSCU) echo(contextID byte) *command {
+    request := &command{
+        Field:               commandCEchoRQ,
+        MessageID:           2,
+        AffectedSOPClassUID: uid.VerificationSOPClass,
+    }
+    if err := writeMessage(c.conn, contextID, request.encode(), nil, 4096); err != nil {
+        c.t.Fatalf("Failed to send C-ECHO request: %v", err)
+    }
+    return c.response()
+}
+
+func (c *testSCU) response() *command {
+    message, err := readPDU(c.conn, maxPDULength)
+    if err != nil || message.Type != pduDataTF {
+        c.t.Fatalf("Failed to read response: %v", err)
+    }
+    pdvs, err := decodePDVs(message.Data)
+    if err != nil || len(pdvs) != 1 || !pdvs[0].Command || !pdvs[0].Last {
+        c.t.Fatalf("Unexpected response: %v", err)
+    }
+    response, err := decodeCommand(pdvs[0].Data)
+    if err != nil {
+        c.t.Fatalf("Failed to decode response: %v", err)
+    }
+    return response
+}
+
+func (c *testSCU) release() {
+    if err := writePDU(c.conn, pduReleaseRQ, make([]byte, 4)); err != nil {
+        c.t.Fatalf("Failed to send release request: %v", err)
+    }
+    response, err := readPDU(c.conn, maxPDULength)
+    if err != nil || response.Type != pduReleaseRP {
+        c.t.Fatalf("Expected release response, got %v, %v", response, err)
+    }
+}
+
+// testDataset returns the dataset of the test file without its preamble and file meta information
+func testDataset(t *testing.T) []byte {
+    data, err := os.ReadFile("../parser/test_file.dcm")
+    if err != nil {
+        t.Fatalf("Failed to read test file: %v", err)
+    }
+    // (0002,0000) UL group length is the first element after the magic word
+    groupLength := binary.LittleEndian.Uint32(data[128+4+8:])
+    return data[128+4+12+int(groupLength):]
+}
+
+func TestDicomServer_CStore(t *testing.T) {
+    inserted := 0
+    addr := serveTestServer(t, newTestServer(t, &inserted))
+
+    scu, response := associateSCU(t, addr, testAssociate(
+        presentationContext{ID: 1, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{"1.2.840.10008.1.2.2", uid.ExplicitVRLittleEndian}},
+        presentationContext{ID: 3, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{"1.2.840.10008.1.2.2"}},
+        presentationContext{ID: 5, AbstractSyntax: "1.2.840.10008.5.1.4.1.2.2.1", TransferSyntaxes: []string{uid.ImplicitVRLittleEndian}},
+    ))
+    if response.Type != pduAssociateAC {
+        t.Fatalf("Expected association to be accepted, got PDU type %d", response.Type)
+    }
+    accepted, err := decodeAssociate(response.Data)
+    if err != nil {
+        t.Fatalf("Failed to decode association response: %v", err)
+    }
+
+    expected := map[byte]byte{1: contextAccepted, 3: contextTransferSyntaxUnsupported, 5: contextAbstractSyntaxUnsupported}
+    for _, context := range accepted.Contexts {
+        if context.Result != expected[context.ID] {
+            t.Errorf("Presentation context %d: expected result %d, got %d", context.ID, expected[context.ID], context.Result)
+        }
+        if context.ID == 1 && (len(context.TransferSyntaxes) != 1 || context.TransferSyntaxes[0] != uid.ExplicitVRLittleEndian) {
+            t.Errorf("Expected explicit VR little endian to be accepted, got %v", context.TransferSyntaxes)
+        }
+    }
+
+    stored := scu.store(1, testDataset(t))
+    if stored.Field != commandCStoreRSP || stored.Status != statusSuccess || stored.MessageIDBeingRespondedTo != 1 {
+        t.Errorf("Unexpected C-STORE response %+v", stored)
+    }
+    if stored.AffectedSOPInstanceUID != testSOPInstanceUID {
+        t.Errorf("Expected response for %s, got %s", testSOPInstanceUID, stored.AffectedSOPInstanceUID)
+    }
+    if inserted != 1 {
+        t.Errorf("Expected the instance to be ingested once, got %d", inserted)
+    }
+
+    failed := scu.store(1, []byte("not a dataset"))
+    if failed.Status != statusCannotUnderstand {
+        t.Errorf("Expected status %04X for an invalid dataset, got %04X", statusCannotUnderstand, failed.Status)
+    }
+
+    scu.release()
+}
+
+func TestDicomServer_ContextMismatch(t *testing.T) {
+    inserted := 0
+    addr := serveTestServer(t, newTestServer(t, &inserted))
+
+    scu, response := associateSCU(t, addr, testAssociate(
+        presentationContext{ID: 1, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{uid.ExplicitVRLittleEndian}},
+        presentationContext{ID: 3, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{uid.ImplicitVRLittleEndian}},
+    ))
+    if response.Type != pduAssociateAC {
+        t.Fatalf("Expected association to be accepted, got PDU type %d", response.Type)
+    }
+
+    // The command is sent on context 1 and its dataset on context 3
+    request := &command{
+        Field:                  commandCStoreRQ,
+        MessageID:              1,
+        AffectedSOPClassUID:    testSOPClassUID,
+        AffectedSOPInstanceUID: testSOPInstanceUID,
+        HasDataset:             true,
+    }
+    writePDU(scu.conn, pduDataTF, pdv{ContextID: 1, Command: true, Last: true, Data: request.encode()}.encode())
+    writePDU(scu.conn, pduDataTF, pdv{ContextID: 3, Last: true, Data: testDataset(t)}.encode())
+
+    aborted, err := readPDU(scu.conn, maxPDULength)
+    if err != nil || aborted.Type != pduAbort {
+        t.Errorf("Expected abort, got %v, %v", aborted, err)
+    }
+    if inserted != 0 {
+        t.Errorf("Expected nothing to be ingested, got %d", inserted)
+    }
+}
+
+func TestDicomServer_MaxDatasetSize(t *testing.T) {
+    inserted := 0
+    server := newTestServer(t, &inserted)
+    dataset := testDataset(t)
+    server.SetMaxDatasetSize(int64(len(dataset) - 1))
+    addr := serveTestServer(t, server)
+
+    scu, response := associateSCU(t, addr, testAssociate(
+        presentationContext{ID: 1, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{uid.ExplicitVRLittleEndian}},
+    ))
+    if response.Type != pduAssociateAC {
+        t.Fatalf("Expected association to be accepted, got PDU type %d", response.Type)
+    }
+
+    request := &command{
+        Field:                  commandCStoreRQ,
+        MessageID:              1,
+        AffectedSOPClassUID:    testSOPClassUID,
+        AffectedSOPInstanceUID: testSOPInstanceUID,
+        HasDataset:             true,
+    }
+    // The server aborts before the last fragments, which may no longer be accepted
+    writeMessage(scu.conn, 1, request.encode(), dataset, 4096)
+
+    for {
+        message, err := readPDU(scu.conn, maxPDULength)
+        if err != nil {
+            t.Fatalf("Expected abort, got %v", err)
+        }
+        if message.Type == pduAbort {
+            break
+        }
+    }
+    if inserted != 0 {
+        t.Errorf("Expected nothing to be ingested, got %d", inserted)
+    }
+}
+
+func TestDicomServer_RejectsApplicationContext(t *testing.T) {
+    inserted := 0
+    addr := serveTestServer(t, newTestServer(t, &inserted))
+
+    request := testAssociate(presentationContext{ID: 1, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{uid.ExplicitVRLittleEndian}})
+    request.ApplicationContext = "1.2.3.4"
+    _, response := associateSCU(t, addr, request)
+    if response.Type != pduAssociateRJ {
+        t.Fatalf("Expected association to be rejected, got PDU type %d", response.Type)
+    }
+    if response.Data[3] != rejectReasonApplicationContext {
+        t.Errorf("Expected reason %d, got %d", rejectReasonApplicationContext, response.Data[3])
+    }
+}
+
+func TestDicomServer_UnexpectedPDU(t *testing.T) {
+    inserted := 0
+    addr := serveTestServer(t, newTestServer(t, &inserted))
+
+    conn, err := net.Dial("tcp", addr)
+    if err != nil {
+        t.Fatalf("Failed to connect: %v", err)
+    }
+    defer conn.Close()
+
+    writePDU(conn, pduReleaseRQ, make([]byte, 4))
+    response, err := readPDU(conn, maxPDULength)
+    if err != nil || response.Type != pduAbort {
+        t.Errorf("Expected abort, got %v, %v", response, err)
+    }
+}
+
+func TestDicomServer_CEcho(t *testing.T) {
+    inserted := 0
+    addr := serveTestServer(t, newTestServer(t, &inserted))
+
+    scu, response := associateSCU(t, addr, testAssociate(
+        presentationContext{ID: 1, AbstractSyntax: uid.VerificationSOPClass, TransferSyntaxes: []string{uid.ImplicitVRLittleEndian}},
+        presentationContext{ID: 3, AbstractSyntax: testSOPClassUID, TransferSyntaxes: []string{uid.ExplicitVRLittleEndian}},
+    ))
+    if response.Type != pduAssociateAC {
+        t.Fatalf("Expected association to be accepted, got PDU type %d", response.Type)
+    }
+
+    echoed := scu.echo(1)
+    if echoed.Field != commandCEchoRSP || echoed.Status != statusSuccess || echoed.MessageIDBeingRespondedTo != 2 {
+        t.Errorf("Unexpected C-ECHO response %+v", echoed)
+    }
+    if echoed.AffectedSOPClassUID != uid.VerificationSOPClass || echoed.HasDataset {
+        t.Errorf("Unexpected C-ECHO response %+v", echoed)
+    }
+
+    // Only the verification presentation context can be echoed on
+    if wrongContext := scu.echo(3); wrongContext.Status != statusSOPClassNotSupported {
+        t.Errorf("Expected status %04X, got %04X", statusSOPClassNotSupported, wrongContext.Status)
+    }
+
+    scu.release()
+}
+
+func TestDicomServer_AETitles(t *testing.T) {
+    tests := []struct {
+        calling string
+        called  string
+        reason  byte
+    }{
+        {calling: "MODALITY", called: "ARCHIVE"},
+        {calling: "UNKNOWN", called: "ARCHIVE", reason: rejectReasonCallingAETitle},
+        {calling: "MODALITY", called: "OTHER", reason: rejectReasonCalledAETitle},
+    }
+
+    inserted := 0
+    server := newTestServer(t, &inserted)
+    server.SetAllowedAETitles([]string{"MODALITY", "WORKSTATION"}, []string{"ARCHIVE"})
+    addr := serveTestServer(t, server)
+
+    for _, test := range tests {
+        request := testAssociate(presentationContext{ID: 1, AbstractSyntax: uid.VerificationSOPClass, TransferSyntaxes: []string{uid.ImplicitVRLittleEndian}})
+        request.CallingAETitle = test.calling
+        request.CalledAETitle = test.called
+
+        _, response := associateSCU(t, addr, request)
+        if test.reason == 0 {
+            if response.Type != pduAssociateAC {
+                t.Errorf("%s -> %s: expected association to be accepted, got PDU type %d", test.calling, test.called, response.Type)
+            }
+            continue
+        }
+        if response.Type != pduAssociateRJ || response.Data[2] != rejectSourceServiceUser || response.Data[3] != test.reason {
+            t.Errorf("%s -> %s: expected rejection with reason %d, got PDU type %d %v", test.calling, test.called, test.reason, response.Type, response.Data)
+        }
+    }
+}
+
+func TestCommand_EncodeDecode(t *testing.T) {
+    original := &command{
+        Field:                     commandCStoreRSP,
+        MessageIDBeingRespondedTo: 7,
+        AffectedSOPClassUID:       testSOPClassUID,
+        AffectedSOPInstanceUID:    testSOPInstanceUID,
+        Status:                    statusOutOfResources,
+    }
+
+    decoded, err := decodeCommand(original.encode())
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if *decoded != *original {
+        t.Errorf("Expected %+v, got %+v", original, decoded)
+    }
+}
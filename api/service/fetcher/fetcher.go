@@ -1,14 +1,33 @@
 package fetcher
 
 import (
+    dbsql "database/sql"
+    "errors"
+    "fmt"
     "image"
+    "io"
+    "io/fs"
     "log"
+    "regexp"
+    "strconv"
+    "strings"
 
     "dicom/api/model"
     "dicom/api/repository/blob"
     "dicom/api/repository/sql"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+    "dicom/api/service/renderer"
+
+    "github.com/suyashkumar/dicom/pkg/tag"
 )
 
+// ErrNotFound is returned when what is asked for was never stored
+var ErrNotFound = errors.New("not found")
+
+// bulkDataKey matches the keys of model.BulkDataKey, which never leave the blob directory
+var bulkDataKey = regexp.MustCompile(`^[0-9A-Fa-f]{8}(\.[0-9]+\.[0-9A-Fa-f]{8})*$`)
+
 type Fetcher interface {
     GetImage(uuid string) (image.Image, error)
 }
@@ -16,27 +35,56 @@ type Fetcher interface {
 type DicomFetcher struct {
     blobStorage blob.Repository
     sql         sql.Repository
+    renderer    *renderer.DicomRenderer
     logger      *log.Logger
 }
 
-func NewDicomFetcher(blobStorage blob.Repository, sql sql.Repository, logger *log.Logger) *DicomFetcher {
+func NewDicomFetcher(blobStorage blob.Repository, sql sql.Repository, dicomRenderer *renderer.DicomRenderer, logger *log.Logger) *DicomFetcher {
     return &DicomFetcher{
         blobStorage: blobStorage,
         sql:          sql,
+        renderer:     dicomRenderer,
         logger:       logger,
     }
 }
 
 func (d *DicomFetcher) GetImage(uuid string) (image.Image, error) {
-    dicom, err := d.sql.GetDicomByUUID(uuid)
+    instance, err := d.sql.GetDicomByUUID(uuid)
     if err != nil {
         d.logger.Printf("Error retrieving DICOM by UUID: %v", err)
         return nil, err
     }
 
-    img, err := d.blobStorage.ReadImageFromFile(dicom.ImageURL)
+    // Files ingested before originals were kept have their image rendered once at ingest, newer files are
+    // rendered from their original and have no image of their own
+    if instance.FileURL == "" {
+        if instance.ImageURL == "" {
+            return nil, fmt.Errorf("%w: DICOM file %s has no image", ErrNotFound, uuid)
+        }
+        img, err := d.blobStorage.ReadImageFromFile(instance.ImageURL)
+        if err != nil {
+            d.logger.Printf("Error reading image from file: %v", err)
+            return nil, err
+        }
+        return img, nil
+    }
+
+    file, err := d.blobStorage.OpenFile(instance.FileURL)
+    if err != nil {
+        d.logger.Printf("Error opening DICOM file: %v", err)
+        return nil, err
+    }
+    defer file.Close()
+
+    dataset, err := parser.ParseDatasetFrom(file, -1)
+    if err != nil {
+        d.logger.Printf("Error parsing DICOM file: %v", err)
+        return nil, err
+    }
+
+    img, err := d.renderer.Render(&dataset, 1, renderer.Options{})
     if err != nil {
-        d.logger.Printf("Error reading image from file: %v", err)
+        d.logger.Printf("Error rendering DICOM image: %v", err)
         return nil, err
     }
 
@@ -51,4 +99,83 @@ func (d *DicomFetcher) GetTags(uuid string) ([]model.Tag, error) {
     }
 
     return tags, nil
-}
\ No newline at end of file
+}
+
+// GetFile opens the original DICOM file as it was received, the caller closes it
+func (d *DicomFetcher) GetFile(uuid string) (io.ReadCloser, error) {
+    instance, err := d.sql.GetDicomByUUID(uuid)
+    if errors.Is(err, dbsql.ErrNoRows) {
+        return nil, fmt.Errorf("%w: no DICOM file %s", ErrNotFound, uuid)
+    }
+    if err != nil {
+        d.logger.Printf("Error retrieving DICOM by UUID: %v", err)
+        return nil, err
+    }
+
+    // Files ingested before originals were kept only have their image and tags
+    if instance.FileURL == "" {
+        return nil, fmt.Errorf("%w: original of %s was not kept", ErrNotFound, uuid)
+    }
+
+    file, err := d.blobStorage.OpenFile(instance.FileURL)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, fmt.Errorf("%w: original of %s is missing", ErrNotFound, uuid)
+    }
+    if err != nil {
+        d.logger.Printf("Error opening DICOM file: %v", err)
+        return nil, err
+    }
+    return file, nil
+}
+
+// GetBulkData opens the binary value of a tag stored apart from the tags table, key being the last part
+// of its BulkDataURI. The caller closes it
+func (d *DicomFetcher) GetBulkData(uuid string, key string) (io.ReadCloser, error) {
+    key = strings.ToUpper(key)
+    if !bulkDataKey.MatchString(key) {
+        return nil, fmt.Errorf("%w: invalid bulk data key %q", ErrNotFound, key)
+    }
+
+    file, err := d.blobStorage.OpenFile(processor.BulkDataPath(uuid, key))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, fmt.Errorf("%w: no bulk data %s for %s", ErrNotFound, key, uuid)
+    }
+    if err != nil {
+        d.logger.Printf("Error opening bulk data: %v", err)
+        return nil, err
+    }
+    return file, nil
+}
+
+// GetTagTree returns the tags of a DICOM file with the items of every sequence nested below it
+func (d *DicomFetcher) GetTagTree(uuid string) ([]model.TagNode, error) {
+    tags, err := d.GetTags(uuid)
+    if err != nil {
+        return nil, err
+    }
+    return TagTree(tags), nil
+}
+
+// TagTree nests tags stored with the path of their sequence item below their sequences
+func TagTree(tags []model.Tag) []model.TagNode {
+    byPath := make(map[string][]model.Tag)
+    for _, t := range tags {
+        byPath[t.Path] = append(byPath[t.Path], t)
+    }
+    return tagNodes(byPath, "")
+}
+
+func tagNodes(byPath map[string][]model.Tag, path string) []model.TagNode {
+    nodes := make([]model.TagNode, 0, len(byPath[path]))
+    for _, t := range byPath[path] {
+        node := model.TagNode{Tag: t.Tag, VR: t.VR, Value: t.Value, Name: t.Name, BulkDataURI: t.BulkDataURI}
+        // Tags flattened by older versions have no item count in the value of their sequences
+        if count, err := strconv.Atoi(t.Value); err == nil && t.VR == tag.VRSequence.String() {
+            for i := 0; i < count; i++ {
+                node.Items = append(node.Items, tagNodes(byPath, model.ItemPath(path, t.Tag, i)))
+            }
+        }
+        nodes = append(nodes, node)
+    }
+    return nodes
+}
@@ -1,14 +1,19 @@
 package fetcher
 
 import (
+    dbsql "database/sql"
     "errors"
     "image"
+    "io"
     "log"
+    "os"
+    "strings"
     "testing"
 
     "dicom/api/model"
     "dicom/api/repository/blob"
     "dicom/api/repository/sql"
+    "dicom/api/service/renderer"
 )
 
 func TestDicomFetcher_GetImage_Success(t *testing.T) {
@@ -31,7 +36,7 @@ func TestDicomFetcher_GetImage_Success(t *testing.T) {
     }
 
     // Create the DicomFetcher with mock repositories
-    fetcher := NewDicomFetcher(mockBlobRepo, mockSQLRepo, log.Default())
+    fetcher := NewDicomFetcher(mockBlobRepo, mockSQLRepo, renderer.NewDicomRenderer(log.Default()), log.Default())
 
     // Test GetImage method
     imageData, err := fetcher.GetImage(mockUUID)
@@ -43,6 +48,44 @@ func TestDicomFetcher_GetImage_Success(t *testing.T) {
     }
 }
 
+func TestDicomFetcher_GetImage_RendersOriginal(t *testing.T) {
+    mockBlobRepo := &blob.MockRepository{
+        OpenFileFunc: func(path string) (io.ReadCloser, error) {
+            return os.Open(path)
+        },
+    }
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{FileURL: "../parser/test_file.dcm"}, nil
+        },
+    }
+
+    fetcher := NewDicomFetcher(mockBlobRepo, mockSQLRepo, renderer.NewDicomRenderer(log.Default()), log.Default())
+
+    imageData, err := fetcher.GetImage("mock_uuid")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if bounds := imageData.Bounds(); bounds.Dx() != 1184 || bounds.Dy() != 1537 {
+        t.Errorf("Expected a 1184x1537 image, got %v", bounds)
+    }
+}
+
+func TestDicomFetcher_GetImage_NotFound(t *testing.T) {
+    // A file still being ingested has neither an original nor a legacy image
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{UUID: uuid}, nil
+        },
+    }
+
+    fetcher := NewDicomFetcher(&blob.MockRepository{}, mockSQLRepo, nil, log.Default())
+
+    if _, err := fetcher.GetImage("mock_uuid"); !errors.Is(err, ErrNotFound) {
+        t.Errorf("Expected ErrNotFound, got %v", err)
+    }
+}
+
 func TestDicomFetcher_GetTags_Success(t *testing.T) {
     mockUUID := "mock_uuid"
 
@@ -57,7 +100,7 @@ func TestDicomFetcher_GetTags_Success(t *testing.T) {
     }
 
     // Create the DicomFetcher with mock repository
-    fetcher := NewDicomFetcher(nil, mockSQLRepo, log.Default())
+    fetcher := NewDicomFetcher(nil, mockSQLRepo, nil, log.Default())
 
     // Test GetTags method
     tags, err := fetcher.GetTags(mockUUID)
@@ -71,3 +114,92 @@ func TestDicomFetcher_GetTags_Success(t *testing.T) {
         t.Errorf("Unexpected tags: %+v", tags)
     }
 }
+
+func TestTagTree(t *testing.T) {
+    tags := []model.Tag{
+        {Tag: "(0010,0020)", VR: "VRString", Value: "[5184]"},
+        {Tag: "(0008,1140)", VR: "VRSequence", Value: "2"},
+        {Tag: "(0008,1155)", VR: "VRUID", Value: "[1.2.3]", Path: "(0008,1140)[0]"},
+        {Tag: "(0008,1155)", VR: "VRUID", Value: "[1.2.4]", Path: "(0008,1140)[1]", Item: 1},
+        {Tag: "(0008,1160)", VR: "VRStringList", Value: "[1]", Path: "(0008,1140)[1]", Item: 1},
+    }
+
+    tree := TagTree(tags)
+    if len(tree) != 2 {
+        t.Fatalf("Expected 2 top level tags, got %+v", tree)
+    }
+    items := tree[1].Items
+    if len(items) != 2 || len(items[0]) != 1 || len(items[1]) != 2 {
+        t.Fatalf("Expected a sequence of 2 items, got %+v", items)
+    }
+    if items[1][0].Value != "[1.2.4]" {
+        t.Errorf("Expected the UID of the second item, got %+v", items[1][0])
+    }
+}
+
+func TestDicomFetcher_GetBulkData(t *testing.T) {
+    mockBlobRepo := &blob.MockRepository{
+        OpenFileFunc: func(path string) (io.ReadCloser, error) {
+            if path == "output/bulkdata_mock_uuid_7FE00010.bin" {
+                return io.NopCloser(strings.NewReader("pixels")), nil
+            }
+            return nil, os.ErrNotExist
+        },
+    }
+    fetcher := NewDicomFetcher(mockBlobRepo, nil, nil, log.Default())
+
+    file, err := fetcher.GetBulkData("mock_uuid", "7FE00010")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    defer file.Close()
+    if data, _ := io.ReadAll(file); string(data) != "pixels" {
+        t.Errorf("Unexpected bulk data %q", data)
+    }
+
+    for _, key := range []string{"00282000", "../../etc/passwd", "7FE00010.x"} {
+        if _, err := fetcher.GetBulkData("mock_uuid", key); !errors.Is(err, ErrNotFound) {
+            t.Errorf("Expected ErrNotFound for %q, got %v", key, err)
+        }
+    }
+}
+
+func TestDicomFetcher_GetFile(t *testing.T) {
+    stored := map[string]*model.Dicom{
+        "mock_uuid":   {UUID: "mock_uuid", FileURL: "output/dicom_mock_uuid.dcm"},
+        "legacy_uuid": {UUID: "legacy_uuid", ImageURL: "output/image_legacy_uuid.png"},
+        "lost_uuid":   {UUID: "lost_uuid", FileURL: "output/dicom_lost_uuid.dcm"},
+    }
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            if dicom, ok := stored[uuid]; ok {
+                return dicom, nil
+            }
+            return nil, dbsql.ErrNoRows
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        OpenFileFunc: func(path string) (io.ReadCloser, error) {
+            if path == "output/dicom_mock_uuid.dcm" {
+                return io.NopCloser(strings.NewReader("DICM")), nil
+            }
+            return nil, os.ErrNotExist
+        },
+    }
+    fetcher := NewDicomFetcher(mockBlobRepo, mockSQLRepo, nil, log.Default())
+
+    file, err := fetcher.GetFile("mock_uuid")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    defer file.Close()
+    if data, _ := io.ReadAll(file); string(data) != "DICM" {
+        t.Errorf("Unexpected file %q", data)
+    }
+
+    for _, uuid := range []string{"unknown_uuid", "legacy_uuid", "lost_uuid"} {
+        if _, err := fetcher.GetFile(uuid); !errors.Is(err, ErrNotFound) {
+            t.Errorf("Expected ErrNotFound for %s, got %v", uuid, err)
+        }
+    }
+}
@@ -0,0 +1,205 @@
+package fetcher
+
+import (
+    "errors"
+    "fmt"
+    "net/url"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    "dicom/api/model"
+
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const (
+    // DefaultSearchLimit is the number of matches returned when the search does not set a limit
+    DefaultSearchLimit = 100
+    // MaxSearchLimit is the largest number of matches a search can return at once
+    MaxSearchLimit = 1000
+)
+
+// ErrInvalidSearch is returned by ParseTagQuery for a tag search naming an unknown tag, a value that does
+// not fit the VR of its tag or a malformed limit or offset
+var ErrInvalidSearch = errors.New("invalid tag search")
+
+// tagKey matches a tag written as (gggg,eeee) or ggggeeee
+var tagKey = regexp.MustCompile(`^\(?([0-9A-Fa-f]{4}),?([0-9A-Fa-f]{4})\)?$`)
+
+// numericVRs are compared as numbers
+var numericVRs = map[string]bool{"IS": true, "DS": true, "SS": true, "US": true, "SL": true, "UL": true, "SV": true, "UV": true, "FL": true, "FD": true}
+
+// searchTag is a tag named in a search
+type searchTag struct {
+    tag string
+    // vr is empty for tags outside the dictionary, private tags among them
+    vr string
+}
+
+// ParseTagQuery parses a search of top level tags. Every parameter but limit, offset and includefield names
+// a tag by keyword or as (gggg,eeee), and its values are predicates the tag must all match:
+//
+//   - SIEMENS matches the value, numbers, dates and times as such and text exactly
+//   - SIEM* matches text with * for any characters and ? for one, ignoring case
+//   - <2, <=2, >2 and >=2 compare numbers, dates and times, and text otherwise
+//   - * matches files that have the tag
+//
+// A predicate starting with ! matches the files the rest of it does not. includefield lists, separated by
+// commas, the tags returned with each file besides those searched for
+func ParseTagQuery(params url.Values) (*model.TagQuery, error) {
+    query := &model.TagQuery{Limit: DefaultSearchLimit}
+    included := map[string]bool{}
+
+    keys := make([]string, 0, len(params))
+    for key := range params {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        values := params[key]
+        switch key {
+        case "limit", "offset":
+            number, err := strconv.Atoi(values[0])
+            if err != nil || number < 0 || (key == "limit" && (number == 0 || number > MaxSearchLimit)) {
+                return nil, fmt.Errorf("%w: invalid %s %q", ErrInvalidSearch, key, values[0])
+            }
+            if key == "limit" {
+                query.Limit = number
+            } else {
+                query.Offset = number
+            }
+            continue
+        case "includefield":
+            for _, value := range values {
+                for _, field := range strings.Split(value, ",") {
+                    t, err := findSearchTag(strings.TrimSpace(field))
+                    if err != nil {
+                        return nil, err
+                    }
+                    included[t.tag] = true
+                }
+            }
+            continue
+        }
+
+        t, err := findSearchTag(key)
+        if err != nil {
+            return nil, err
+        }
+        for _, value := range values {
+            predicate, err := parsePredicate(t, value)
+            if err != nil {
+                return nil, err
+            }
+            query.Predicates = append(query.Predicates, predicate)
+        }
+        included[t.tag] = true
+    }
+
+    for t := range included {
+        query.Include = append(query.Include, t)
+    }
+    sort.Strings(query.Include)
+    return query, nil
+}
+
+// findSearchTag looks a tag up by keyword or by its group and element
+func findSearchTag(key string) (searchTag, error) {
+    if match := tagKey.FindStringSubmatch(key); match != nil {
+        group, _ := strconv.ParseUint(match[1], 16, 16)
+        element, _ := strconv.ParseUint(match[2], 16, 16)
+        t := tag.Tag{Group: uint16(group), Element: uint16(element)}
+        info, err := tag.Find(t)
+        if err != nil {
+            return searchTag{tag: t.String()}, nil
+        }
+        return searchTag{tag: t.String(), vr: dictionaryVR(info)}, nil
+    }
+
+    info, err := tag.FindByName(key)
+    if err != nil {
+        return searchTag{}, fmt.Errorf("%w: unknown tag %q", ErrInvalidSearch, key)
+    }
+    return searchTag{tag: info.Tag.String(), vr: dictionaryVR(info)}, nil
+}
+
+// dictionaryVR is the VR of a tag, the first one for tags with alternatives like "US or SS"
+func dictionaryVR(info tag.Info) string {
+    if vrs := strings.Fields(info.VR); len(vrs) > 0 {
+        return vrs[0]
+    }
+    return ""
+}
+
+func parsePredicate(t searchTag, value string) (model.TagPredicate, error) {
+    predicate := model.TagPredicate{Tag: t.tag, Operator: model.TagEqual}
+    if strings.HasPrefix(value, "!") {
+        predicate.Negate = true
+        value = value[1:]
+    }
+
+    if value == "*" {
+        predicate.Operator = model.TagPresent
+        return predicate, nil
+    }
+    for _, operator := range []model.TagOperator{model.TagLessOrEqual, model.TagGreaterOrEqual, model.TagLess, model.TagGreater} {
+        if strings.HasPrefix(value, string(operator)) {
+            predicate.Operator = operator
+            value = value[len(operator):]
+            break
+        }
+    }
+    if predicate.Operator == model.TagEqual && strings.ContainsAny(value, "*?") {
+        predicate.Operator = model.TagWildcard
+        predicate.Value = value
+        return predicate, nil
+    }
+
+    operand, err := searchOperand(t, value, predicate.Operator != model.TagEqual)
+    if err != nil {
+        return predicate, err
+    }
+    predicate.Value = operand
+    return predicate, nil
+}
+
+// searchOperand types a value after the VR of the tag. Tags outside the dictionary are compared as
+// numbers when the value is one, and as text otherwise
+func searchOperand(t searchTag, value string, comparison bool) (interface{}, error) {
+    var operand interface{}
+    var err error
+    switch {
+    case t.vr == "DA":
+        operand, err = model.ParseDate(value)
+    case t.vr == "TM":
+        operand, err = model.ParseTime(value)
+    case t.vr == "DT":
+        operand, err = model.ParseDateTime(value)
+    case numericVRs[t.vr]:
+        operand, err = strconv.ParseFloat(value, 64)
+    case t.vr == "" && comparison:
+        if number, err := strconv.ParseFloat(value, 64); err == nil {
+            return number, nil
+        }
+        return value, nil
+    default:
+        return value, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("%w: invalid %s value %q for %s", ErrInvalidSearch, t.vr, value, t.tag)
+    }
+    return operand, nil
+}
+
+// SearchTags returns the DICOM files matching a query with the tags it includes
+func (d *DicomFetcher) SearchTags(query *model.TagQuery) ([]model.SearchMatch, error) {
+    matches, err := d.sql.SearchTags(*query)
+    if err != nil {
+        d.logger.Printf("Error searching tags: %v", err)
+        return nil, err
+    }
+    return matches, nil
+}
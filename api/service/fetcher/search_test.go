@@ -0,0 +1,53 @@
+package fetcher
+
+import (
+    "errors"
+    "net/url"
+    "reflect"
+    "testing"
+    "time"
+
+    "dicom/api/model"
+)
+
+func TestParseTagQuery(t *testing.T) {
+    params, _ := url.ParseQuery("Manufacturer=SIEM*&(0018,0050)=>=1.5&StudyDate=!<20200102&00091001=*&00091002=>3&Modality=CT&includefield=PatientName,00100020&offset=20")
+    query, err := ParseTagQuery(params)
+    if err != nil {
+        t.Fatalf("ParseTagQuery failed: %v", err)
+    }
+
+    expected := &model.TagQuery{
+        Predicates: []model.TagPredicate{
+            {Tag: "(0018,0050)", Operator: model.TagGreaterOrEqual, Value: 1.5},
+            {Tag: "(0009,1001)", Operator: model.TagPresent},
+            {Tag: "(0009,1002)", Operator: model.TagGreater, Value: 3.0},
+            {Tag: "(0008,0070)", Operator: model.TagWildcard, Value: "SIEM*"},
+            {Tag: "(0008,0060)", Operator: model.TagEqual, Value: "CT"},
+            {Tag: "(0008,0020)", Operator: model.TagLess, Value: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Negate: true},
+        },
+        Include: []string{"(0008,0020)", "(0008,0060)", "(0008,0070)", "(0009,1001)", "(0009,1002)", "(0010,0010)", "(0010,0020)", "(0018,0050)"},
+        Limit:   DefaultSearchLimit,
+        Offset:  20,
+    }
+    if !reflect.DeepEqual(query, expected) {
+        t.Errorf("Expected %+v, got %+v", expected, query)
+    }
+}
+
+func TestParseTagQuery_Invalid(t *testing.T) {
+    for _, rawQuery := range []string{
+        "NotATag=1",
+        "SliceThickness=thick",
+        "StudyDate=>yesterday",
+        "includefield=NotATag",
+        "limit=0",
+        "limit=1001",
+        "offset=-1",
+    } {
+        params, _ := url.ParseQuery(rawQuery)
+        if _, err := ParseTagQuery(params); !errors.Is(err, ErrInvalidSearch) {
+            t.Errorf("Expected ErrInvalidSearch for %s, got %v", rawQuery, err)
+        }
+    }
+}
@@ -0,0 +1,116 @@
+package importer
+
+import (
+    "io/fs"
+    "log"
+    "path/filepath"
+    "sort"
+    "sync"
+
+    "dicom/api/model"
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+)
+
+// dicomDirName is the fixed file name of a DICOMDIR, which indexes a media tree rather than holding an image
+const dicomDirName = "DICOMDIR"
+
+type Importer interface {
+    ImportDirectory(root string) (*model.BatchResult, error)
+}
+
+// DicomImporter discovers DICOM files below a directory and ingests them with a bounded number of workers
+type DicomImporter struct {
+    ingester *ingester.DicomIngester
+    workers  int
+    logger   *log.Logger
+}
+
+func NewDicomImporter(dicomIngester *ingester.DicomIngester, workers int, logger *log.Logger) *DicomImporter {
+    if workers < 1 {
+        workers = 1
+    }
+
+    return &DicomImporter{
+        ingester: dicomIngester,
+        workers:  workers,
+        logger:   logger,
+    }
+}
+
+// ImportDirectory walks the directory recursively and ingests every file recognized as DICOM by its
+// preamble, whatever its extension. Other files are skipped
+func (i *DicomImporter) ImportDirectory(root string) (*model.BatchResult, error) {
+    result := &model.BatchResult{
+        Succeeded: []model.IngestResult{},
+        Skipped:   []string{},
+        Failed:    []model.IngestResult{},
+    }
+
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    paths := make(chan string)
+
+    for w := 0; w < i.workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for path := range paths {
+                uuid, err := i.ingester.IngestPath(path, nil)
+
+                mu.Lock()
+                if err != nil {
+                    result.Failed = append(result.Failed, model.IngestResult{Path: path, Error: err.Error()})
+                } else {
+                    result.Succeeded = append(result.Succeeded, model.IngestResult{Path: path, ID: uuid})
+                }
+                mu.Unlock()
+            }
+        }()
+    }
+
+    err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+        if err != nil {
+            // The root itself has to be readable, anything below it is reported as failed
+            if path == root {
+                return err
+            }
+            mu.Lock()
+            result.Failed = append(result.Failed, model.IngestResult{Path: path, Error: err.Error()})
+            mu.Unlock()
+            return nil
+        }
+        if !entry.Type().IsRegular() {
+            return nil
+        }
+
+        isDicom, err := parser.IsDicomFile(path)
+        if err == nil && isDicom && entry.Name() != dicomDirName {
+            paths <- path
+            return nil
+        }
+
+        mu.Lock()
+        if err != nil {
+            result.Failed = append(result.Failed, model.IngestResult{Path: path, Error: err.Error()})
+        } else {
+            result.Skipped = append(result.Skipped, path)
+        }
+        mu.Unlock()
+        return nil
+    })
+    close(paths)
+    wg.Wait()
+
+    if err != nil {
+        i.logger.Printf("Error walking directory: %v", err)
+        return nil, err
+    }
+
+    // Workers finish in any order, report files in a stable one
+    sort.Slice(result.Succeeded, func(a, b int) bool { return result.Succeeded[a].Path < result.Succeeded[b].Path })
+    sort.Slice(result.Failed, func(a, b int) bool { return result.Failed[a].Path < result.Failed[b].Path })
+
+    i.logger.Printf("Imported directory %s: %d succeeded, %d skipped, %d failed", root, len(result.Succeeded), len(result.Skipped), len(result.Failed))
+    return result, nil
+}
@@ -0,0 +1,77 @@
+package importer
+
+import (
+    "image"
+    "log"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+)
+
+func copyFile(t *testing.T, src, dst string) {
+    data, err := os.ReadFile(src)
+    if err != nil {
+        t.Fatalf("Failed to read %s: %v", src, err)
+    }
+    if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+        t.Fatalf("Failed to create directory: %v", err)
+    }
+    if err := os.WriteFile(dst, data, 0644); err != nil {
+        t.Fatalf("Failed to write %s: %v", dst, err)
+    }
+}
+
+func TestDicomImporter_ImportDirectory(t *testing.T) {
+    root := t.TempDir()
+    copyFile(t, "../parser/test_file.dcm", filepath.Join(root, "IM000001"))
+    copyFile(t, "../parser/test_file.dcm", filepath.Join(root, "SE000002", "IM000002"))
+    copyFile(t, "../../../test-xray/DICOMDIR", filepath.Join(root, "DICOMDIR"))
+    copyFile(t, "importer.go", filepath.Join(root, "SE000002", "notes.txt"))
+    // A file with a DICOM preamble that fails to parse
+    os.WriteFile(filepath.Join(root, "broken.dcm"), append(make([]byte, 128), []byte("DICMbroken")...), 0644)
+
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        WritePngToFileFunc: func(image.Image, string) error {
+            return nil
+        },
+    }
+    dicomIngester := ingester.NewDicomIngester(
+        parser.NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default()),
+        processor.NewDicomProcessor(mockSQLRepo, mockBlobRepo, log.Default()),
+        log.Default(),
+    )
+
+    result, err := NewDicomImporter(dicomIngester, 2, log.Default()).ImportDirectory(root)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    if len(result.Succeeded) != 2 || result.Succeeded[0].ID == "" {
+        t.Errorf("Expected 2 succeeded files, got %+v", result.Succeeded)
+    }
+    if len(result.Skipped) != 2 {
+        t.Errorf("Expected the DICOMDIR and the text file to be skipped, got %v", result.Skipped)
+    }
+    if len(result.Failed) != 1 || result.Failed[0].Path != filepath.Join(root, "broken.dcm") {
+        t.Errorf("Expected broken.dcm to fail, got %+v", result.Failed)
+    }
+}
+
+func TestDicomImporter_ImportDirectory_Error(t *testing.T) {
+    _, err := NewDicomImporter(nil, 1, log.Default()).ImportDirectory("non_existent_directory")
+    if err == nil {
+        t.Error("Expected error, got nil")
+    }
+}
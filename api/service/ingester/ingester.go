@@ -0,0 +1,108 @@
+package ingester
+
+import (
+    "errors"
+    "io"
+    "log"
+
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+)
+
+// ProgressFunc is told how far along an ingest is, from 0 to 1
+type ProgressFunc func(progress float64)
+
+type Ingester interface {
+    IngestPath(dicomFilePath string, progress ProgressFunc) (string, error)
+    IngestFile(file io.Reader, progress ProgressFunc) (string, error)
+}
+
+// DicomIngester runs a DICOM file through the whole pipeline: parsing, which keeps the original file,
+// and tag extraction. Images are rendered from the original when asked for
+type DicomIngester struct {
+    parser    *parser.DicomParser
+    processor *processor.DicomProcessor
+    logger    *log.Logger
+}
+
+func NewDicomIngester(dicomParser *parser.DicomParser, dicomProcessor *processor.DicomProcessor, logger *log.Logger) *DicomIngester {
+    return &DicomIngester{
+        parser:    dicomParser,
+        processor: dicomProcessor,
+        logger:    logger,
+    }
+}
+
+// IngestPath ingests the DICOM file at the path and returns its id
+func (i *DicomIngester) IngestPath(dicomFilePath string, progress ProgressFunc) (string, error) {
+    parsed, err := i.parser.GetDicomDatasetByPath(dicomFilePath)
+    if err != nil {
+        return existingDuplicate(err)
+    }
+
+    return i.Process(parsed, progress)
+}
+
+// IngestFile ingests the DICOM file read from the reader and returns its id
+func (i *DicomIngester) IngestFile(file io.Reader, progress ProgressFunc) (string, error) {
+    parsed, err := i.parser.GetDicomDatasetByFile(file)
+    if err != nil {
+        return existingDuplicate(err)
+    }
+
+    return i.Process(parsed, progress)
+}
+
+// Process stores the tags of an already parsed DICOM file and commits it, or discards the file when
+// that fails so it can be ingested again
+func (i *DicomIngester) Process(parsed *parser.ParsedDicom, progress ProgressFunc) (string, error) {
+    if progress == nil {
+        progress = func(float64) {}
+    }
+    progress(0.5)
+
+    // Extract tags from the dicom file
+    if err := i.processor.ExtractParsedHeaders(parsed); err != nil {
+        i.discard(parsed)
+        return "", &Error{Step: "Error extracting DICOM tags", Err: err}
+    }
+    if err := i.parser.Commit(parsed); err != nil {
+        i.discard(parsed)
+        return existingDuplicate(err)
+    }
+    progress(1)
+
+    return parsed.UUID, nil
+}
+
+// discard removes what was stored of a file whose ingest failed, its bulk data first as it is found
+// from its tags
+func (i *DicomIngester) discard(parsed *parser.ParsedDicom) {
+    if !parsed.Replaced {
+        i.processor.RemoveBulkData(parsed.UUID)
+    }
+    i.parser.Discard(parsed)
+}
+
+// existingDuplicate turns a duplicate that should resolve to the stored instance into its id
+func existingDuplicate(err error) (string, error) {
+    var duplicate *parser.DuplicateError
+    if errors.As(err, &duplicate) && duplicate.Policy == parser.DuplicateExisting {
+        return duplicate.UUID, nil
+    }
+    return "", err
+}
+
+// Error reports which step of the pipeline failed after the file was parsed
+type Error struct {
+    Step string
+    Err  error
+}
+
+func (e *Error) Error() string {
+    return e.Step + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+    return e.Err
+}
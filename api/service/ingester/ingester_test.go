@@ -0,0 +1,222 @@
+package ingester
+
+import (
+    "errors"
+    "image"
+    "io"
+    "log"
+    "reflect"
+    "sort"
+    "strings"
+    "testing"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+)
+
+func newTestIngester(sqlRepo *sql.MockRepository, blobRepo *blob.MockRepository) *DicomIngester {
+    return NewDicomIngester(
+        parser.NewDicomParser(sqlRepo, &blob.MockRepository{}, log.Default()),
+        processor.NewDicomProcessor(sqlRepo, blobRepo, log.Default()),
+        log.Default(),
+    )
+}
+
+func TestDicomIngester_IngestPath_Success(t *testing.T) {
+    tagsStored, committed := false, false
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            tagsStored = true
+            return nil
+        },
+        SetDicomFingerprintFunc: func(uuid string, sopInstanceUID string, fileHash string) error {
+            // The fingerprint is what makes the file a duplicate, it is only recorded once it is stored
+            committed = tagsStored
+            return nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        WritePngToFileFunc: func(image.Image, string) error {
+            return nil
+        },
+    }
+
+    var reported []float64
+    uuid, err := newTestIngester(mockSQLRepo, mockBlobRepo).IngestPath("../parser/test_file.dcm", func(progress float64) {
+        reported = append(reported, progress)
+    })
+    if err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+    if uuid == "" {
+        t.Error("Expected uuid, got ''")
+    }
+    if len(reported) != 2 || reported[1] != 1 {
+        t.Errorf("Expected progress to be reported for every step, got %v", reported)
+    }
+    if !committed {
+        t.Error("Expected the fingerprint to be recorded after the tags")
+    }
+}
+
+func TestDicomIngester_IngestPath_Error(t *testing.T) {
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            return errors.New("disk full")
+        },
+    }
+    fingerprinted := false
+    mockSQLRepo.SetDicomFingerprintFunc = func(uuid string, sopInstanceUID string, fileHash string) error {
+        fingerprinted = true
+        return nil
+    }
+    var discarded []string
+    mockSQLRepo.DeleteDicomFunc = func(uuid string) error {
+        discarded = append(discarded, uuid)
+        return nil
+    }
+    mockBlobRepo := &blob.MockRepository{}
+
+    _, err := newTestIngester(mockSQLRepo, mockBlobRepo).IngestPath("../parser/test_file.dcm", nil)
+    var ingestErr *Error
+    if !errors.As(err, &ingestErr) || ingestErr.Step != "Error extracting DICOM tags" {
+        t.Errorf("Expected the tags step to fail, got %v", err)
+    }
+    // A retry must not find the half ingested file as a duplicate
+    if fingerprinted || len(discarded) != 1 {
+        t.Errorf("Expected the file to be discarded without a fingerprint, got fingerprint %v, discarded %q", fingerprinted, discarded)
+    }
+
+    if _, err := newTestIngester(mockSQLRepo, mockBlobRepo).IngestPath("non_existent_file.dcm", nil); err == nil {
+        t.Error("Expected error, got nil")
+    }
+}
+
+func TestDicomIngester_IngestPath_Duplicate(t *testing.T) {
+    processed := false
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByFingerprintFunc: func(sopInstanceUID string, fileHash string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, UUID: "existing_uuid"}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            processed = true
+            return nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{}
+
+    // By default a duplicate resolves to the instance already stored without being processed again
+    uuid, err := newTestIngester(mockSQLRepo, mockBlobRepo).IngestPath("../parser/test_file.dcm", nil)
+    if err != nil || uuid != "existing_uuid" {
+        t.Errorf("Expected existing uuid, got %q, %v", uuid, err)
+    }
+    if processed {
+        t.Error("Expected the duplicate not to be processed")
+    }
+
+    ingester := newTestIngester(mockSQLRepo, mockBlobRepo)
+    ingester.parser.SetDuplicatePolicy(parser.DuplicateReject)
+    if _, err := ingester.IngestPath("../parser/test_file.dcm", nil); !errors.Is(err, parser.ErrDuplicate) {
+        t.Errorf("Expected duplicate error, got %v", err)
+    }
+}
+
+// replaceIngester ingests test_file.dcm as a replacement of existing_uuid, recording what is done to the
+// files of the blob repository
+func replaceIngester(insertTags func(dicomID int64, tags []model.Tag) error, written, moved, removed *[]string) *DicomIngester {
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByFingerprintFunc: func(sopInstanceUID string, fileHash string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, UUID: "existing_uuid"}, nil
+        },
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, UUID: uuid}, nil
+        },
+        InsertTagsFunc: insertTags,
+        DeleteDicomFunc: func(uuid string) error {
+            *removed = append(*removed, "row "+uuid)
+            return nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        WriteFileFunc: func(r io.Reader, path string) error {
+            *written = append(*written, path)
+            return nil
+        },
+        MoveFileFunc: func(from string, to string) error {
+            *moved = append(*moved, to)
+            return nil
+        },
+        DeleteFileFunc: func(path string) error {
+            *removed = append(*removed, path)
+            return nil
+        },
+    }
+
+    dicomParser := parser.NewDicomParser(mockSQLRepo, mockBlobRepo, log.Default())
+    dicomParser.SetDuplicatePolicy(parser.DuplicateReplace)
+    return NewDicomIngester(dicomParser, processor.NewDicomProcessor(mockSQLRepo, mockBlobRepo, log.Default()), log.Default())
+}
+
+func TestDicomIngester_Replace_Error(t *testing.T) {
+    var written, moved, removed []string
+    ingester := replaceIngester(func(int64, []model.Tag) error { return errors.New("disk full") }, &written, &moved, &removed)
+
+    if _, err := ingester.IngestPath("../parser/test_file.dcm", nil); err == nil {
+        t.Fatal("Expected error, got nil")
+    }
+
+    // The original and the pixel data of the new file were staged, the stored ones are left untouched
+    if len(written) != 2 {
+        t.Fatalf("Expected the original and its bulk data to be written, got %q", written)
+    }
+    for _, path := range written {
+        if !strings.Contains(path, ".staged_") {
+            t.Errorf("Expected %s to be staged", path)
+        }
+    }
+    if len(moved) != 0 {
+        t.Errorf("Expected no file to be replaced, got %q", moved)
+    }
+    // Both the processor and the parser clean up after themselves, removing a file twice is harmless
+    distinct := map[string]bool{}
+    for _, path := range removed {
+        distinct[path] = true
+    }
+    removed = nil
+    for path := range distinct {
+        removed = append(removed, path)
+    }
+    sort.Strings(written)
+    sort.Strings(removed)
+    if !reflect.DeepEqual(removed, written) {
+        t.Errorf("Expected only the staged files %q to be removed, got %q", written, removed)
+    }
+}
+
+func TestDicomIngester_Replace(t *testing.T) {
+    var written, moved, removed []string
+    ingester := replaceIngester(func(int64, []model.Tag) error { return nil }, &written, &moved, &removed)
+
+    uuid, err := ingester.IngestPath("../parser/test_file.dcm", nil)
+    if err != nil || uuid != "existing_uuid" {
+        t.Fatalf("Expected existing_uuid to be replaced, got %q, %v", uuid, err)
+    }
+
+    sort.Strings(moved)
+    expected := []string{processor.BulkDataPath("existing_uuid", "7FE00010"), "output/dicom_existing_uuid.dcm"}
+    if !reflect.DeepEqual(moved, expected) {
+        t.Errorf("Expected %q to be replaced, got %q", expected, moved)
+    }
+    if len(removed) != 0 {
+        t.Errorf("Expected nothing to be removed, got %q", removed)
+    }
+}
@@ -0,0 +1,216 @@
+package jobs
+
+import (
+    "context"
+    "errors"
+    "log"
+    "sync"
+    "time"
+
+    "dicom/api/common"
+    "dicom/api/model"
+)
+
+// retention is how long a finished job can still be looked up
+const retention = time.Hour
+
+var (
+    ErrJobNotFound = errors.New("job not found")
+    ErrQueueFull   = errors.New("job queue is full")
+    ErrQueueClosed = errors.New("job queue is closed")
+)
+
+// Task does the work of a job, reporting its progress from 0 to 1, and returns the id of the ingested DICOM file
+type Task func(progress func(float64)) (string, error)
+
+type Queue interface {
+    Enqueue(source string, task Task, discard func()) (*model.Job, error)
+    GetJob(id string) (*model.Job, error)
+    Close(ctx context.Context) error
+}
+
+type queuedJob struct {
+    id      string
+    task    Task
+    discard func()
+}
+
+// JobQueue runs tasks on a bounded pool of workers and keeps track of their state
+type JobQueue struct {
+    tasks    chan queuedJob
+    jobs     map[string]*model.Job
+    closed   bool
+    dropping bool
+    mu     sync.RWMutex
+    wg     sync.WaitGroup
+    logger *log.Logger
+}
+
+// NewJobQueue starts the given number of workers. At most queueSize jobs can be waiting for a worker
+func NewJobQueue(workers int, queueSize int, logger *log.Logger) *JobQueue {
+    q := &JobQueue{
+        tasks:  make(chan queuedJob, queueSize),
+        jobs:   make(map[string]*model.Job),
+        logger: logger,
+    }
+
+    for i := 0; i < workers; i++ {
+        q.wg.Add(1)
+        go q.work()
+    }
+
+    return q
+}
+
+// Enqueue adds a task to the queue and returns its job without waiting for it to run. discard is called
+// instead of the task when the queue is closed before the task started, to clean up what it would have
+// used. It can be nil
+func (q *JobQueue) Enqueue(source string, task Task, discard func()) (*model.Job, error) {
+    job := &model.Job{
+        ID:        common.GenShortUUID(),
+        State:     model.JobQueued,
+        Source:    source,
+        CreatedAt: time.Now(),
+    }
+
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if q.closed {
+        return nil, ErrQueueClosed
+    }
+
+    q.prune()
+
+    select {
+    case q.tasks <- queuedJob{id: job.ID, task: task, discard: discard}:
+    default:
+        q.logger.Printf("Job queue is full, rejecting job for: %s", source)
+        return nil, ErrQueueFull
+    }
+
+    q.jobs[job.ID] = job
+    snapshot := *job
+    return &snapshot, nil
+}
+
+// GetJob returns a snapshot of the job's state
+func (q *JobQueue) GetJob(id string) (*model.Job, error) {
+    q.mu.RLock()
+    defer q.mu.RUnlock()
+
+    job, ok := q.jobs[id]
+    if !ok {
+        return nil, ErrJobNotFound
+    }
+
+    snapshot := *job
+    return &snapshot, nil
+}
+
+// Close stops accepting jobs and waits for the queued ones to finish until ctx is done. The jobs that have
+// not started by then fail without running and are discarded, the running ones are not waited for
+func (q *JobQueue) Close(ctx context.Context) error {
+    q.mu.Lock()
+    if !q.closed {
+        q.closed = true
+        close(q.tasks)
+    }
+    q.mu.Unlock()
+
+    finished := make(chan struct{})
+    go func() {
+        q.wg.Wait()
+        close(finished)
+    }()
+
+    select {
+    case <-finished:
+        return nil
+    case <-ctx.Done():
+    }
+
+    q.mu.Lock()
+    q.dropping = true
+    q.mu.Unlock()
+    // Workers done with their job drop the next ones too
+    for queued := range q.tasks {
+        q.drop(queued)
+    }
+    return ctx.Err()
+}
+
+func (q *JobQueue) work() {
+    defer q.wg.Done()
+
+    for queued := range q.tasks {
+        q.mu.RLock()
+        dropping := q.dropping
+        q.mu.RUnlock()
+        if dropping {
+            q.drop(queued)
+            continue
+        }
+
+        q.update(queued.id, func(job *model.Job) {
+            now := time.Now()
+            job.State = model.JobRunning
+            job.StartedAt = &now
+        })
+
+        dicomID, err := queued.task(func(progress float64) {
+            q.update(queued.id, func(job *model.Job) {
+                job.Progress = progress
+            })
+        })
+
+        q.update(queued.id, func(job *model.Job) {
+            now := time.Now()
+            job.FinishedAt = &now
+            if err != nil {
+                job.State = model.JobFailed
+                job.Error = err.Error()
+                return
+            }
+            job.State = model.JobSucceeded
+            job.Progress = 1
+            job.DicomID = dicomID
+        })
+
+        if err != nil {
+            q.logger.Printf("Job %s failed: %v", queued.id, err)
+        }
+    }
+}
+
+// drop fails a job that has not started because the queue closed, and discards it
+func (q *JobQueue) drop(queued queuedJob) {
+    q.update(queued.id, func(job *model.Job) {
+        now := time.Now()
+        job.FinishedAt = &now
+        job.State = model.JobFailed
+        job.Error = ErrQueueClosed.Error()
+    })
+    if queued.discard != nil {
+        queued.discard()
+    }
+    q.logger.Printf("Job %s dropped, the queue closed before it started", queued.id)
+}
+
+func (q *JobQueue) update(id string, change func(job *model.Job)) {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+
+    if job, ok := q.jobs[id]; ok {
+        change(job)
+    }
+}
+
+// prune forgets jobs that finished longer than the retention period ago. Callers must hold the lock
+func (q *JobQueue) prune() {
+    for id, job := range q.jobs {
+        if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+            delete(q.jobs, id)
+        }
+    }
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+    "context"
+    "errors"
+    "log"
+    "testing"
+    "time"
+
+    "dicom/api/model"
+)
+
+func TestJobQueue_Enqueue_Success(t *testing.T) {
+    queue := NewJobQueue(2, 10, log.Default())
+
+    job, err := queue.Enqueue("test_file.dcm", func(progress func(float64)) (string, error) {
+        progress(0.5)
+        return "mock_uuid", nil
+    }, nil)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if job.State != model.JobQueued {
+        t.Errorf("Expected a queued job, got %s", job.State)
+    }
+
+    queue.Close(context.Background())
+
+    job, err = queue.GetJob(job.ID)
+    if err != nil {
+        t.Fatalf("GetJob failed: %v", err)
+    }
+    if job.State != model.JobSucceeded || job.DicomID != "mock_uuid" || job.Progress != 1 {
+        t.Errorf("Expected a succeeded job, got %+v", job)
+    }
+    if job.StartedAt == nil || job.FinishedAt == nil {
+        t.Errorf("Expected start and finish times to be set, got %+v", job)
+    }
+}
+
+func TestJobQueue_Enqueue_TaskError(t *testing.T) {
+    queue := NewJobQueue(1, 10, log.Default())
+
+    job, _ := queue.Enqueue("test_file.dcm", func(progress func(float64)) (string, error) {
+        return "", errors.New("parse error")
+    }, nil)
+
+    queue.Close(context.Background())
+
+    job, _ = queue.GetJob(job.ID)
+    if job.State != model.JobFailed || job.Error != "parse error" {
+        t.Errorf("Expected a failed job, got %+v", job)
+    }
+}
+
+func TestJobQueue_Enqueue_QueueFull(t *testing.T) {
+    // Without workers nothing leaves the queue
+    queue := NewJobQueue(0, 1, log.Default())
+    task := func(progress func(float64)) (string, error) { return "", nil }
+
+    if _, err := queue.Enqueue("first", task, nil); err != nil {
+        t.Errorf("Unexpected error: %v", err)
+    }
+    if _, err := queue.Enqueue("second", task, nil); !errors.Is(err, ErrQueueFull) {
+        t.Errorf("Expected ErrQueueFull, got %v", err)
+    }
+}
+
+func TestJobQueue_GetJob_Error(t *testing.T) {
+    queue := NewJobQueue(1, 1, log.Default())
+    defer queue.Close(context.Background())
+
+    if _, err := queue.GetJob("non_existing_job"); !errors.Is(err, ErrJobNotFound) {
+        t.Errorf("Expected ErrJobNotFound, got %v", err)
+    }
+}
+
+func TestJobQueue_Close_Timeout(t *testing.T) {
+    queue := NewJobQueue(1, 10, log.Default())
+
+    started := make(chan struct{})
+    release := make(chan struct{})
+    defer close(release)
+    running, _ := queue.Enqueue("running", func(progress func(float64)) (string, error) {
+        close(started)
+        <-release
+        return "mock_uuid", nil
+    }, nil)
+    <-started
+
+    discarded := false
+    queued, _ := queue.Enqueue("queued", func(progress func(float64)) (string, error) {
+        t.Error("Expected the queued job not to run")
+        return "", nil
+    }, func() { discarded = true })
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if err := queue.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+        t.Errorf("Expected the deadline to be exceeded, got %v", err)
+    }
+
+    job, _ := queue.GetJob(queued.ID)
+    if job.State != model.JobFailed || !discarded {
+        t.Errorf("Expected the queued job to be dropped and discarded, got %+v", job)
+    }
+    if job, _ := queue.GetJob(running.ID); job.State != model.JobRunning {
+        t.Errorf("Expected the running job to be left running, got %+v", job)
+    }
+}
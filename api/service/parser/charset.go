@@ -0,0 +1,224 @@
+package parser
+
+import (
+    "strings"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+    "golang.org/x/text/encoding"
+    "golang.org/x/text/encoding/charmap"
+    "golang.org/x/text/encoding/japanese"
+    "golang.org/x/text/encoding/korean"
+    "golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// textVRs are the VRs whose values are in the character set of the dataset, the others are always ASCII
+var textVRs = map[string]bool{"SH": true, "LO": true, "ST": true, "LT": true, "PN": true, "UC": true, "UT": true}
+
+// multiValuedVRs separate their values with a backslash, in the others it is text
+var multiValuedVRs = map[string]bool{"SH": true, "LO": true, "PN": true, "UC": true}
+
+// characterSets are the defined terms of SpecificCharacterSet without code extensions. A nil encoding
+// leaves the bytes as they are
+var characterSets = map[string]encoding.Encoding{
+    "":           nil,
+    "ISO_IR 6":   nil,
+    "ISO_IR 100": charmap.ISO8859_1,
+    "ISO_IR 101": charmap.ISO8859_2,
+    "ISO_IR 109": charmap.ISO8859_3,
+    "ISO_IR 110": charmap.ISO8859_4,
+    "ISO_IR 144": charmap.ISO8859_5,
+    "ISO_IR 127": charmap.ISO8859_6,
+    "ISO_IR 126": charmap.ISO8859_7,
+    "ISO_IR 138": charmap.ISO8859_8,
+    "ISO_IR 148": charmap.ISO8859_9,
+    "ISO_IR 203": charmap.ISO8859_15,
+    "ISO_IR 13":  japanese.ShiftJIS,
+    "ISO_IR 166": charmap.Windows874,
+    "ISO_IR 192": nil,
+    "GB18030":    simplifiedchinese.GB18030,
+    "GBK":        simplifiedchinese.GBK,
+}
+
+// codeElement is a character set an ISO 2022 escape sequence switches to. G1 elements decode the bytes with
+// the high bit set, G0 elements the others
+type codeElement struct {
+    g1     bool
+    decode func(data []byte) string
+}
+
+// codeElements are keyed by the escape sequence designating them
+var codeElements = map[string]codeElement{
+    "\x1b(B":  {decode: ascii},
+    "\x1b(J":  {decode: ascii},
+    "\x1b-A":  {g1: true, decode: decoder(charmap.ISO8859_1)},
+    "\x1b-B":  {g1: true, decode: decoder(charmap.ISO8859_2)},
+    "\x1b-C":  {g1: true, decode: decoder(charmap.ISO8859_3)},
+    "\x1b-D":  {g1: true, decode: decoder(charmap.ISO8859_4)},
+    "\x1b-L":  {g1: true, decode: decoder(charmap.ISO8859_5)},
+    "\x1b-G":  {g1: true, decode: decoder(charmap.ISO8859_6)},
+    "\x1b-F":  {g1: true, decode: decoder(charmap.ISO8859_7)},
+    "\x1b-H":  {g1: true, decode: decoder(charmap.ISO8859_8)},
+    "\x1b-M":  {g1: true, decode: decoder(charmap.ISO8859_9)},
+    "\x1b-b":  {g1: true, decode: decoder(charmap.ISO8859_15)},
+    "\x1b-T":  {g1: true, decode: decoder(charmap.Windows874)},
+    "\x1b)I":  {g1: true, decode: decoder(japanese.ShiftJIS)},
+    "\x1b$B":  {decode: jisX0208},
+    "\x1b$(D": {decode: jisX0212},
+    "\x1b$)C": {g1: true, decode: decoder(korean.EUCKR)},
+    "\x1b$)A": {g1: true, decode: decoder(simplifiedchinese.GBK)},
+}
+
+// codeExtensions are the escape sequences designating the defined terms with code extensions, which is
+// also what the first of them starts with
+var codeExtensions = map[string][]string{
+    "ISO 2022 IR 6":   {"\x1b(B"},
+    "ISO 2022 IR 100": {"\x1b-A"},
+    "ISO 2022 IR 101": {"\x1b-B"},
+    "ISO 2022 IR 109": {"\x1b-C"},
+    "ISO 2022 IR 110": {"\x1b-D"},
+    "ISO 2022 IR 144": {"\x1b-L"},
+    "ISO 2022 IR 127": {"\x1b-G"},
+    "ISO 2022 IR 126": {"\x1b-F"},
+    "ISO 2022 IR 138": {"\x1b-H"},
+    "ISO 2022 IR 148": {"\x1b-M"},
+    "ISO 2022 IR 203": {"\x1b-b"},
+    "ISO 2022 IR 13":  {"\x1b(J", "\x1b)I"},
+    "ISO 2022 IR 166": {"\x1b-T"},
+    "ISO 2022 IR 87":  {"\x1b$B"},
+    "ISO 2022 IR 159": {"\x1b$(D"},
+    "ISO 2022 IR 149": {"\x1b$)C"},
+    "ISO 2022 IR 58":  {"\x1b$)A"},
+}
+
+// decodeElements decodes the text values of the elements to UTF-8 from the character set of their dataset,
+// or of the sequence item they are in when it has its own. terms is the character set they inherit
+func decodeElements(elements []*dicom.Element, terms []string) {
+    for _, e := range elements {
+        if e.Tag == tag.SpecificCharacterSet {
+            terms, _ = e.Value.GetValue().([]string)
+        }
+    }
+
+    for _, e := range elements {
+        if items, ok := e.Value.GetValue().([]*dicom.SequenceItemValue); ok {
+            for _, item := range items {
+                itemElements, _ := item.GetValue().([]*dicom.Element)
+                decodeElements(itemElements, terms)
+            }
+            continue
+        }
+        if len(terms) == 0 || !textVRs[e.RawValueRepresentation] {
+            continue
+        }
+        values, ok := e.Value.GetValue().([]string)
+        if !ok {
+            continue
+        }
+
+        // The parser splits values on every backslash, which multi-byte characters may contain
+        text := decodeText(strings.Join(values, `\`), terms)
+        decoded := []string{text}
+        if multiValuedVRs[e.RawValueRepresentation] {
+            decoded = strings.Split(text, `\`)
+        }
+        if value, err := dicom.NewValue(decoded); err == nil {
+            e.Value = value
+        }
+    }
+}
+
+// decodeText decodes a value from the character set of SpecificCharacterSet. Values in character sets
+// that are not supported are left as they are
+func decodeText(value string, terms []string) string {
+    if len(terms) == 1 && !strings.HasPrefix(terms[0], "ISO 2022") {
+        enc, ok := characterSets[terms[0]]
+        if !ok || enc == nil {
+            return value
+        }
+        return decoder(enc)([]byte(value))
+    }
+
+    // With code extensions the first character set is in use until an escape sequence switches to another
+    var g0, g1 codeElement
+    g0 = codeElements["\x1b(B"]
+    g1 = codeElement{g1: true, decode: decoder(charmap.ISO8859_1)}
+    for _, escape := range codeExtensions[strings.Replace(terms[0], "ISO_IR ", "ISO 2022 IR ", 1)] {
+        if element := codeElements[escape]; element.g1 {
+            g1 = element
+        } else {
+            g0 = element
+        }
+    }
+
+    var decoded strings.Builder
+    data := []byte(value)
+    for pos := 0; pos < len(data); {
+        if data[pos] == 0x1b {
+            if escape, element, ok := codeElementAt(data[pos:]); ok {
+                if element.g1 {
+                    g1 = element
+                } else {
+                    g0 = element
+                }
+                pos += len(escape)
+                continue
+            }
+        }
+
+        // A run of bytes in the same half of the code table, up to the next escape sequence
+        high := data[pos] >= 0x80
+        end := pos + 1
+        for end < len(data) && data[end] != 0x1b && (data[end] >= 0x80) == high {
+            end++
+        }
+        if high {
+            decoded.WriteString(g1.decode(data[pos:end]))
+        } else {
+            decoded.WriteString(g0.decode(data[pos:end]))
+        }
+        pos = end
+    }
+    return decoded.String()
+}
+
+func codeElementAt(data []byte) (string, codeElement, bool) {
+    for escape, element := range codeElements {
+        if strings.HasPrefix(string(data), escape) {
+            return escape, element, true
+        }
+    }
+    return "", codeElement{}, false
+}
+
+func ascii(data []byte) string {
+    return string(data)
+}
+
+func decoder(enc encoding.Encoding) func(data []byte) string {
+    return func(data []byte) string {
+        decoded, err := enc.NewDecoder().Bytes(data)
+        if err != nil {
+            return string(data)
+        }
+        return string(decoded)
+    }
+}
+
+// jisX0208 decodes the 7 bit bytes of JIS X 0208 as EUC-JP, which has their high bit set
+func jisX0208(data []byte) string {
+    euc := make([]byte, len(data))
+    for i, b := range data {
+        euc[i] = b | 0x80
+    }
+    return decoder(japanese.EUCJP)(euc)
+}
+
+// jisX0212 decodes the 7 bit byte pairs of JIS X 0212 as EUC-JP, which prefixes each with 8FH
+func jisX0212(data []byte) string {
+    euc := make([]byte, 0, len(data)/2*3)
+    for i := 0; i+1 < len(data); i += 2 {
+        euc = append(euc, 0x8f, data[i]|0x80, data[i+1]|0x80)
+    }
+    return decoder(japanese.EUCJP)(euc)
+}
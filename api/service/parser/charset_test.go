@@ -0,0 +1,163 @@
+package parser
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "reflect"
+    "strings"
+    "testing"
+    "testing/iotest"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+    "github.com/suyashkumar/dicom/pkg/uid"
+)
+
+// writeDataset encodes a DICOM file whose text values are the given bytes, as written in another character set
+func writeDataset(t *testing.T, transferSyntax string, elements ...*dicom.Element) []byte {
+    meta := []*dicom.Element{
+        mustElement(t, tag.MediaStorageSOPClassUID, []string{"1.2.840.10008.5.1.4.1.1.7"}),
+        mustElement(t, tag.MediaStorageSOPInstanceUID, []string{"1.2.3"}),
+        mustElement(t, tag.TransferSyntaxUID, []string{transferSyntax}),
+    }
+    var buf bytes.Buffer
+    if err := dicom.Write(&buf, dicom.Dataset{Elements: append(meta, elements...)}, dicom.SkipVRVerification()); err != nil {
+        t.Fatalf("Writing DICOM file failed: %v", err)
+    }
+    return buf.Bytes()
+}
+
+func mustElement(t *testing.T, tg tag.Tag, value interface{}) *dicom.Element {
+    element, err := dicom.NewElement(tg, value)
+    if err != nil {
+        t.Fatalf("Creating element %v failed: %v", tg, err)
+    }
+    return element
+}
+
+func TestParseDataset_CharacterSets(t *testing.T) {
+    // Patient names of PS3.5 annex H, I and J and of the character sets without code extensions
+    tests := []struct {
+        characterSet string
+        raw          string
+        expected     string
+    }{
+        {`ISO_IR 100`, "M\xfcller^Ren\xe9", "Müller^René"},
+        {`ISO_IR 126`, "\xc4\xe9\xef\xed\xf5\xf3\xe9\xef\xf2", "Διονυσιος"},
+        {`ISO_IR 144`, "\xbb\xee\xdc\xd8\xdd^\xb2\xdb\xd0\xd4\xd8\xdc\xd8\xe0", "Люмин^Владимир"},
+        {`ISO_IR 166`, "\xca\xc7\xd1\xca\xb4\xd5", "สวัสดี"},
+        {`ISO_IR 13`, "\xd4\xcf\xc0\xde^\xc0\xdb\xb3", "ﾔﾏﾀﾞ^ﾀﾛｳ"},
+        {`ISO_IR 192`, "Wang^XiaoDong=\xe7\x8e\x8b^\xe5\xb0\x8f\xe6\x9d\xb1=", "Wang^XiaoDong=王^小東="},
+        {`GB18030`, "Wang^XiaoDong=\xcd\xf5^\xd0\xa1\xb6\xab=", "Wang^XiaoDong=王^小东="},
+        {`\ISO 2022 IR 87`, "Yamada^Tarou=\x1b$B;3ED\x1b(B^\x1b$BB@O:\x1b(B=\x1b$B$d$^$@\x1b(B^\x1b$B$?$m$&\x1b(B", "Yamada^Tarou=山田^太郎=やまだ^たろう"},
+        {`ISO 2022 IR 13\ISO 2022 IR 87`, "\xd4\xcf\xc0\xde^\xc0\xdb\xb3=\x1b$B;3ED\x1b(J^\x1b$BB@O:\x1b(J=\x1b$B$d$^$@\x1b(J^\x1b$B$?$m$&\x1b(J", "ﾔﾏﾀﾞ^ﾀﾛｳ=山田^太郎=やまだ^たろう"},
+        {`\ISO 2022 IR 149`, "Hong^Gildong=\x1b$)C\xfb\xf3^\x1b$)C\xd1\xce\xd4\xd7=\x1b$)C\xc8\xab^\x1b$)C\xb1\xe6\xb5\xbf", "Hong^Gildong=洪^吉洞=홍^길동"},
+        {`\ISO 2022 IR 58`, "Zhang^XiaoDong=\x1b$)A\xd5\xc5^\x1b$)A\xd0\xa1\xb6\xab=", "Zhang^XiaoDong=张^小东="},
+        {`ISO 2022 IR 6\ISO 2022 IR 126`, "Dionysios=\x1b-F\xc4\xe9\xef\xed\xf5\xf3\xe9\xef\xf2", "Dionysios=Διονυσιος"},
+        // Character sets that are not supported keep their bytes instead of failing the file
+        {`ISO_IR 999`, "Doe^John", "Doe^John"},
+    }
+
+    for _, transferSyntax := range []string{uid.ExplicitVRLittleEndian, uid.ImplicitVRLittleEndian} {
+        for _, test := range tests {
+            data := writeDataset(t, transferSyntax,
+                mustElement(t, tag.SpecificCharacterSet, strings.Split(test.characterSet, `\`)),
+                mustElement(t, tag.PatientName, []string{test.raw}),
+            )
+
+            dataset, err := ParseDataset(data)
+            if err != nil {
+                t.Errorf("Parsing %s failed: %v", test.characterSet, err)
+                continue
+            }
+            if name := FirstString(&dataset, tag.PatientName); name != test.expected {
+                t.Errorf("Expected %s name %q, got %q", test.characterSet, test.expected, name)
+            }
+
+            characterSet, err := dataset.FindElementByTag(tag.SpecificCharacterSet)
+            if err != nil {
+                t.Errorf("Expected the %s character set to be kept: %v", test.characterSet, err)
+                continue
+            }
+            if values := characterSet.Value.GetValue(); !reflect.DeepEqual(values, strings.Split(test.characterSet, `\`)) {
+                t.Errorf("Expected character set %q, got %q", test.characterSet, values)
+            }
+        }
+    }
+}
+
+func TestParseDataset_MultipleValuesAndSequences(t *testing.T) {
+    data := writeDataset(t, uid.ExplicitVRLittleEndian,
+        mustElement(t, tag.SpecificCharacterSet, []string{"ISO_IR 100"}),
+        mustElement(t, tag.InstitutionName, []string{"Caf\xe9\\Cr\xe8me"}),
+        mustElement(t, tag.ReferencedPatientSequence, [][]*dicom.Element{
+            {mustElement(t, tag.PatientName, []string{"Gr\xfcn^J\xfcrgen"})},
+            {
+                // An item may have its own character set
+                mustElement(t, tag.SpecificCharacterSet, []string{"ISO_IR 126"}),
+                mustElement(t, tag.PatientName, []string{"\xc4\xe9\xef\xed\xf5\xf3\xe9\xef\xf2"}),
+            },
+        }),
+        mustElement(t, tag.StudyInstanceUID, []string{"1.2.3.4"}),
+    )
+
+    dataset, err := ParseDataset(data)
+    if err != nil {
+        t.Fatalf("ParseDataset failed: %v", err)
+    }
+
+    institution, _ := dataset.FindElementByTag(tag.InstitutionName)
+    if values := institution.Value.GetValue(); !reflect.DeepEqual(values, []string{"Café", "Crème"}) {
+        t.Errorf("Unexpected institution names %q", values)
+    }
+    if uid := FirstString(&dataset, tag.StudyInstanceUID); uid != "1.2.3.4" {
+        t.Errorf("Unexpected study instance UID %q", uid)
+    }
+
+    sequence, _ := dataset.FindElementByTag(tag.ReferencedPatientSequence)
+    items := sequence.Value.GetValue().([]*dicom.SequenceItemValue)
+    var names []string
+    for _, item := range items {
+        names = append(names, FirstString(&dicom.Dataset{Elements: item.GetValue().([]*dicom.Element)}, tag.PatientName))
+    }
+    if !reflect.DeepEqual(names, []string{"Grün^Jürgen", "Διονυσιος"}) {
+        t.Errorf("Unexpected names in sequence items %q", names)
+    }
+}
+
+func TestParseDatasetFrom_Stream(t *testing.T) {
+    data := writeDataset(t, uid.ExplicitVRLittleEndian,
+        mustElement(t, tag.SpecificCharacterSet, []string{"ISO_IR 100"}),
+        mustElement(t, tag.PatientName, []string{"M\xfcller^Ren\xe9"}),
+    )
+
+    // The file is read a byte at a time without knowing its size
+    dataset, err := ParseDatasetFrom(iotest.OneByteReader(bytes.NewReader(data)), -1)
+    if err != nil {
+        t.Fatalf("ParseDatasetFrom failed: %v", err)
+    }
+    if name := FirstString(&dataset, tag.PatientName); name != "Müller^René" {
+        t.Errorf("Expected name %q, got %q", "Müller^René", name)
+    }
+}
+
+func TestReadTransferSyntax(t *testing.T) {
+    data := writeDataset(t, uid.ExplicitVRBigEndian, mustElement(t, tag.PatientName, []string{"Doe^John"}))
+
+    // Nothing past the file meta information is read, so a file failing after it is still fine
+    failing := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(errors.New("read past the header")))
+    transferSyntax, err := ReadTransferSyntax(failing)
+    if err != nil {
+        t.Fatalf("ReadTransferSyntax failed: %v", err)
+    }
+    if transferSyntax != uid.ExplicitVRBigEndian {
+        t.Errorf("Expected transfer syntax %s, got %s", uid.ExplicitVRBigEndian, transferSyntax)
+    }
+
+    // Without its preamble the file is read from the start as implicit VR little endian
+    transferSyntax, err = ReadTransferSyntax(bytes.NewReader(append(data[128+4:], make([]byte, 128+4)...)))
+    if err != nil || transferSyntax != "" {
+        t.Errorf("Expected no transfer syntax without file meta information, got %q, %v", transferSyntax, err)
+    }
+}
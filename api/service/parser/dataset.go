@@ -0,0 +1,183 @@
+package parser
+
+import (
+    "bufio"
+    "bytes"
+    "io"
+    "math"
+    "strings"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+    "github.com/suyashkumar/dicom/pkg/uid"
+)
+
+// maskedCharacterSet stands in for SpecificCharacterSet while a file is parsed. It is not in the dictionary,
+// so the parser reads text as raw bytes instead of decoding it itself
+var maskedCharacterSet = tag.Tag{Group: 0x0008, Element: 0x0004}
+
+// headerSize is how much of a file is looked at to find its SpecificCharacterSet, which follows the file
+// meta information
+const headerSize = 64 << 10
+
+// ParseDataset parses a DICOM file read in full, with its text values decoded to UTF-8 according to
+// SpecificCharacterSet
+func ParseDataset(data []byte, opts ...dicom.ParseOption) (dicom.Dataset, error) {
+    return ParseDatasetFrom(bytes.NewReader(data), int64(len(data)), opts...)
+}
+
+// ParseDatasetFrom parses the size bytes of a DICOM file streamed from the reader like ParseDataset, only
+// its header is held in memory on top of the dataset. A size of -1 reads the file to its end. Every DICOM
+// file the service reads goes through it
+func ParseDatasetFrom(r io.Reader, size int64, opts ...dicom.ParseOption) (dicom.Dataset, error) {
+    if size < 0 {
+        size = math.MaxInt64
+    }
+
+    // The parser only knows some character sets, fails on the others and cannot decode ISO 2022 code
+    // extensions, so it is kept from seeing the character set of the file
+    buffered := bufio.NewReaderSize(r, headerSize)
+    header, err := buffered.Peek(headerSize)
+    if err != nil && err != io.EOF {
+        return dicom.Dataset{}, err
+    }
+    var in io.Reader = buffered
+    pos, raw := characterSetPosition(header)
+    if pos >= 0 {
+        head := append([]byte(nil), header[:pos+4]...)
+        raw.byteOrder().PutUint16(head[pos+2:], maskedCharacterSet.Element)
+        buffered.Discard(len(head))
+        in = io.MultiReader(bytes.NewReader(head), buffered)
+    }
+
+    dataset, err := dicom.Parse(in, size, nil, opts...)
+    if err != nil {
+        return dataset, err
+    }
+
+    if pos >= 0 {
+        restoreCharacterSet(&dataset)
+    } else if _, err := dataset.FindElementByTag(tag.SpecificCharacterSet); err == nil {
+        // A character set that could not be masked was decoded by the parser already
+        return dataset, nil
+    }
+    decodeElements(dataset.Elements, nil)
+    return dataset, nil
+}
+
+// ReadTransferSyntax returns the transfer syntax of a DICOM file, reading no further than its file meta
+// information. A file without one is implicit VR little endian and has an empty transfer syntax
+func ReadTransferSyntax(r io.Reader) (string, error) {
+    p, err := dicom.NewParser(r, math.MaxInt64, nil)
+    if err != nil {
+        return "", err
+    }
+    metadata := p.GetMetadata()
+    return FirstString(&metadata, tag.TransferSyntaxUID), nil
+}
+
+// characterSetPosition returns where the SpecificCharacterSet element of the dataset starts, and how the
+// dataset is encoded, or -1 when the file has none or cannot be walked
+func characterSetPosition(data []byte) (int, *rawDataset) {
+    r := &rawDataset{data: data, implicit: true}
+
+    // Files without the preamble and magic word are read as implicit VR little endian from the start,
+    // as the parser does
+    pos := 0
+    if len(data) >= 128+4 && string(data[128:128+4]) == "DICM" {
+        meta := &rawDataset{data: data}
+        transferSyntax := ""
+        for pos = 128 + 4; pos+4 <= len(data) && meta.tag(pos).Group == 0x0002; {
+            length, start, err := meta.header(pos)
+            if err != nil || length == undefinedLength || start+int(length) > len(data) {
+                return -1, nil
+            }
+            if meta.tag(pos) == tag.TransferSyntaxUID {
+                transferSyntax = strings.TrimRight(string(data[start:start+int(length)]), " \x00")
+            }
+            pos = start + int(length)
+        }
+
+        if transferSyntax != "" {
+            canonical, err := uid.CanonicalTransferSyntaxUID(transferSyntax)
+            if err != nil || canonical == uid.DeflatedExplicitVRLittleEndian {
+                return -1, nil
+            }
+            if r.order, r.implicit, err = uid.ParseTransferSyntaxUID(canonical); err != nil {
+                return -1, nil
+            }
+        }
+    }
+
+    // Elements are sorted by tag, so the character set comes first or not at all
+    for pos+4 <= len(data) {
+        t := r.tag(pos)
+        if t == tag.SpecificCharacterSet {
+            return pos, r
+        }
+        if t.Group > tag.SpecificCharacterSet.Group || (t.Group == tag.SpecificCharacterSet.Group && t.Element > tag.SpecificCharacterSet.Element) {
+            return -1, nil
+        }
+
+        next, err := r.skipElement(pos)
+        if err != nil {
+            return -1, nil
+        }
+        pos = next
+    }
+    return -1, nil
+}
+
+// restoreCharacterSet puts SpecificCharacterSet back in place of the element it was parsed as
+func restoreCharacterSet(dataset *dicom.Dataset) {
+    for i, e := range dataset.Elements {
+        if e.Tag != maskedCharacterSet {
+            continue
+        }
+
+        // The masked element is unknown to the dictionary, implicit VR files have its value as bytes
+        var value string
+        switch v := e.Value.GetValue().(type) {
+        case []string:
+            value = strings.Join(v, `\`)
+        case []byte:
+            value = string(v)
+        }
+        terms := strings.Split(strings.TrimRight(value, " \x00"), `\`)
+        for j, term := range terms {
+            terms[j] = strings.TrimSpace(term)
+        }
+
+        if element, err := dicom.NewElement(tag.SpecificCharacterSet, terms); err == nil {
+            dataset.Elements[i] = element
+        }
+        return
+    }
+}
+
+// FirstString returns the first value of a text element of the dataset without its padding, or an empty
+// string when the element is missing
+func FirstString(dataset *dicom.Dataset, t tag.Tag) string {
+    element, err := dataset.FindElementByTag(t)
+    if err != nil {
+        return ""
+    }
+    values, ok := element.Value.GetValue().([]string)
+    if !ok || len(values) == 0 {
+        return ""
+    }
+    return strings.TrimSpace(values[0])
+}
+
+// FirstInt returns the first value of a numeric element of the dataset, or 0 when the element is missing
+func FirstInt(dataset *dicom.Dataset, t tag.Tag) int {
+    element, err := dataset.FindElementByTag(t)
+    if err != nil {
+        return 0
+    }
+    values, ok := element.Value.GetValue().([]int)
+    if !ok || len(values) == 0 {
+        return 0
+    }
+    return values[0]
+}
@@ -0,0 +1,293 @@
+package parser
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "dicom/api/model"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+const undefinedLength = 0xFFFFFFFF
+
+var (
+    itemTag                 = tag.Tag{Group: 0xFFFE, Element: 0xE000}
+    itemDelimitationTag     = tag.Tag{Group: 0xFFFE, Element: 0xE00D}
+    sequenceDelimitationTag = tag.Tag{Group: 0xFFFE, Element: 0xE0DD}
+)
+
+// GetDicomDirRecords reads a DICOMDIR and walks its Patient/Study/Series/Image directory records by
+// following their offsets, returning every file referenced by a record that is in use
+func (p *DicomParser) GetDicomDirRecords(dicomDirPath string) ([]model.DicomDirRecord, error) {
+    data, err := os.ReadFile(dicomDirPath)
+    if err != nil {
+        p.logger.Printf("Error reading DICOMDIR: %v", err)
+        return nil, err
+    }
+
+    dataset, err := ParseDataset(data, dicom.SkipPixelData())
+    if err != nil {
+        p.logger.Printf("Error parsing DICOMDIR: %v", err)
+        return nil, err
+    }
+
+    sequence, err := dataset.FindElementByTag(tag.DirectoryRecordSequence)
+    if err != nil {
+        p.logger.Printf("Error finding directory records: %v", err)
+        return nil, err
+    }
+    items, ok := sequence.Value.GetValue().([]*dicom.SequenceItemValue)
+    if !ok {
+        return nil, errors.New("directory record sequence has no items")
+    }
+
+    // Records reference each other by the byte offset of their item in the file, so the offsets of the
+    // parsed items are recovered from the raw bytes
+    offsets, err := directoryRecordOffsets(data)
+    if err != nil {
+        p.logger.Printf("Error locating directory records: %v", err)
+        return nil, err
+    }
+    if len(offsets) != len(items) {
+        return nil, fmt.Errorf("found %d directory record offsets for %d records", len(offsets), len(items))
+    }
+
+    records := make(map[int][]*dicom.Element, len(items))
+    for i, item := range items {
+        records[offsets[i]] = item.GetValue().([]*dicom.Element)
+    }
+
+    walker := &dicomDirWalker{
+        dir:     filepath.Dir(dicomDirPath),
+        records: records,
+        visited: map[int]bool{},
+    }
+    if err := walker.walk(FirstInt(&dataset, tag.OffsetOfTheFirstDirectoryRecordOfTheRootDirectoryEntity), model.DicomDirRecord{}); err != nil {
+        p.logger.Printf("Error walking directory records: %v", err)
+        return nil, err
+    }
+
+    return walker.files, nil
+}
+
+type dicomDirWalker struct {
+    dir     string
+    records map[int][]*dicom.Element
+    visited map[int]bool
+    files   []model.DicomDirRecord
+}
+
+// walk visits the record at the offset and every record after it on the same level, descending
+// into lower level records along the way. parent carries the identifiers of the enclosing records
+func (w *dicomDirWalker) walk(offset int, parent model.DicomDirRecord) error {
+    for offset != 0 {
+        if w.visited[offset] {
+            return fmt.Errorf("directory record at offset %d is referenced twice", offset)
+        }
+        w.visited[offset] = true
+
+        elements, ok := w.records[offset]
+        if !ok {
+            return fmt.Errorf("no directory record at offset %d", offset)
+        }
+        record := &dicom.Dataset{Elements: elements}
+
+        // Records that are no longer in use have their flag set to 0000H
+        inactive := false
+        if flag, err := record.FindElementByTag(tag.RecordInUseFlag); err == nil {
+            if values, ok := flag.Value.GetValue().([]int); ok && len(values) > 0 && values[0] == 0 {
+                inactive = true
+            }
+        }
+
+        if !inactive {
+            current := parent
+            current.RecordType = FirstString(record, tag.DirectoryRecordType)
+            switch current.RecordType {
+            case "PATIENT":
+                current.PatientID = FirstString(record, tag.PatientID)
+            case "STUDY":
+                current.StudyInstanceUID = FirstString(record, tag.StudyInstanceUID)
+            case "SERIES":
+                current.SeriesInstanceUID = FirstString(record, tag.SeriesInstanceUID)
+            }
+
+            if fileID, err := record.FindElementByTag(tag.ReferencedFileID); err == nil {
+                components, _ := fileID.Value.GetValue().([]string)
+                path, err := fileIDPath(w.dir, components)
+                if err != nil {
+                    return fmt.Errorf("directory record at offset %d: %w", offset, err)
+                }
+                file := current
+                file.Path = path
+                file.SOPInstanceUID = FirstString(record, tag.ReferencedSOPInstanceUIDInFile)
+                w.files = append(w.files, file)
+            }
+
+            if err := w.walk(FirstInt(record, tag.OffsetOfReferencedLowerLevelDirectoryEntity), current); err != nil {
+                return err
+            }
+        }
+
+        offset = FirstInt(record, tag.OffsetOfTheNextDirectoryRecord)
+    }
+
+    return nil
+}
+
+// fileIDPath resolves the components of a Referenced File ID below the directory of the DICOMDIR.
+// Components are plain names, so a file ID cannot lead out of the directory, a volume name being
+// rejected on any system
+func fileIDPath(dir string, components []string) (string, error) {
+    if len(components) == 0 {
+        return "", errors.New("referenced file ID is empty")
+    }
+
+    elements := []string{dir}
+    for _, component := range components {
+        component = strings.TrimSpace(component)
+        if component == "" || component == "." || component == ".." ||
+            strings.ContainsAny(component, `/\:`) || filepath.VolumeName(component) != "" {
+            return "", fmt.Errorf("invalid referenced file ID component %q", component)
+        }
+        elements = append(elements, component)
+    }
+
+    path := filepath.Join(elements...)
+    if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+        return "", fmt.Errorf("referenced file %s is outside of %s", path, dir)
+    }
+    return path, nil
+}
+
+// directoryRecordOffsets returns the file offset of every item of the directory record sequence.
+// A DICOMDIR is always encoded in explicit VR little endian
+func directoryRecordOffsets(data []byte) ([]int, error) {
+    r := &rawDataset{data: data}
+
+    // Skip the preamble, the magic word and the file meta information group
+    pos := 128 + 4
+    for pos+4 <= len(data) && r.tag(pos).Group == 0x0002 {
+        next, err := r.skipElement(pos)
+        if err != nil {
+            return nil, err
+        }
+        pos = next
+    }
+
+    for pos < len(data) {
+        if r.tag(pos) != tag.DirectoryRecordSequence {
+            next, err := r.skipElement(pos)
+            if err != nil {
+                return nil, err
+            }
+            pos = next
+            continue
+        }
+
+        length, start, err := r.header(pos)
+        if err != nil {
+            return nil, err
+        }
+        end := len(data)
+        if length != undefinedLength {
+            end = start + int(length)
+        }
+
+        var offsets []int
+        for pos = start; pos < end && r.tag(pos) == itemTag; {
+            offsets = append(offsets, pos)
+            if pos, err = r.skipElement(pos); err != nil {
+                return nil, err
+            }
+        }
+        return offsets, nil
+    }
+
+    return nil, errors.New("directory record sequence not found")
+}
+
+// rawDataset walks the raw bytes of a dataset, explicit VR little endian unless told otherwise
+type rawDataset struct {
+    data     []byte
+    order    binary.ByteOrder
+    implicit bool
+}
+
+func (r *rawDataset) byteOrder() binary.ByteOrder {
+    if r.order == nil {
+        return binary.LittleEndian
+    }
+    return r.order
+}
+
+func (r *rawDataset) tag(pos int) tag.Tag {
+    if pos+4 > len(r.data) {
+        return tag.Tag{}
+    }
+    return tag.Tag{
+        Group:   r.byteOrder().Uint16(r.data[pos:]),
+        Element: r.byteOrder().Uint16(r.data[pos+2:]),
+    }
+}
+
+// header returns the value length of the element at pos and where its value starts
+func (r *rawDataset) header(pos int) (uint32, int, error) {
+    if pos+8 > len(r.data) {
+        return 0, 0, errors.New("unexpected end of data")
+    }
+
+    if r.implicit || r.tag(pos).Group == 0xFFFE {
+        return r.byteOrder().Uint32(r.data[pos+4:]), pos + 8, nil
+    }
+
+    switch string(r.data[pos+4 : pos+6]) {
+    case "OB", "OD", "OF", "OL", "OV", "OW", "SQ", "SV", "UC", "UN", "UR", "UT", "UV":
+        if pos+12 > len(r.data) {
+            return 0, 0, errors.New("unexpected end of data")
+        }
+        return r.byteOrder().Uint32(r.data[pos+8:]), pos + 12, nil
+    default:
+        return uint32(r.byteOrder().Uint16(r.data[pos+6:])), pos + 8, nil
+    }
+}
+
+// skipElement returns the position right after the element, item or delimiter at pos
+func (r *rawDataset) skipElement(pos int) (int, error) {
+    length, start, err := r.header(pos)
+    if err != nil {
+        return 0, err
+    }
+
+    if length != undefinedLength {
+        end := start + int(length)
+        if end > len(r.data) {
+            return 0, errors.New("element length exceeds data")
+        }
+        return end, nil
+    }
+
+    // An undefined length item ends with an item delimiter, an undefined length sequence with a
+    // sequence delimiter
+    delimiter := sequenceDelimitationTag
+    if r.tag(pos) == itemTag {
+        delimiter = itemDelimitationTag
+    }
+
+    for pos = start; pos < len(r.data); {
+        if r.tag(pos) == delimiter {
+            return pos + 8, nil
+        }
+        if pos, err = r.skipElement(pos); err != nil {
+            return 0, err
+        }
+    }
+
+    return 0, errors.New("missing delimitation item")
+}
@@ -0,0 +1,68 @@
+package parser
+
+import (
+    "log"
+    "path/filepath"
+    "testing"
+
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+)
+
+func TestDicomParser_GetDicomDirRecords_Success(t *testing.T) {
+    parser := NewDicomParser(&sql.MockRepository{}, &blob.MockRepository{}, log.Default())
+
+    records, err := parser.GetDicomDirRecords("../../../test-xray/DICOMDIR")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(records) == 0 {
+        t.Fatal("Expected referenced files, got none")
+    }
+
+    for _, record := range records {
+        if filepath.Dir(filepath.Dir(filepath.Dir(filepath.Dir(record.Path)))) != "../../../test-xray/DICOM" {
+            t.Errorf("Unexpected referenced file path: %s", record.Path)
+        }
+        if record.PatientID == "" || record.StudyInstanceUID == "" || record.SeriesInstanceUID == "" || record.SOPInstanceUID == "" {
+            t.Errorf("Expected the record to carry its patient, study and series, got %+v", record)
+        }
+    }
+}
+
+func TestDicomParser_GetDicomDirRecords_Error(t *testing.T) {
+    parser := NewDicomParser(&sql.MockRepository{}, &blob.MockRepository{}, log.Default())
+
+    if _, err := parser.GetDicomDirRecords("test_file.dcm"); err == nil {
+        t.Error("Expected error for a file without directory records, got nil")
+    }
+    if _, err := parser.GetDicomDirRecords("non_existent_DICOMDIR"); err == nil {
+        t.Error("Expected error for a missing file, got nil")
+    }
+}
+
+func TestFileIDPath(t *testing.T) {
+    path, err := fileIDPath("archive", []string{"DICOM", "ST000001", "IM000001 "})
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if expected := filepath.Join("archive", "DICOM", "ST000001", "IM000001"); path != expected {
+        t.Errorf("Expected %s, got %s", expected, path)
+    }
+
+    invalid := [][]string{
+        nil,
+        {"DICOM", ""},
+        {"..", "etc", "passwd"},
+        {"DICOM", "..", "..", "secret"},
+        {"DICOM/../../secret"},
+        {`DICOM\..\secret`},
+        {"/etc/passwd"},
+        {"C:", "secret"},
+    }
+    for _, components := range invalid {
+        if path, err := fileIDPath("archive", components); err == nil {
+            t.Errorf("Expected error for %q, got %s", components, path)
+        }
+    }
+}
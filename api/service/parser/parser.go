@@ -1,54 +1,314 @@
 package parser
 
 import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
     "fmt"
+    "io"
     "log"
+    "os"
 
     "dicom/api/common"
+    "dicom/api/model"
+    "dicom/api/repository/blob"
     "dicom/api/repository/sql"
 
     "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
 )
 
+// DuplicatePolicy decides what happens when a DICOM file is received again
+type DuplicatePolicy string
+
+const (
+    // DuplicateReject fails the ingest of a duplicate with a DuplicateError
+    DuplicateReject DuplicatePolicy = "reject"
+    // DuplicateExisting keeps the stored instance and reports its id for the duplicate
+    DuplicateExisting DuplicatePolicy = "existing"
+    // DuplicateReplace reprocesses the duplicate under the id of the stored instance
+    DuplicateReplace DuplicatePolicy = "replace"
+)
+
+// ErrDuplicate is matched by every DuplicateError
+var ErrDuplicate = errors.New("DICOM instance already exists")
+
+// DuplicateError is returned instead of a dataset when a file has already been ingested, either with the
+// same SOP instance UID or with identical content, and the policy does not reprocess it
+type DuplicateError struct {
+    UUID   string
+    Policy DuplicatePolicy
+}
+
+func (e *DuplicateError) Error() string {
+    return fmt.Sprintf("%v as %s", ErrDuplicate, e.UUID)
+}
+
+func (e *DuplicateError) Is(target error) bool {
+    return target == ErrDuplicate
+}
+
+// ParseDuplicatePolicy validates the name of a duplicate policy
+func ParseDuplicatePolicy(name string) (DuplicatePolicy, error) {
+    switch policy := DuplicatePolicy(name); policy {
+    case DuplicateReject, DuplicateExisting, DuplicateReplace:
+        return policy, nil
+    default:
+        return "", fmt.Errorf("unknown duplicate policy %q", name)
+    }
+}
+
 type Parser interface {
-    GetDicomDatasetByPath(dicomFilePath string) (*dicom.Dataset, string, error)
-    GetDicomDatasetByFile(file string) (error)
+    GetDicomDatasetByPath(dicomFilePath string) (*ParsedDicom, error)
+    GetDicomDatasetByFile(file io.Reader) (*ParsedDicom, error)
+}
+
+// ParsedDicom is a DICOM file that was parsed and registered under UUID, with its original kept. Its
+// fingerprint is only recorded by Commit once the whole ingest succeeded, so a file that failed half way
+// is never taken for a stored duplicate. A failed ingest is undone with Discard
+type ParsedDicom struct {
+    Dataset        *dicom.Dataset
+    UUID           string
+    SOPInstanceUID string
+    FileHash       string
+    // Replaced is set when the file reprocesses a stored instance under the DuplicateReplace policy
+    Replaced bool
+    // staging names the files written for a replaced instance, staged maps their paths to where they
+    // are written until Commit
+    staging string
+    staged  map[string]string
+}
+
+// Stage returns where a file of the instance stored at path is to be written. The files of a replaced
+// instance are staged next to the ones in use, which Commit replaces and Discard keeps
+func (d *ParsedDicom) Stage(path string) string {
+    if d.staging == "" {
+        return path
+    }
+    if d.staged == nil {
+        d.staged = map[string]string{}
+    }
+    d.staged[path] = fmt.Sprintf("%s.staged_%s", path, d.staging)
+    return d.staged[path]
 }
 
 type DicomParser struct {
-    sql    sql.Repository
-    logger *log.Logger
+    sql             sql.Repository
+    blob            blob.Repository
+    duplicatePolicy DuplicatePolicy
+    logger          *log.Logger
 }
 
-func NewDicomParser(repo sql.Repository, logger *log.Logger) *DicomParser {
+func NewDicomParser(repo sql.Repository, blobRepo blob.Repository, logger *log.Logger) *DicomParser {
     p := &DicomParser{
-        sql:    repo,
-        logger: logger,
+        sql:             repo,
+        blob:            blobRepo,
+        duplicatePolicy: DuplicateExisting,
+        logger:          logger,
     }
 
     return p
 }
 
-func (p *DicomParser) GetDicomDatasetByPath(dicomFilePath string) (*dicom.Dataset, string, error) {
-    dataset, err := dicom.ParseFile(dicomFilePath, nil)
+// SetDuplicatePolicy changes how files that have already been ingested are handled
+func (p *DicomParser) SetDuplicatePolicy(policy DuplicatePolicy) {
+    p.duplicatePolicy = policy
+}
+
+func (p *DicomParser) GetDicomDatasetByPath(dicomFilePath string) (*ParsedDicom, error) {
+    file, err := os.Open(dicomFilePath)
+    if err != nil {
+        p.logger.Printf("Error opening DICOM file: %v", err)
+        return nil, err
+    }
+    defer file.Close()
+
+    return p.parse(file)
+}
+
+// GetDicomDatasetByFile parses a DICOM file streamed from the reader, e.g. the body of an HTTP upload,
+// so the file never has to exist on the server's own filesystem
+func (p *DicomParser) GetDicomDatasetByFile(file io.Reader) (*ParsedDicom, error) {
+    return p.parse(file)
+}
+
+// parse spools the DICOM file to a temporary file while hashing its content and parses it from there,
+// then registers it and keeps the original unless it is a duplicate
+func (p *DicomParser) parse(file io.Reader) (*ParsedDicom, error) {
+    spooled, err := os.CreateTemp("", "dicom-parse-*.dcm")
+    if err != nil {
+        p.logger.Printf("Error creating temporary DICOM file: %v", err)
+        return nil, err
+    }
+    defer os.Remove(spooled.Name())
+    defer spooled.Close()
+
+    hash := sha256.New()
+    size, err := io.Copy(io.MultiWriter(hash, spooled), file)
+    if err != nil {
+        p.logger.Printf("Error reading DICOM file: %v", err)
+        return nil, err
+    }
+    if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+        p.logger.Printf("Error reading DICOM file: %v", err)
+        return nil, err
+    }
+
+    dataset, err := ParseDatasetFrom(spooled, size)
     if err != nil {
         p.logger.Printf("Error parsing DICOM file: %v", err)
-        return nil, "", err
+        return nil, err
     }
 
-    uuid := common.GenShortUUID()
-    imageURL := fmt.Sprintf("output/image_%s.png", uuid)
+    parsed := &ParsedDicom{
+        Dataset:        &dataset,
+        SOPInstanceUID: FirstString(&dataset, tag.SOPInstanceUID),
+        FileHash:       hex.EncodeToString(hash.Sum(nil)),
+    }
+    if parsed.SOPInstanceUID == "" {
+        parsed.SOPInstanceUID = FirstString(&dataset, tag.MediaStorageSOPInstanceUID)
+    }
 
-    _, err = p.sql.InsertDicom(imageURL, uuid)
+    // Files being ingested at the same time are not found yet, Commit recognizes them
+    existing, err := p.sql.GetDicomByFingerprint(parsed.SOPInstanceUID, parsed.FileHash)
     if err != nil {
-        p.logger.Printf("Error inserting DICOM into database: %v", err)
-        return nil, "", err
+        return nil, err
+    }
+    if existing != nil {
+        if err := p.handleDuplicate(existing); err != nil {
+            return nil, err
+        }
+        parsed.UUID, parsed.Replaced, parsed.staging = existing.UUID, true, common.GenShortUUID()
+    } else if parsed.UUID, err = p.insertDicom(); err != nil {
+        return nil, err
+    }
+
+    // The original is kept as received so it can be retrieved byte for byte
+    fileURL := originalURL(parsed.UUID)
+    if _, err := spooled.Seek(0, io.SeekStart); err != nil {
+        p.logger.Printf("Error reading DICOM file: %v", err)
+        p.Discard(parsed)
+        return nil, err
+    }
+    if err := p.blob.WriteFile(spooled, parsed.Stage(fileURL)); err != nil {
+        p.logger.Printf("Error storing original DICOM file: %v", err)
+        p.Discard(parsed)
+        return nil, err
+    }
+    if err := p.sql.SetDicomFileURL(parsed.UUID, fileURL); err != nil {
+        p.Discard(parsed)
+        return nil, err
+    }
+
+    return parsed, nil
+}
+
+// Commit records the fingerprint of a file once its tags are stored, after which copies of it are
+// duplicates, and puts the staged files of a replaced instance in place. When a copy ingested at the same
+// time was committed first, a DuplicateError names the copy. A file that fails to be committed is to be
+// discarded
+func (p *DicomParser) Commit(parsed *ParsedDicom) error {
+    err := p.sql.SetDicomFingerprint(parsed.UUID, parsed.SOPInstanceUID, parsed.FileHash)
+    if err == nil {
+        return p.moveStaged(parsed)
+    }
+    if !errors.Is(err, sql.ErrDuplicateFingerprint) {
+        return err
     }
 
-    return &dataset, uuid, nil
+    existing, lookupErr := p.sql.GetDicomByFingerprint(parsed.SOPInstanceUID, parsed.FileHash)
+    if lookupErr != nil || existing == nil || existing.UUID == parsed.UUID {
+        return err
+    }
+    // The copy committed first is kept, there is nothing left to replace
+    policy := p.duplicatePolicy
+    if policy == DuplicateReplace {
+        policy = DuplicateExisting
+    }
+    p.logger.Printf("DICOM file is a duplicate of %s", existing.UUID)
+    return &DuplicateError{UUID: existing.UUID, Policy: policy}
+}
+
+// moveStaged replaces the files of a replaced instance with the ones staged for it
+func (p *DicomParser) moveStaged(parsed *ParsedDicom) error {
+    for path, staged := range parsed.staged {
+        if err := p.blob.MoveFile(staged, path); err != nil {
+            p.logger.Printf("Error replacing DICOM file %s: %v", path, err)
+            return err
+        }
+        delete(parsed.staged, path)
+    }
+    return nil
+}
+
+// Discard undoes the registration of a file whose ingest failed, removing its row, tags and original.
+// Its bulk data is left to the processor that wrote it. A stored instance that was being replaced is
+// kept with the tags and files it had, only the files staged for it are removed
+func (p *DicomParser) Discard(parsed *ParsedDicom) {
+    if parsed.Replaced {
+        for _, staged := range parsed.staged {
+            if err := p.blob.DeleteFile(staged); err != nil {
+                p.logger.Printf("Error discarding staged file %s: %v", staged, err)
+            }
+        }
+        return
+    }
+    if err := p.sql.DeleteDicom(parsed.UUID); err != nil {
+        p.logger.Printf("Error discarding DICOM %s: %v", parsed.UUID, err)
+    }
+    if err := p.blob.DeleteFile(originalURL(parsed.UUID)); err != nil {
+        p.logger.Printf("Error discarding original DICOM file %s: %v", parsed.UUID, err)
+    }
 }
 
-// To be implemented. Preferably an uploader service would be implemented as well if transferring files over https
-func (p *DicomParser) GetDicomDatasetByFile(file string) (*dicom.Dataset, error) {
-    return nil, nil
+// originalURL is where the original of a DICOM file is stored in the blob repository
+func originalURL(uuid string) string {
+    return fmt.Sprintf("output/dicom_%s.dcm", uuid)
+}
+
+// handleDuplicate applies the duplicate policy to a file that matches an instance already stored. Under
+// DuplicateReplace the file is reprocessed under the id of the instance, whose tags are only replaced
+// once the new ones are stored
+func (p *DicomParser) handleDuplicate(existing *model.Dicom) error {
+    if p.duplicatePolicy != DuplicateReplace {
+        p.logger.Printf("DICOM file is a duplicate of %s", existing.UUID)
+        return &DuplicateError{UUID: existing.UUID, Policy: p.duplicatePolicy}
+    }
+
+    p.logger.Printf("Replacing DICOM file %s", existing.UUID)
+    return nil
+}
+
+// IsDicomFile sniffs the 128 byte preamble and DICM magic word of a DICOM Part 10 file,
+// regardless of the file's name or extension
+func IsDicomFile(path string) (bool, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return false, err
+    }
+    defer file.Close()
+
+    header := make([]byte, 128+4)
+    if _, err := io.ReadFull(file, header); err != nil {
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            return false, nil
+        }
+        return false, err
+    }
+
+    return string(header[128:]) == "DICM", nil
+}
+
+// insertDicom registers a newly parsed DICOM file and returns the uuid it is known by. Its images are
+// rendered from the original, only files ingested before originals were kept have an image URL
+func (p *DicomParser) insertDicom() (string, error) {
+    uuid := common.GenShortUUID()
+
+    _, err := p.sql.InsertDicom("", uuid)
+    if err != nil {
+        p.logger.Printf("Error inserting DICOM into database: %v", err)
+        return "", err
+    }
+
+    return uuid, nil
 }
@@ -1,30 +1,40 @@
 package parser
 
 import (
+    "bytes"
     "errors"
+    "io"
     "log"
+    "os"
+    "strings"
     "testing"
 
+    "dicom/api/model"
+    "dicom/api/repository/blob"
     "dicom/api/repository/sql"
 )
 
 func TestDicomParser_GetDicomDatasetByPath_Success(t *testing.T) {
     mockSQLRepo := &sql.MockRepository{
         InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+            // Images are rendered from the original, nothing is written at the image URL
+            if imageURL != "" {
+                t.Errorf("Expected no image URL, got %q", imageURL)
+            }
             return 1, nil
         },
     }
 
-    parser := NewDicomParser(mockSQLRepo, log.Default())
+    parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
 
-    dataset, uuid, err := parser.GetDicomDatasetByPath("test_file.dcm")
+    parsed, err := parser.GetDicomDatasetByPath("test_file.dcm")
     if err != nil {
-        t.Errorf("Unexpected error: %v", err)
+        t.Fatalf("Unexpected error: %v", err)
     }
-    if dataset == nil {
+    if parsed.Dataset == nil {
         t.Error("Expected dataset, got nil")
     }
-    if uuid == "" {
+    if parsed.UUID == "" {
         t.Error("Expected uuid, got ''")
     }
 }
@@ -36,10 +46,277 @@ func TestDicomParser_GetDicomDatasetByPath_SQL_Error(t *testing.T) {
         },
     }
 
-    parser := NewDicomParser(mockSQLRepo, log.Default())
+    parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
 
-    _, _, err := parser.GetDicomDatasetByPath("test_file.dcm")
+    _, err := parser.GetDicomDatasetByPath("test_file.dcm")
     if err == nil {
         t.Error("Expected error, got nil")
     }
 }
+
+func TestDicomParser_GetDicomDatasetByFile_Success(t *testing.T) {
+    mockSQLRepo := &sql.MockRepository{
+        InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+            return 1, nil
+        },
+    }
+
+    parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
+
+    file, err := os.Open("test_file.dcm")
+    if err != nil {
+        t.Fatalf("Failed to open test file: %v", err)
+    }
+    defer file.Close()
+
+    parsed, err := parser.GetDicomDatasetByFile(file)
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if parsed.Dataset == nil {
+        t.Error("Expected dataset, got nil")
+    }
+    if parsed.UUID == "" {
+        t.Error("Expected uuid, got ''")
+    }
+}
+
+func TestDicomParser_GetDicomDatasetByFile_Invalid(t *testing.T) {
+    insertCalled := false
+    mockSQLRepo := &sql.MockRepository{
+        InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+            insertCalled = true
+            return 1, nil
+        },
+    }
+
+    parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
+
+    _, err := parser.GetDicomDatasetByFile(strings.NewReader("not a dicom file"))
+    if err == nil {
+        t.Error("Expected error, got nil")
+    }
+    if insertCalled {
+        t.Error("Expected no DICOM to be inserted for an invalid file")
+    }
+}
+
+func TestDicomParser_Duplicate(t *testing.T) {
+    tests := []struct {
+        policy      DuplicatePolicy
+        expectError bool
+        expectUUID  string
+    }{
+        {policy: DuplicateReject, expectError: true},
+        {policy: DuplicateExisting, expectError: true},
+        {policy: DuplicateReplace, expectUUID: "existing_uuid"},
+    }
+
+    for _, test := range tests {
+        insertCalled := false
+        tagsDeleted := false
+        mockSQLRepo := &sql.MockRepository{
+            InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+                insertCalled = true
+                return 1, nil
+            },
+            GetDicomByFingerprintFunc: func(sopInstanceUID string, fileHash string) (*model.Dicom, error) {
+                if sopInstanceUID == "" || len(fileHash) != 64 {
+                    t.Errorf("Expected SOP instance UID and SHA-256 hash, got %q, %q", sopInstanceUID, fileHash)
+                }
+                return &model.Dicom{ID: 1, UUID: "existing_uuid"}, nil
+            },
+            DeleteTagsByDicomUUIDFunc: func(uuid string) error {
+                tagsDeleted = true
+                return nil
+            },
+        }
+
+        parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
+        parser.SetDuplicatePolicy(test.policy)
+
+        parsed, err := parser.GetDicomDatasetByPath("test_file.dcm")
+        if test.expectError {
+            var duplicate *DuplicateError
+            if !errors.As(err, &duplicate) || !errors.Is(err, ErrDuplicate) {
+                t.Errorf("%s: expected duplicate error, got %v", test.policy, err)
+            } else if duplicate.UUID != "existing_uuid" || duplicate.Policy != test.policy {
+                t.Errorf("%s: unexpected duplicate error %+v", test.policy, duplicate)
+            }
+        } else if err != nil {
+            t.Errorf("%s: unexpected error: %v", test.policy, err)
+        } else if parsed.UUID != test.expectUUID || !parsed.Replaced {
+            t.Errorf("%s: expected to replace %q, got %+v", test.policy, test.expectUUID, parsed)
+        }
+        // The tags of a replaced instance are only replaced along with storing the new ones
+        if tagsDeleted {
+            t.Errorf("%s: expected no tags to be deleted", test.policy)
+        }
+        if insertCalled {
+            t.Errorf("%s: expected no new DICOM to be inserted", test.policy)
+        }
+    }
+}
+
+func TestDicomParser_Commit(t *testing.T) {
+    var fingerprint []string
+    mockSQLRepo := &sql.MockRepository{
+        SetDicomFingerprintFunc: func(uuid string, sopInstanceUID string, fileHash string) error {
+            fingerprint = []string{uuid, sopInstanceUID, fileHash}
+            return nil
+        },
+    }
+
+    parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
+
+    parsed, err := parser.GetDicomDatasetByPath("test_file.dcm")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if fingerprint != nil {
+        t.Fatal("Expected the fingerprint to be recorded only once committed")
+    }
+    if err := parser.Commit(parsed); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if len(fingerprint) != 3 || fingerprint[0] != parsed.UUID || fingerprint[1] != parsed.SOPInstanceUID || len(fingerprint[2]) != 64 {
+        t.Errorf("Unexpected fingerprint %q", fingerprint)
+    }
+}
+
+func TestDicomParser_Commit_Duplicate(t *testing.T) {
+    // A copy of the file was committed while this one was being ingested
+    committed := false
+    var deleted []string
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByFingerprintFunc: func(sopInstanceUID string, fileHash string) (*model.Dicom, error) {
+            if !committed {
+                return nil, nil
+            }
+            return &model.Dicom{ID: 1, UUID: "other_uuid"}, nil
+        },
+        SetDicomFingerprintFunc: func(uuid string, sopInstanceUID string, fileHash string) error {
+            committed = true
+            return sql.ErrDuplicateFingerprint
+        },
+        DeleteDicomFunc: func(uuid string) error {
+            deleted = append(deleted, uuid)
+            return nil
+        },
+    }
+    var removed []string
+    mockBlobRepo := &blob.MockRepository{
+        DeleteFileFunc: func(path string) error {
+            removed = append(removed, path)
+            return nil
+        },
+    }
+
+    parser := NewDicomParser(mockSQLRepo, mockBlobRepo, log.Default())
+
+    parsed, err := parser.GetDicomDatasetByPath("test_file.dcm")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    err = parser.Commit(parsed)
+    var duplicate *DuplicateError
+    if !errors.As(err, &duplicate) || duplicate.UUID != "other_uuid" || duplicate.Policy != DuplicateExisting {
+        t.Errorf("Expected a duplicate of other_uuid, got %v", err)
+    }
+
+    parser.Discard(parsed)
+    if len(deleted) != 1 || deleted[0] != parsed.UUID {
+        t.Errorf("Expected the duplicate to be discarded, got %q", deleted)
+    }
+    if len(removed) != 1 || removed[0] != "output/dicom_"+parsed.UUID+".dcm" {
+        t.Errorf("Expected the original of the duplicate to be removed, got %q", removed)
+    }
+}
+
+func TestDicomParser_Discard(t *testing.T) {
+    var deleted []string
+    mockSQLRepo := &sql.MockRepository{
+        SetDicomFileURLFunc: func(uuid string, fileURL string) error {
+            return errors.New("SQL error")
+        },
+        DeleteDicomFunc: func(uuid string) error {
+            deleted = append(deleted, uuid)
+            return nil
+        },
+    }
+
+    parser := NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
+
+    if _, err := parser.GetDicomDatasetByPath("test_file.dcm"); err == nil {
+        t.Fatal("Expected error, got nil")
+    }
+    if len(deleted) != 1 {
+        t.Errorf("Expected the half registered file to be discarded, got %q", deleted)
+    }
+
+    // A stored instance that fails to be replaced is kept
+    deleted = nil
+    parser.Discard(&ParsedDicom{UUID: "existing_uuid", Replaced: true})
+    if len(deleted) != 0 {
+        t.Errorf("Expected the replaced instance to be kept, got %q", deleted)
+    }
+}
+
+func TestParseDuplicatePolicy(t *testing.T) {
+    if policy, err := ParseDuplicatePolicy("replace"); err != nil || policy != DuplicateReplace {
+        t.Errorf("Expected replace policy, got %q, %v", policy, err)
+    }
+    if _, err := ParseDuplicatePolicy("ignore"); err == nil {
+        t.Error("Expected error for an unknown policy, got nil")
+    }
+}
+
+func TestIsDicomFile(t *testing.T) {
+    isDicom, err := IsDicomFile("test_file.dcm")
+    if err != nil || !isDicom {
+        t.Errorf("Expected test_file.dcm to be a DICOM file, got %v, %v", isDicom, err)
+    }
+
+    isDicom, err = IsDicomFile("parser.go")
+    if err != nil || isDicom {
+        t.Errorf("Expected parser.go not to be a DICOM file, got %v, %v", isDicom, err)
+    }
+
+    if _, err := IsDicomFile("non_existent_file.dcm"); err == nil {
+        t.Error("Expected error for a missing file, got nil")
+    }
+}
+
+func TestDicomParser_KeepsOriginal(t *testing.T) {
+    var fileURL string
+    mockSQLRepo := &sql.MockRepository{
+        InsertDicomFunc: func(imageURL string, uuid string) (int64, error) {
+            return 1, nil
+        },
+        SetDicomFileURLFunc: func(uuid string, url string) error {
+            fileURL = url
+            return nil
+        },
+    }
+    var original []byte
+    mockBlobRepo := &blob.MockRepository{
+        WriteFileFunc: func(r io.Reader, path string) error {
+            original, _ = io.ReadAll(r)
+            return nil
+        },
+    }
+
+    parser := NewDicomParser(mockSQLRepo, mockBlobRepo, log.Default())
+
+    parsed, err := parser.GetDicomDatasetByPath("test_file.dcm")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if fileURL != "output/dicom_"+parsed.UUID+".dcm" {
+        t.Errorf("Unexpected file URL %q", fileURL)
+    }
+    expected, _ := os.ReadFile("test_file.dcm")
+    if !bytes.Equal(original, expected) {
+        t.Errorf("Expected the original file to be stored unchanged, got %d bytes instead of %d", len(original), len(expected))
+    }
+}
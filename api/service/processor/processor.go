@@ -1,11 +1,18 @@
 package processor
 
 import (
+    "bytes"
+    "encoding/base64"
+    "fmt"
     "log"
+    "math"
+    "strconv"
+    "strings"
 
     "dicom/api/model"
     "dicom/api/repository/blob"
     "dicom/api/repository/sql"
+    "dicom/api/service/parser"
 
     "github.com/suyashkumar/dicom"
     "github.com/suyashkumar/dicom/pkg/tag"
@@ -13,12 +20,15 @@ import (
 
 type Processor interface {
     ExtractDicomHeaders(id string, dicomDataset *dicom.Dataset) error
-    ExtractDicomImage(id string, dicomDataset *dicom.Dataset) error
 }
 
+// DefaultBulkDataThreshold is the size in bytes above which binary values are kept out of the tags table
+const DefaultBulkDataThreshold = 1024
+
 type DicomProcessor struct {
     sql     sql.Repository
     blob   blob.Repository
+    bulkDataThreshold int
     logger  *log.Logger
 }
 
@@ -26,66 +36,251 @@ func NewDicomProcessor(sqlRepo sql.Repository, blobRepo blob.Repository, logger
     p := &DicomProcessor{
         sql:    sqlRepo,
         blob:  blobRepo,
+        bulkDataThreshold: DefaultBulkDataThreshold,
         logger: logger,
     }
 
     return p
 }
 
-// Get all the headers and read them and store them in SQL
+// SetBulkDataThreshold changes the size in bytes above which binary values, pixel data included, are
+// stored in the blob repository and linked from their tag instead of being stored inline
+func (p *DicomProcessor) SetBulkDataThreshold(threshold int) {
+    p.bulkDataThreshold = threshold
+}
+
+// BulkDataPath is where the bulk data of a DICOM file is stored in the blob repository, key being the
+// tag's BulkDataKey
+func BulkDataPath(uuid string, key string) string {
+    return fmt.Sprintf("output/bulkdata_%s_%s.bin", uuid, key)
+}
+
+// BulkDataURI is where the bulk data of a DICOM file is served
+func BulkDataURI(uuid string, key string) string {
+    return fmt.Sprintf("/dicom/%s/bulkdata/%s", uuid, key)
+}
+
+// Get all the headers and read them and store them in SQL, keeping the sequence items they are in
 func (p *DicomProcessor) ExtractDicomHeaders(id string, dicomDataset *dicom.Dataset) error {
+    return p.extractHeaders(id, dicomDataset, func(path string) string { return path })
+}
+
+// ExtractParsedHeaders stores the headers of a parsed file like ExtractDicomHeaders, the bulk data of a
+// file replacing a stored instance is staged until the parser commits it
+func (p *DicomProcessor) ExtractParsedHeaders(parsed *parser.ParsedDicom) error {
+    return p.extractHeaders(parsed.UUID, parsed.Dataset, parsed.Stage)
+}
+
+// extractHeaders stores the headers of the dataset, writing bulk data where stage puts its path
+func (p *DicomProcessor) extractHeaders(id string, dicomDataset *dicom.Dataset, stage func(path string) string) error {
     dicom, err := p.sql.GetDicomByUUID(id)
     if err != nil {
         p.logger.Printf("Error retrieving DICOM by UUID: %v", err)
         return err
     }
 
-    for elem := dicomDataset.FlatStatefulIterator(); elem.HasNext(); {
-        e := elem.Next()
+    tags, err := p.collectTags(dicom, dicomDataset.Elements, "", 0, nil, stage)
+    if err != nil {
+        p.removeBulkData(dicom.UUID, tags, stage)
+        return err
+    }
+    if err := p.sql.InsertTags(dicom.ID, tags); err != nil {
+        p.logger.Printf("Error inserting tags: %v", err)
+        p.removeBulkData(dicom.UUID, tags, stage)
+        return err
+    }
+
+    if err := p.sql.SetDicomAttributes(id, datasetAttributes(dicomDataset)); err != nil {
+        p.logger.Printf("Error setting DICOM attributes: %v", err)
+        p.removeBulkData(dicom.UUID, tags, stage)
+        return err
+    }
+
+    return nil
+}
+
+// RemoveBulkData removes the bulk data of the tags stored for a DICOM file, before the file is removed
+func (p *DicomProcessor) RemoveBulkData(uuid string) {
+    tags, err := p.sql.GetTagsByDicomUUID(uuid)
+    if err != nil {
+        p.logger.Printf("Error retrieving tags: %v", err)
+        return
+    }
+    p.removeBulkData(uuid, tags, func(path string) string { return path })
+}
+
+// removeBulkData removes the bulk data written for the tags where stage put it
+func (p *DicomProcessor) removeBulkData(uuid string, tags []model.Tag, stage func(path string) string) {
+    for _, t := range tags {
+        if t.BulkDataURI != "" {
+            p.blob.DeleteFile(stage(BulkDataPath(uuid, model.BulkDataKey(t.Path, t.Tag))))
+        }
+    }
+}
+
+// collectTags appends the elements of the item at path to tags, followed by the items of their sequences.
+// Bulk data is written where stage puts it. On error the tags collected so far are returned, so their bulk
+// data can be removed
+func (p *DicomProcessor) collectTags(stored *model.Dicom, elements []*dicom.Element, path string, item int, tags []model.Tag, stage func(path string) string) ([]model.Tag, error) {
+    for _, e := range elements {
         var tagName string
         if tagInfo, err := tag.Find(e.Tag); err == nil {
             tagName = tagInfo.Name
         }
-        
-        tag := model.Tag{
-            Tag:          e.Tag.String(),
-            Name:         tagName,
-            VR:           e.ValueRepresentation.String(),
-            Value:        e.Value.String(),
-        }
 
-        tagID, err := p.sql.InsertTag(tag)
-        if err != nil {
-            p.logger.Printf("Error inserting tag: %v", err)
-            return err
+        t := model.Tag{
+            Tag:   e.Tag.String(),
+            Name:  tagName,
+            VR:    e.ValueRepresentation.String(),
+            Value: e.Value.String(),
+            Path:  path,
+            Item:  item,
+            RawVR: e.RawValueRepresentation,
+        }
+        t.Values = tagValues(e)
+        if data, ok := bulkData(e); ok && len(data) > p.bulkDataThreshold {
+            key := model.BulkDataKey(path, t.Tag)
+            if err := p.blob.WriteFile(bytes.NewReader(data), stage(BulkDataPath(stored.UUID, key))); err != nil {
+                p.logger.Printf("Error writing bulk data: %v", err)
+                return tags, err
+            }
+            t.Value = ""
+            t.BulkDataURI = BulkDataURI(stored.UUID, key)
+        } else if ok {
+            // Small binary values are kept with the tags so they can be inlined in DICOM JSON
+            t.Values = []interface{}{base64.StdEncoding.EncodeToString(data)}
+        }
+        items, isSequence := e.Value.GetValue().([]*dicom.SequenceItemValue)
+        if isSequence {
+            t.Value = strconv.Itoa(len(items))
         }
 
-        _, err = p.sql.InsertDicomTag(dicom.ID, tagID)
-        if err != nil {
-            p.logger.Printf("Error inserting tag: %v", err)
-            return err
+        tags = append(tags, t)
+
+        for i, sequenceItem := range items {
+            itemElements, _ := sequenceItem.GetValue().([]*dicom.Element)
+            var err error
+            tags, err = p.collectTags(stored, itemElements, model.ItemPath(path, t.Tag, i), i, tags, stage)
+            if err != nil {
+                return tags, err
+            }
         }
     }
+    return tags, nil
+}
 
-    return nil
+// bulkData returns the bytes of a binary value. Native pixel data is little endian, and the frames of
+// encapsulated pixel data follow each other
+func bulkData(e *dicom.Element) ([]byte, bool) {
+    switch v := e.Value.GetValue().(type) {
+    case []byte:
+        return v, true
+    case dicom.PixelDataInfo:
+        if len(v.UnprocessedValueData) > 0 {
+            return v.UnprocessedValueData, true
+        }
+
+        var data bytes.Buffer
+        for _, frame := range v.Frames {
+            if frame.Encapsulated {
+                data.Write(frame.EncapsulatedData.Data)
+                continue
+            }
+            size := (frame.NativeData.BitsPerSample + 7) / 8
+            for _, pixel := range frame.NativeData.Data {
+                for _, sample := range pixel {
+                    for i := 0; i < size; i++ {
+                        data.WriteByte(byte(sample >> (8 * i)))
+                    }
+                }
+            }
+        }
+        return data.Bytes(), true
+    }
+    return nil, false
 }
 
-// Store image in "blob" storage and then store then generated the image URL and store the image URL in the database
-func (p *DicomProcessor) ExtractDicomImage(id string, dicomDataset *dicom.Dataset) error {
-    pixelDataElement, _ := dicomDataset.FindElementByTag(tag.PixelData)
-    pixelDataInfo := dicom.MustGetPixelDataInfo(pixelDataElement.Value)
-    for _, fr := range pixelDataInfo.Frames {
-        img, _ := fr.GetImage()
-        dicom, err := p.sql.GetDicomByUUID(id)
-        if err != nil {
-            p.logger.Printf("Error retrieving DICOM by UUID: %v", err)
-            return err
+// tagValues types the values of an element after its VR, binary values and sequences have none
+func tagValues(e *dicom.Element) []interface{} {
+    var values []interface{}
+    switch v := e.Value.GetValue().(type) {
+    case []string:
+        for _, value := range v {
+            values = append(values, typedString(e.RawValueRepresentation, value))
+        }
+    case []int:
+        for _, value := range v {
+            values = append(values, int64(value))
         }
-        if err := p.blob.WritePngToFile(img, dicom.ImageURL); err != nil {
-            p.logger.Printf("Error writing PNG to file: %v", err)
-            return err
+    case []float64:
+        for _, value := range v {
+            // NaN and infinities are valid binary floats that JSON cannot hold, they have no value
+            if math.IsInf(value, 0) || math.IsNaN(value) {
+                values = append(values, nil)
+                continue
+            }
+            values = append(values, value)
         }
     }
+    return values
+}
 
-    return nil
+// typedString parses a string value of the VR, values that do not parse are kept as strings
+func typedString(vr string, value string) interface{} {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return nil
+    }
+
+    var typed interface{}
+    var err error
+    switch vr {
+    case "DA":
+        typed, err = model.ParseDate(value)
+    case "TM":
+        typed, err = model.ParseTime(value)
+    case "DT":
+        typed, err = model.ParseDateTime(value)
+    case "PN":
+        typed = model.ParsePersonName(value)
+    case "IS":
+        typed, err = strconv.ParseInt(value, 10, 64)
+    case "DS":
+        var number float64
+        number, err = strconv.ParseFloat(value, 64)
+        // Decimal strings are not meant to hold NaN or infinities, which JSON cannot hold either
+        if err == nil && (math.IsInf(number, 0) || math.IsNaN(number)) {
+            return value
+        }
+        typed = number
+    default:
+        typed = value
+    }
+    if err != nil {
+        return value
+    }
+    return typed
+}
+
+// datasetAttributes picks the attributes DICOM files are searched by
+func datasetAttributes(dataset *dicom.Dataset) model.DicomAttributes {
+    return model.DicomAttributes{
+        StudyInstanceUID:       parser.FirstString(dataset, tag.StudyInstanceUID),
+        SeriesInstanceUID:      parser.FirstString(dataset, tag.SeriesInstanceUID),
+        SOPClassUID:            parser.FirstString(dataset, tag.SOPClassUID),
+        PatientID:              parser.FirstString(dataset, tag.PatientID),
+        PatientName:            parser.FirstString(dataset, tag.PatientName),
+        PatientBirthDate:       parser.FirstString(dataset, tag.PatientBirthDate),
+        PatientSex:             parser.FirstString(dataset, tag.PatientSex),
+        StudyDate:              parser.FirstString(dataset, tag.StudyDate),
+        StudyTime:              parser.FirstString(dataset, tag.StudyTime),
+        AccessionNumber:        parser.FirstString(dataset, tag.AccessionNumber),
+        StudyID:                parser.FirstString(dataset, tag.StudyID),
+        StudyDescription:       parser.FirstString(dataset, tag.StudyDescription),
+        ReferringPhysicianName: parser.FirstString(dataset, tag.ReferringPhysicianName),
+        Modality:               parser.FirstString(dataset, tag.Modality),
+        SeriesNumber:           parser.FirstString(dataset, tag.SeriesNumber),
+        SeriesDescription:      parser.FirstString(dataset, tag.SeriesDescription),
+        InstanceNumber:         parser.FirstString(dataset, tag.InstanceNumber),
+    }
 }
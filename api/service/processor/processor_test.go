@@ -1,10 +1,15 @@
 package processor
 
 import (
+    "encoding/base64"
+    "encoding/json"
     "errors"
-    "image"
+    "io"
     "log"
+    "math"
+    "reflect"
     "testing"
+    "time"
 
     "dicom/api/model"
     "dicom/api/repository/blob"
@@ -12,6 +17,7 @@ import (
     "dicom/api/service/parser"
 
     "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/tag"
 )
 
 func TestDicomProcessor_ExtractDicomHeaders_Success(t *testing.T) {
@@ -24,11 +30,8 @@ func TestDicomProcessor_ExtractDicomHeaders_Success(t *testing.T) {
         GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
             return &model.Dicom{ID: 1}, nil
         },
-        InsertTagFunc: func(tag model.Tag) (int64, error) {
-            return 1, nil
-        },
-        InsertDicomTagFunc: func(dicomID, tagID int64) (int64, error) {
-            return 1, nil
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            return nil
         },
     }
 
@@ -60,47 +63,222 @@ func TestDicomProcessor_ExtractDicomHeaders_SQL_Error(t *testing.T) {
     }
 }
 
-func TestDicomProcessor_ExtractDicomImage_Success(t *testing.T) {
+func TestDicomProcessor_ExtractDicomHeaders_Attributes(t *testing.T) {
+    var attributes model.DicomAttributes
     mockSQLRepo := &sql.MockRepository{
         GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
-            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+            return &model.Dicom{ID: 1}, nil
+        },
+        SetDicomAttributesFunc: func(uuid string, a model.DicomAttributes) error {
+            attributes = a
+            return nil
         },
     }
 
-    parser :=  parser.NewDicomParser(mockSQLRepo, log.Default()) 
+    parser := parser.NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default())
+    processor := NewDicomProcessor(mockSQLRepo, &blob.MockRepository{}, log.Default())
 
-    mockBlobRepo := &blob.MockRepository{
-        WritePngToFileFunc: func(image.Image, string) error {
+    parsed, err := parser.GetDicomDatasetByPath("test_file.dcm")
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    if err := processor.ExtractDicomHeaders("mock_uuid", parsed.Dataset); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if attributes.StudyInstanceUID != "1.2.840.114202.4.3505441013.825017129.509896760.4230310936" {
+        t.Errorf("Unexpected study instance UID %q", attributes.StudyInstanceUID)
+    }
+    if attributes.SOPClassUID == "" || attributes.Modality == "" {
+        t.Errorf("Expected SOP class and modality, got %+v", attributes)
+    }
+}
+
+func newElement(t *testing.T, elementTag tag.Tag, data interface{}) *dicom.Element {
+    element, err := dicom.NewElement(elementTag, data)
+    if err != nil {
+        t.Fatalf("Error creating element %v: %v", elementTag, err)
+    }
+    return element
+}
+
+func TestDicomProcessor_ExtractDicomHeaders_Sequences(t *testing.T) {
+    referenced := func(uid string) []*dicom.Element {
+        return []*dicom.Element{
+            newElement(t, tag.ReferencedSOPInstanceUID, []string{uid}),
+            newElement(t, tag.ReferencedFrameNumber, []string{"1"}),
+        }
+    }
+    dataset := &dicom.Dataset{Elements: []*dicom.Element{
+        newElement(t, tag.PatientID, []string{"5184"}),
+        newElement(t, tag.ReferencedImageSequence, [][]*dicom.Element{referenced("1.2.3"), referenced("1.2.4")}),
+    }}
+
+    var stored []model.Tag
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            stored = append(stored, tags...)
             return nil
         },
     }
 
-    processor := NewDicomProcessor(mockSQLRepo, mockBlobRepo, log.Default())
+    processor := NewDicomProcessor(mockSQLRepo, nil, log.Default())
+    if err := processor.ExtractDicomHeaders("mock_uuid", dataset); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
 
-    dataset, _, _ := parser.GetDicomDatasetByPath("test_file.dcm")
+    if len(stored) != 6 {
+        t.Fatalf("Expected 6 tags, got %+v", stored)
+    }
+    if sequence := stored[1]; sequence.Path != "" || sequence.Value != "2" {
+        t.Errorf("Expected the sequence at the top level with its item count, got %+v", sequence)
+    }
+    if second := stored[4]; second.Path != "(0008,1140)[1]" || second.Item != 1 || second.Value != "[1.2.4]" {
+        t.Errorf("Expected the UID of the second item with its path, got %+v", second)
+    }
+}
 
-    // Test ExtractDicomImage method
-    err := processor.ExtractDicomImage("mock_uuid", dataset)
-    if err != nil {
-        t.Errorf("Unexpected error: %v", err)
+func TestDicomProcessor_ExtractDicomHeaders_TypedValues(t *testing.T) {
+    dataset := &dicom.Dataset{Elements: []*dicom.Element{
+        newElement(t, tag.StudyDate, []string{"20131209"}),
+        newElement(t, tag.StudyTime, []string{"092316.5"}),
+        newElement(t, tag.AcquisitionDateTime, []string{"20131209092316+0100"}),
+        newElement(t, tag.PatientName, []string{"NAYYAR^HARSH"}),
+        newElement(t, tag.ImagerPixelSpacing, []string{"0.1", "0.2"}),
+        newElement(t, tag.InstanceNumber, []string{"7"}),
+        newElement(t, tag.Rows, []int{1184}),
+        newElement(t, tag.PatientAge, []string{"025Y"}),
+    }}
+
+    stored := map[string]model.Tag{}
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            for _, tag := range tags {
+                stored[tag.Name] = tag
+            }
+            return nil
+        },
+    }
+
+    processor := NewDicomProcessor(mockSQLRepo, nil, log.Default())
+    if err := processor.ExtractDicomHeaders("mock_uuid", dataset); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    expected := map[string][]interface{}{
+        "StudyDate":          {time.Date(2013, 12, 9, 0, 0, 0, 0, time.UTC)},
+        "StudyTime":          {time.Date(0, 1, 1, 9, 23, 16, 500000000, time.UTC)},
+        "PatientName":        {model.PersonName{Family: "NAYYAR", Given: "HARSH"}},
+        "ImagerPixelSpacing": {0.1, 0.2},
+        "InstanceNumber":     {int64(7)},
+        "Rows":               {int64(1184)},
+        "PatientAge":         {"025Y"},
+    }
+    for name, values := range expected {
+        if got := stored[name]; !reflect.DeepEqual(got.Values, values) {
+            t.Errorf("Expected %s values %v, got %+v", name, values, got)
+        }
+    }
+
+    dateTime, ok := stored["AcquisitionDateTime"].Values[0].(time.Time)
+    if !ok || !dateTime.Equal(time.Date(2013, 12, 9, 8, 23, 16, 0, time.UTC)) {
+        t.Errorf("Expected the date time with its offset, got %+v", stored["AcquisitionDateTime"])
+    }
+    if stored["StudyDate"].RawVR != "DA" {
+        t.Errorf("Expected the raw VR DA, got %q", stored["StudyDate"].RawVR)
     }
 }
 
-func TestDicomProcessor_ExtractDicomImage_SQL_Error(t *testing.T) {
+func TestDicomProcessor_ExtractDicomHeaders_NonFiniteValues(t *testing.T) {
+    dataset := &dicom.Dataset{Elements: []*dicom.Element{
+        newElement(t, tag.ImagerPixelSpacing, []string{"NaN", "Inf"}),
+        newElement(t, tag.RealWorldValueSlope, []float64{math.NaN(), math.Inf(-1), 1.5}),
+    }}
+
+    stored := map[string]model.Tag{}
     mockSQLRepo := &sql.MockRepository{
         GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
-            return nil, errors.New("SQL error")
+            return &model.Dicom{ID: 1}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            for _, tag := range tags {
+                // The values are stored as JSON
+                if _, err := json.Marshal(tag.Values); err != nil {
+                    return err
+                }
+                stored[tag.Name] = tag
+            }
+            return nil
         },
     }
 
-    parser :=  parser.NewDicomParser(mockSQLRepo, log.Default()) 
     processor := NewDicomProcessor(mockSQLRepo, nil, log.Default())
+    if err := processor.ExtractDicomHeaders("mock_uuid", dataset); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    expected := map[string][]interface{}{
+        "ImagerPixelSpacing":             {"NaN", "Inf"},
+        "RealWorldValueSlope": {nil, nil, 1.5},
+    }
+    for name, values := range expected {
+        if got := stored[name]; !reflect.DeepEqual(got.Values, values) {
+            t.Errorf("Expected %s values %v, got %+v", name, values, got)
+        }
+    }
+}
 
-    dataset, _, _ := parser.GetDicomDatasetByPath("test_file.dcm")
+func TestDicomProcessor_ExtractDicomHeaders_BulkData(t *testing.T) {
+    dataset := &dicom.Dataset{Elements: []*dicom.Element{
+        newElement(t, tag.PatientID, []string{"5184"}),
+        newElement(t, tag.ICCProfile, make([]byte, 2048)),
+        newElement(t, tag.ReferencedImageSequence, [][]*dicom.Element{{
+            newElement(t, tag.ICCProfile, make([]byte, 64)),
+        }}),
+    }}
 
-    // Test ExtractDicomImage method with SQL error
-    err := processor.ExtractDicomImage("mock_uuid", dataset)
-    if err == nil {
-        t.Error("Expected error, got nil")
+    stored := map[string]model.Tag{}
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, UUID: uuid}, nil
+        },
+        InsertTagsFunc: func(dicomID int64, tags []model.Tag) error {
+            for _, tag := range tags {
+                stored[tag.Path+tag.Tag] = tag
+            }
+            return nil
+        },
+    }
+    written := map[string]int{}
+    mockBlobRepo := &blob.MockRepository{
+        WriteFileFunc: func(r io.Reader, path string) error {
+            data, err := io.ReadAll(r)
+            written[path] = len(data)
+            return err
+        },
+    }
+
+    processor := NewDicomProcessor(mockSQLRepo, mockBlobRepo, log.Default())
+    processor.SetBulkDataThreshold(1024)
+    if err := processor.ExtractDicomHeaders("mock_uuid", dataset); err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    large := stored["(0028,2000)"]
+    if large.BulkDataURI != "/dicom/mock_uuid/bulkdata/00282000" || large.Value != "" {
+        t.Errorf("Expected the large value to be linked, got %+v", large)
+    }
+    if size := written[BulkDataPath("mock_uuid", "00282000")]; size != 2048 || len(written) != 1 {
+        t.Errorf("Expected only the large value in the blob repository, got %v", written)
+    }
+    small := stored["(0008,1140)[0](0028,2000)"]
+    if small.BulkDataURI != "" || !reflect.DeepEqual(small.Values, []interface{}{base64.StdEncoding.EncodeToString(make([]byte, 64))}) {
+        t.Errorf("Expected the small value inline, got %+v", small)
     }
 }
@@ -0,0 +1,61 @@
+package renderer
+
+import (
+    "errors"
+    "image"
+    "image/color"
+    "image/gif"
+    "image/jpeg"
+    "image/png"
+    "io"
+)
+
+// Media types images can be encoded to
+const (
+    MediaTypeJPEG = "image/jpeg"
+    MediaTypePNG  = "image/png"
+    MediaTypeGIF  = "image/gif"
+)
+
+// DefaultQuality is the JPEG quality used when none is asked for
+const DefaultQuality = 75
+
+// ThumbnailSize is the largest width and height of a thumbnail
+const ThumbnailSize = 128
+
+// ErrUnsupportedMediaType is returned when encoding to a media type other than JPEG, PNG or GIF
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// MediaTypes lists the media types images can be encoded to, the first is the default
+var MediaTypes = []string{MediaTypeJPEG, MediaTypePNG, MediaTypeGIF}
+
+// Encode writes the image in the media type, quality from 1 to 100 only applies to JPEG
+func Encode(w io.Writer, img image.Image, mediaType string, quality int) error {
+    switch mediaType {
+    case MediaTypeJPEG:
+        if quality < 1 || quality > 100 {
+            quality = DefaultQuality
+        }
+        return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+    case MediaTypePNG:
+        return png.Encode(w, img)
+    case MediaTypeGIF:
+        // Greyscale images keep all their levels with a grey palette instead of the default one
+        if gray, ok := img.(*image.Gray); ok {
+            palette := make(color.Palette, 256)
+            for i := range palette {
+                palette[i] = color.Gray{Y: uint8(i)}
+            }
+            bounds := gray.Bounds()
+            paletted := image.NewPaletted(bounds, palette)
+            for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+                for x := bounds.Min.X; x < bounds.Max.X; x++ {
+                    paletted.SetColorIndex(x, y, gray.GrayAt(x, y).Y)
+                }
+            }
+            return gif.Encode(w, paletted, nil)
+        }
+        return gif.Encode(w, img, nil)
+    }
+    return ErrUnsupportedMediaType
+}
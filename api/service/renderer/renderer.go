@@ -0,0 +1,329 @@
+package renderer
+
+import (
+    "errors"
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "log"
+    "math"
+    "strconv"
+    "strings"
+
+    "dicom/api/service/parser"
+
+    "github.com/suyashkumar/dicom"
+    "github.com/suyashkumar/dicom/pkg/frame"
+    "github.com/suyashkumar/dicom/pkg/tag"
+)
+
+// VOI LUT functions a window can be applied with (PS3.3 section C.11.2.1.3)
+const (
+    FunctionLinear      = "linear"
+    FunctionLinearExact = "linear_exact"
+    FunctionSigmoid     = "sigmoid"
+)
+
+// ErrInvalidOptions is returned for window or viewport parameters that cannot be parsed
+var ErrInvalidOptions = errors.New("invalid rendering options")
+
+// ErrNoFrame is returned when the frame to render does not exist
+var ErrNoFrame = errors.New("no such frame")
+
+// Window maps stored pixel values to displayed grey levels
+type Window struct {
+    Center   float64
+    Width    float64
+    Function string
+}
+
+// Viewport is the size of the rendered image and the region of the frame it shows. A zero source
+// width or height means the whole frame
+type Viewport struct {
+    Width        int
+    Height       int
+    SourceX      int
+    SourceY      int
+    SourceWidth  int
+    SourceHeight int
+}
+
+// Options of a rendering, a nil window uses the window of the file and a nil viewport the frame's size
+type Options struct {
+    Window   *Window
+    Viewport *Viewport
+}
+
+type Renderer interface {
+    Render(dataset *dicom.Dataset, frame int, options Options) (image.Image, error)
+}
+
+// DicomRenderer turns frames of DICOM pixel data into images for display
+type DicomRenderer struct {
+    logger *log.Logger
+}
+
+func NewDicomRenderer(logger *log.Logger) *DicomRenderer {
+    return &DicomRenderer{
+        logger: logger,
+    }
+}
+
+// ParseWindow parses a window written as center,width[,function]
+func ParseWindow(value string) (*Window, error) {
+    fields := strings.Split(value, ",")
+    if len(fields) < 2 || len(fields) > 3 {
+        return nil, fmt.Errorf("%w: window must be center,width[,function]", ErrInvalidOptions)
+    }
+
+    center, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+    if err != nil {
+        return nil, fmt.Errorf("%w: invalid window center %q", ErrInvalidOptions, fields[0])
+    }
+    width, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+    if err != nil || width < 1 {
+        return nil, fmt.Errorf("%w: invalid window width %q", ErrInvalidOptions, fields[1])
+    }
+
+    window := &Window{Center: center, Width: width, Function: FunctionLinear}
+    if len(fields) == 3 {
+        window.Function = strings.ToLower(strings.TrimSpace(fields[2]))
+        switch window.Function {
+        case FunctionLinear, FunctionLinearExact, FunctionSigmoid:
+        default:
+            return nil, fmt.Errorf("%w: unknown window function %q", ErrInvalidOptions, fields[2])
+        }
+    }
+    return window, nil
+}
+
+// ParseViewport parses a viewport written as vw,vh[,sx,sy,sw,sh]
+func ParseViewport(value string) (*Viewport, error) {
+    fields := strings.Split(value, ",")
+    if len(fields) != 2 && len(fields) != 6 {
+        return nil, fmt.Errorf("%w: viewport must be vw,vh[,sx,sy,sw,sh]", ErrInvalidOptions)
+    }
+
+    numbers := make([]int, len(fields))
+    for i, field := range fields {
+        field = strings.TrimSpace(field)
+        if field == "" {
+            continue
+        }
+        number, err := strconv.Atoi(field)
+        if err != nil || number < 0 {
+            return nil, fmt.Errorf("%w: invalid viewport value %q", ErrInvalidOptions, field)
+        }
+        numbers[i] = number
+    }
+    if numbers[0] == 0 || numbers[1] == 0 {
+        return nil, fmt.Errorf("%w: viewport width and height are required", ErrInvalidOptions)
+    }
+
+    viewport := &Viewport{Width: numbers[0], Height: numbers[1]}
+    if len(numbers) == 6 {
+        viewport.SourceX, viewport.SourceY = numbers[2], numbers[3]
+        viewport.SourceWidth, viewport.SourceHeight = numbers[4], numbers[5]
+    }
+    return viewport, nil
+}
+
+// Render renders the frame, numbered from 1, of a dataset parsed with its pixel data
+func (r *DicomRenderer) Render(dataset *dicom.Dataset, frame int, options Options) (image.Image, error) {
+    element, err := dataset.FindElementByTag(tag.PixelData)
+    if err != nil {
+        return nil, fmt.Errorf("%w: no pixel data", ErrNoFrame)
+    }
+    info := dicom.MustGetPixelDataInfo(element.Value)
+    if frame < 1 || frame > len(info.Frames) {
+        return nil, fmt.Errorf("%w: frame %d", ErrNoFrame, frame)
+    }
+
+    var img image.Image
+    current := info.Frames[frame-1]
+    if current.Encapsulated {
+        // Compressed frames are decoded to display values already, windowing does not apply
+        img, err = current.GetImage()
+        if err != nil {
+            r.logger.Printf("Error decoding frame: %v", err)
+            return nil, err
+        }
+    } else {
+        img = renderNative(dataset, &current.NativeData, options.Window)
+    }
+
+    if options.Viewport != nil {
+        img = applyViewport(img, options.Viewport)
+    }
+    return img, nil
+}
+
+// renderNative renders native pixel data: rescaled and windowed for greyscale, as is for color
+func renderNative(dataset *dicom.Dataset, native *frame.NativeFrame, window *Window) image.Image {
+    bounds := image.Rect(0, 0, native.Cols, native.Rows)
+    photometric := parser.FirstString(dataset, tag.PhotometricInterpretation)
+
+    if len(native.Data) > 0 && len(native.Data[0]) >= 3 {
+        img := image.NewRGBA(bounds)
+        shift := 0
+        if native.BitsPerSample > 8 {
+            shift = native.BitsPerSample - 8
+        }
+        for i, pixel := range native.Data {
+            r, g, b := uint8(pixel[0]>>shift), uint8(pixel[1]>>shift), uint8(pixel[2]>>shift)
+            if strings.HasPrefix(photometric, "YBR") {
+                r, g, b = color.YCbCrToRGB(r, g, b)
+            }
+            img.SetRGBA(i%native.Cols, i/native.Cols, color.RGBA{R: r, G: g, B: b, A: 0xff})
+        }
+        return img
+    }
+
+    slope, intercept := 1.0, 0.0
+    if value, err := strconv.ParseFloat(parser.FirstString(dataset, tag.RescaleSlope), 64); err == nil && value != 0 {
+        slope = value
+    }
+    if value, err := strconv.ParseFloat(parser.FirstString(dataset, tag.RescaleIntercept), 64); err == nil {
+        intercept = value
+    }
+    bitsStored := parser.FirstInt(dataset, tag.BitsStored)
+    if bitsStored == 0 {
+        bitsStored = native.BitsPerSample
+    }
+    signed := parser.FirstInt(dataset, tag.PixelRepresentation) == 1
+
+    values := make([]float64, len(native.Data))
+    minimum, maximum := math.Inf(1), math.Inf(-1)
+    for i, pixel := range native.Data {
+        value := pixel[0]
+        // The pixel data is read as unsigned, two's complement values need their sign back
+        if signed && bitsStored > 0 && value >= 1<<(bitsStored-1) {
+            value -= 1 << bitsStored
+        }
+        values[i] = float64(value)*slope + intercept
+        minimum = math.Min(minimum, values[i])
+        maximum = math.Max(maximum, values[i])
+    }
+
+    if window == nil {
+        window = datasetWindow(dataset)
+    }
+    if window == nil {
+        // Without any window the full range of values is shown
+        window = &Window{Center: (minimum + maximum) / 2, Width: math.Max(maximum-minimum, 1), Function: FunctionLinearExact}
+    }
+
+    invert := photometric == "MONOCHROME1"
+    img := image.NewGray(bounds)
+    for i, value := range values {
+        if i >= len(img.Pix) {
+            break
+        }
+        level := window.apply(value)
+        if invert {
+            level = 255 - level
+        }
+        img.Pix[i] = level
+    }
+    return img
+}
+
+// datasetWindow is the first window stored in the file, if any
+func datasetWindow(dataset *dicom.Dataset) *Window {
+    center, err := strconv.ParseFloat(parser.FirstString(dataset, tag.WindowCenter), 64)
+    if err != nil {
+        return nil
+    }
+    width, err := strconv.ParseFloat(parser.FirstString(dataset, tag.WindowWidth), 64)
+    if err != nil || width < 1 {
+        return nil
+    }
+
+    function := strings.ToLower(parser.FirstString(dataset, tag.VOILUTFunction))
+    switch function {
+    case FunctionLinearExact, FunctionSigmoid:
+    default:
+        function = FunctionLinear
+    }
+    return &Window{Center: center, Width: width, Function: function}
+}
+
+// apply maps a value to a grey level following PS3.3 section C.11.2.1.2
+func (w *Window) apply(value float64) uint8 {
+    var level float64
+    switch w.Function {
+    case FunctionSigmoid:
+        level = 255 / (1 + math.Exp(-4*(value-w.Center)/w.Width))
+    case FunctionLinearExact:
+        level = ((value-w.Center)/w.Width + 0.5) * 255
+    default:
+        if w.Width == 1 {
+            if value < w.Center-0.5 {
+                return 0
+            }
+            return 255
+        }
+        level = ((value-(w.Center-0.5))/(w.Width-1) + 0.5) * 255
+    }
+    return uint8(math.Max(0, math.Min(255, math.Round(level))))
+}
+
+// applyViewport crops the source region of the viewport and scales it to fit the viewport, keeping
+// its aspect ratio
+func applyViewport(img image.Image, viewport *Viewport) image.Image {
+    bounds := img.Bounds()
+    source := bounds
+    if viewport.SourceWidth > 0 && viewport.SourceHeight > 0 {
+        source = image.Rect(viewport.SourceX, viewport.SourceY, viewport.SourceX+viewport.SourceWidth, viewport.SourceY+viewport.SourceHeight).Add(bounds.Min).Intersect(bounds)
+        if source.Empty() {
+            source = bounds
+        }
+    }
+
+    scale := math.Min(float64(viewport.Width)/float64(source.Dx()), float64(viewport.Height)/float64(source.Dy()))
+    width := int(math.Max(1, math.Round(float64(source.Dx())*scale)))
+    height := int(math.Max(1, math.Round(float64(source.Dy())*scale)))
+    return resize(img, source, width, height)
+}
+
+// resize scales the region of the image to width by height, averaging the source pixels covered by
+// each destination pixel
+func resize(img image.Image, source image.Rectangle, width int, height int) image.Image {
+    _, gray := img.(*image.Gray)
+    var dst draw.Image = image.NewRGBA(image.Rect(0, 0, width, height))
+    if gray {
+        dst = image.NewGray(image.Rect(0, 0, width, height))
+    }
+
+    scaleX := float64(source.Dx()) / float64(width)
+    scaleY := float64(source.Dy()) / float64(height)
+    for y := 0; y < height; y++ {
+        y0 := source.Min.Y + int(float64(y)*scaleY)
+        y1 := max(y0+1, source.Min.Y+int(float64(y+1)*scaleY))
+        for x := 0; x < width; x++ {
+            x0 := source.Min.X + int(float64(x)*scaleX)
+            x1 := max(x0+1, source.Min.X+int(float64(x+1)*scaleX))
+
+            var r, g, b, a, n uint64
+            for sy := y0; sy < y1 && sy < source.Max.Y; sy++ {
+                for sx := x0; sx < x1 && sx < source.Max.X; sx++ {
+                    cr, cg, cb, ca := img.At(sx, sy).RGBA()
+                    r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+                }
+            }
+            if n == 0 {
+                continue
+            }
+            dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+        }
+    }
+    return dst
+}
+
+func max(a int, b int) int {
+    if a > b {
+        return a
+    }
+    return b
+}
@@ -0,0 +1,126 @@
+package renderer
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "image/jpeg"
+    "log"
+    "os"
+    "testing"
+
+    "github.com/suyashkumar/dicom"
+)
+
+func parseTestFile(t *testing.T) *dicom.Dataset {
+    file, err := os.Open("../parser/test_file.dcm")
+    if err != nil {
+        t.Fatalf("Error opening test file: %v", err)
+    }
+    defer file.Close()
+
+    dataset, err := dicom.ParseUntilEOF(file, nil)
+    if err != nil {
+        t.Fatalf("Error parsing test file: %v", err)
+    }
+    return &dataset
+}
+
+func TestParseWindow(t *testing.T) {
+    window, err := ParseWindow("40,400")
+    if err != nil || *window != (Window{Center: 40, Width: 400, Function: FunctionLinear}) {
+        t.Errorf("Unexpected window %+v, %v", window, err)
+    }
+
+    window, err = ParseWindow("-600,1500,SIGMOID")
+    if err != nil || *window != (Window{Center: -600, Width: 1500, Function: FunctionSigmoid}) {
+        t.Errorf("Unexpected window %+v, %v", window, err)
+    }
+
+    for _, value := range []string{"40", "40,0", "a,400", "40,400,cubic", "1,2,3,4"} {
+        if _, err := ParseWindow(value); !errors.Is(err, ErrInvalidOptions) {
+            t.Errorf("Expected ErrInvalidOptions for %q, got %v", value, err)
+        }
+    }
+}
+
+func TestParseViewport(t *testing.T) {
+    viewport, err := ParseViewport("256,128,10,20,100,50")
+    expected := Viewport{Width: 256, Height: 128, SourceX: 10, SourceY: 20, SourceWidth: 100, SourceHeight: 50}
+    if err != nil || *viewport != expected {
+        t.Errorf("Unexpected viewport %+v, %v", viewport, err)
+    }
+
+    for _, value := range []string{"256", "0,128", "256,-1", "256,128,1,2"} {
+        if _, err := ParseViewport(value); !errors.Is(err, ErrInvalidOptions) {
+            t.Errorf("Expected ErrInvalidOptions for %q, got %v", value, err)
+        }
+    }
+}
+
+func TestWindow_Apply(t *testing.T) {
+    window := &Window{Center: 100, Width: 200, Function: FunctionLinear}
+    if window.apply(-50) != 0 || window.apply(250) != 255 {
+        t.Error("Expected values outside the window to be clamped")
+    }
+    if level := window.apply(100); level < 127 || level > 128 {
+        t.Errorf("Expected the center to be mid grey, got %d", level)
+    }
+
+    sigmoid := &Window{Center: 100, Width: 200, Function: FunctionSigmoid}
+    if level := sigmoid.apply(100); level != 128 {
+        t.Errorf("Expected the sigmoid center to be mid grey, got %d", level)
+    }
+}
+
+func TestDicomRenderer_Render(t *testing.T) {
+    dataset := parseTestFile(t)
+    dicomRenderer := NewDicomRenderer(log.Default())
+
+    img, err := dicomRenderer.Render(dataset, 1, Options{})
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if bounds := img.Bounds(); bounds.Dx() != 1184 || bounds.Dy() != 1537 {
+        t.Errorf("Expected a 1184x1537 image, got %v", bounds)
+    }
+
+    // A narrow window turns almost everything black or white
+    windowed, err := dicomRenderer.Render(dataset, 1, Options{
+        Window:   &Window{Center: 0, Width: 1, Function: FunctionLinear},
+        Viewport: &Viewport{Width: 64, Height: 64, SourceX: 100, SourceY: 100, SourceWidth: 200, SourceHeight: 200},
+    })
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    if bounds := windowed.Bounds(); bounds.Dx() != 64 || bounds.Dy() != 64 {
+        t.Errorf("Expected a 64x64 image, got %v", bounds)
+    }
+
+    if _, err := dicomRenderer.Render(dataset, 2, Options{}); !errors.Is(err, ErrNoFrame) {
+        t.Errorf("Expected ErrNoFrame, got %v", err)
+    }
+}
+
+func TestEncode(t *testing.T) {
+    img := image.NewGray(image.Rect(0, 0, 16, 16))
+
+    for _, mediaType := range MediaTypes {
+        var buffer bytes.Buffer
+        if err := Encode(&buffer, img, mediaType, DefaultQuality); err != nil || buffer.Len() == 0 {
+            t.Errorf("Error encoding %s: %v", mediaType, err)
+        }
+    }
+
+    var buffer bytes.Buffer
+    if err := Encode(&buffer, img, MediaTypeJPEG, 0); err != nil {
+        t.Errorf("Expected an out of range quality to fall back to the default, got %v", err)
+    }
+    if _, err := jpeg.Decode(&buffer); err != nil {
+        t.Errorf("Expected a JPEG image, got %v", err)
+    }
+
+    if err := Encode(&buffer, img, "image/tiff", DefaultQuality); !errors.Is(err, ErrUnsupportedMediaType) {
+        t.Errorf("Expected ErrUnsupportedMediaType, got %v", err)
+    }
+}
@@ -0,0 +1,327 @@
+package uploader
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "dicom/api/common"
+    "dicom/api/model"
+)
+
+const (
+    // DefaultChunkSize is used when an upload is created without a chunk size
+    DefaultChunkSize = 30 * 1024 * 1024
+    // MinChunkSize is the smallest chunk an upload can be split in, unless one chunk holds the whole file
+    MinChunkSize = 64 * 1024
+    // MaxChunks is the largest number of chunks an upload can be split in
+    MaxChunks = 10000
+    // MaxUploadSize is the size in bytes of the largest file that can be uploaded
+    MaxUploadSize = 32 * 1024 * 1024 * 1024
+    // DefaultExpiry is how long an upload is kept without receiving a chunk
+    DefaultExpiry = 24 * time.Hour
+)
+
+var (
+    ErrUploadNotFound   = errors.New("upload not found")
+    ErrInvalidUpload    = errors.New("invalid upload size")
+    ErrInvalidChunk     = errors.New("invalid chunk")
+    ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+    ErrUploadIncomplete = errors.New("upload is missing chunks")
+)
+
+type Uploader interface {
+    CreateUpload(size int64, chunkSize int64) (*model.Upload, error)
+    GetUpload(id string) (*model.Upload, error)
+    WriteChunk(id string, index int, checksum string, chunk io.Reader) error
+    OpenUpload(id string) (io.ReadCloser, error)
+    DeleteUpload(id string) error
+}
+
+// DicomUploader keeps every upload session in its own directory, with a manifest describing the session
+// and one file per received chunk, so an interrupted transfer can be resumed until it expires
+type DicomUploader struct {
+    dir    string
+    expiry time.Duration
+    logger *log.Logger
+}
+
+func NewDicomUploader(dir string, logger *log.Logger) (*DicomUploader, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        logger.Printf("Error creating upload directory: %v", err)
+        return nil, err
+    }
+
+    u := &DicomUploader{
+        dir:    dir,
+        expiry: DefaultExpiry,
+        logger: logger,
+    }
+    u.removeExpired()
+
+    return u, nil
+}
+
+// SetExpiry changes how long an upload is kept without receiving a chunk. Expired uploads are removed
+// when a new upload is created
+func (u *DicomUploader) SetExpiry(expiry time.Duration) {
+    u.expiry = expiry
+}
+
+// CreateUpload starts a new upload session for a file of the given size
+func (u *DicomUploader) CreateUpload(size int64, chunkSize int64) (*model.Upload, error) {
+    if chunkSize == 0 {
+        chunkSize = DefaultChunkSize
+    }
+    if size <= 0 || size > MaxUploadSize {
+        return nil, fmt.Errorf("%w: size must be from 1 to %d bytes", ErrInvalidUpload, int64(MaxUploadSize))
+    }
+    if chunkSize < 0 || (chunkSize < MinChunkSize && chunkSize < size) {
+        return nil, fmt.Errorf("%w: chunks must be at least %d bytes", ErrInvalidUpload, MinChunkSize)
+    }
+    if (size+chunkSize-1)/chunkSize > MaxChunks {
+        return nil, fmt.Errorf("%w: at most %d chunks", ErrInvalidUpload, MaxChunks)
+    }
+    u.removeExpired()
+
+    upload := &model.Upload{
+        ID:        common.GenShortUUID(),
+        Size:      size,
+        ChunkSize: chunkSize,
+        Chunks:    int((size + chunkSize - 1) / chunkSize),
+    }
+
+    if err := os.MkdirAll(u.uploadDir(upload.ID), 0755); err != nil {
+        u.logger.Printf("Error creating upload session directory: %v", err)
+        return nil, err
+    }
+
+    manifest, err := json.Marshal(upload)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.WriteFile(u.manifestPath(upload.ID), manifest, 0644); err != nil {
+        u.logger.Printf("Error writing upload manifest: %v", err)
+        return nil, err
+    }
+
+    upload.Missing = u.missingChunks(upload)
+    return upload, nil
+}
+
+// GetUpload returns the upload session along with the chunks that still have to be sent
+func (u *DicomUploader) GetUpload(id string) (*model.Upload, error) {
+    // ids are generated by us and never contain path separators
+    if id == "" || strings.ContainsAny(id, `/\.`) {
+        return nil, ErrUploadNotFound
+    }
+
+    manifest, err := os.ReadFile(u.manifestPath(id))
+    if errors.Is(err, os.ErrNotExist) {
+        return nil, ErrUploadNotFound
+    }
+    if err != nil {
+        u.logger.Printf("Error reading upload manifest: %v", err)
+        return nil, err
+    }
+
+    var upload model.Upload
+    if err := json.Unmarshal(manifest, &upload); err != nil {
+        u.logger.Printf("Error decoding upload manifest: %v", err)
+        return nil, err
+    }
+
+    upload.Missing = u.missingChunks(&upload)
+    return &upload, nil
+}
+
+// WriteChunk stores one chunk of an upload once its size and SHA-256 checksum have been verified.
+// Sending a chunk again replaces the previous copy
+func (u *DicomUploader) WriteChunk(id string, index int, checksum string, chunk io.Reader) error {
+    upload, err := u.GetUpload(id)
+    if err != nil {
+        return err
+    }
+
+    if index < 0 || index >= upload.Chunks {
+        return fmt.Errorf("%w: index %d out of range", ErrInvalidChunk, index)
+    }
+
+    tmp, err := os.CreateTemp(u.uploadDir(id), "chunk-*.tmp")
+    if err != nil {
+        u.logger.Printf("Error creating chunk file: %v", err)
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    // Read one byte more than expected so oversized chunks can be detected
+    expected := chunkSize(upload, index)
+    hash := sha256.New()
+    written, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(chunk, expected+1))
+    tmp.Close()
+    if err != nil {
+        u.logger.Printf("Error writing chunk: %v", err)
+        return err
+    }
+
+    if written != expected {
+        return fmt.Errorf("%w: expected %d bytes, got %d", ErrInvalidChunk, expected, written)
+    }
+    if !strings.EqualFold(hex.EncodeToString(hash.Sum(nil)), checksum) {
+        return ErrChecksumMismatch
+    }
+
+    if err := os.Rename(tmp.Name(), u.chunkPath(id, index)); err != nil {
+        u.logger.Printf("Error storing chunk: %v", err)
+        return err
+    }
+
+    return nil
+}
+
+// OpenUpload returns the assembled file of a complete upload
+func (u *DicomUploader) OpenUpload(id string) (io.ReadCloser, error) {
+    upload, err := u.GetUpload(id)
+    if err != nil {
+        return nil, err
+    }
+    if len(upload.Missing) > 0 {
+        return nil, ErrUploadIncomplete
+    }
+
+    files := make([]*os.File, 0, upload.Chunks)
+    for i := 0; i < upload.Chunks; i++ {
+        file, err := os.Open(u.chunkPath(id, i))
+        if err != nil {
+            u.logger.Printf("Error opening chunk: %v", err)
+            newAssembledUpload(files).Close()
+            return nil, err
+        }
+        files = append(files, file)
+    }
+
+    return newAssembledUpload(files), nil
+}
+
+// DeleteUpload removes the upload session and every chunk received for it
+func (u *DicomUploader) DeleteUpload(id string) error {
+    if _, err := u.GetUpload(id); err != nil {
+        return err
+    }
+
+    if err := os.RemoveAll(u.uploadDir(id)); err != nil {
+        u.logger.Printf("Error deleting upload: %v", err)
+        return err
+    }
+
+    return nil
+}
+
+// missingChunks lists the runs of consecutive chunks that were not received yet
+func (u *DicomUploader) missingChunks(upload *model.Upload) []model.UploadChunk {
+    received := map[string]bool{}
+    if entries, err := os.ReadDir(u.uploadDir(upload.ID)); err == nil {
+        for _, entry := range entries {
+            received[entry.Name()] = true
+        }
+    }
+
+    missing := []model.UploadChunk{}
+    for i := 0; i < upload.Chunks; i++ {
+        if received[filepath.Base(u.chunkPath(upload.ID, i))] {
+            continue
+        }
+
+        if last := len(missing) - 1; last >= 0 && missing[last].Index+missing[last].Count == i {
+            missing[last].Count++
+            missing[last].Size += chunkSize(upload, i)
+            continue
+        }
+        missing = append(missing, model.UploadChunk{
+            Index:  i,
+            Count:  1,
+            Offset: int64(i) * upload.ChunkSize,
+            Size:   chunkSize(upload, i),
+        })
+    }
+
+    return missing
+}
+
+// removeExpired removes the uploads that received no chunk for longer than the expiry, the directory of
+// an upload being modified with each chunk
+func (u *DicomUploader) removeExpired() {
+    entries, err := os.ReadDir(u.dir)
+    if err != nil {
+        u.logger.Printf("Error listing uploads: %v", err)
+        return
+    }
+
+    for _, entry := range entries {
+        info, err := entry.Info()
+        if err != nil || !entry.IsDir() || time.Since(info.ModTime()) < u.expiry {
+            continue
+        }
+        u.logger.Printf("Removing expired upload %s", entry.Name())
+        if err := os.RemoveAll(u.uploadDir(entry.Name())); err != nil {
+            u.logger.Printf("Error removing expired upload: %v", err)
+        }
+    }
+}
+
+func (u *DicomUploader) uploadDir(id string) string {
+    return filepath.Join(u.dir, id)
+}
+
+func (u *DicomUploader) manifestPath(id string) string {
+    return filepath.Join(u.uploadDir(id), "upload.json")
+}
+
+func (u *DicomUploader) chunkPath(id string, index int) string {
+    return filepath.Join(u.uploadDir(id), fmt.Sprintf("%06d.chunk", index))
+}
+
+// chunkSize is the size of the chunk at the index, every chunk is full except for the last one
+func chunkSize(upload *model.Upload, index int) int64 {
+    offset := int64(index) * upload.ChunkSize
+    if remaining := upload.Size - offset; remaining < upload.ChunkSize {
+        return remaining
+    }
+    return upload.ChunkSize
+}
+
+// assembledUpload reads the chunk files of an upload one after the other
+type assembledUpload struct {
+    io.Reader
+    files []*os.File
+}
+
+func newAssembledUpload(files []*os.File) *assembledUpload {
+    readers := make([]io.Reader, len(files))
+    for i, file := range files {
+        readers[i] = file
+    }
+
+    return &assembledUpload{
+        Reader: io.MultiReader(readers...),
+        files:  files,
+    }
+}
+
+func (a *assembledUpload) Close() error {
+    var err error
+    for _, file := range a.files {
+        if closeErr := file.Close(); closeErr != nil {
+            err = closeErr
+        }
+    }
+    return err
+}
@@ -0,0 +1,167 @@
+package uploader
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "io"
+    "log"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "dicom/api/model"
+)
+
+func checksum(data []byte) string {
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:])
+}
+
+func TestDicomUploader_ResumeAndAssemble(t *testing.T) {
+    uploader, err := NewDicomUploader(t.TempDir(), log.Default())
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+
+    // Four chunks, the last one holding a single byte
+    data := make([]byte, 3*MinChunkSize+1)
+    for i := range data {
+        data[i] = byte(i % 251)
+    }
+    chunk := func(index int) []byte {
+        end := (index + 1) * MinChunkSize
+        if end > len(data) {
+            end = len(data)
+        }
+        return data[index*MinChunkSize : end]
+    }
+    upload, err := uploader.CreateUpload(int64(len(data)), MinChunkSize)
+    if err != nil {
+        t.Fatalf("CreateUpload failed: %v", err)
+    }
+    expected := []model.UploadChunk{{Index: 0, Count: 4, Offset: 0, Size: int64(len(data))}}
+    if upload.Chunks != 4 || !reflect.DeepEqual(upload.Missing, expected) {
+        t.Fatalf("Expected 4 missing chunks, got %+v", upload)
+    }
+
+    // Send the last chunk first, as a client resuming a broken transfer might
+    for _, index := range []int{3, 0} {
+        if err := uploader.WriteChunk(upload.ID, index, checksum(chunk(index)), bytes.NewReader(chunk(index))); err != nil {
+            t.Errorf("WriteChunk failed: %v", err)
+        }
+    }
+
+    upload, err = uploader.GetUpload(upload.ID)
+    if err != nil {
+        t.Fatalf("GetUpload failed: %v", err)
+    }
+    expected = []model.UploadChunk{{Index: 1, Count: 2, Offset: MinChunkSize, Size: 2 * MinChunkSize}}
+    if !reflect.DeepEqual(upload.Missing, expected) {
+        t.Errorf("Expected chunks 1 and 2 to be missing, got %+v", upload.Missing)
+    }
+
+    if _, err := uploader.OpenUpload(upload.ID); !errors.Is(err, ErrUploadIncomplete) {
+        t.Errorf("Expected ErrUploadIncomplete, got %v", err)
+    }
+
+    for _, index := range []int{1, 2} {
+        if err := uploader.WriteChunk(upload.ID, index, checksum(chunk(index)), bytes.NewReader(chunk(index))); err != nil {
+            t.Errorf("WriteChunk failed: %v", err)
+        }
+    }
+
+    file, err := uploader.OpenUpload(upload.ID)
+    if err != nil {
+        t.Fatalf("OpenUpload failed: %v", err)
+    }
+    assembled, _ := io.ReadAll(file)
+    file.Close()
+    if !bytes.Equal(assembled, data) {
+        t.Errorf("Assembled upload doesn't match: expected %q, got %q", data, assembled)
+    }
+
+    if err := uploader.DeleteUpload(upload.ID); err != nil {
+        t.Errorf("DeleteUpload failed: %v", err)
+    }
+    if _, err := uploader.GetUpload(upload.ID); !errors.Is(err, ErrUploadNotFound) {
+        t.Errorf("Expected ErrUploadNotFound, got %v", err)
+    }
+}
+
+func TestDicomUploader_WriteChunk_Error(t *testing.T) {
+    uploader, _ := NewDicomUploader(t.TempDir(), log.Default())
+    upload, _ := uploader.CreateUpload(2*MinChunkSize, MinChunkSize)
+
+    chunk := bytes.Repeat([]byte("0123456789"), MinChunkSize/10+1)[:MinChunkSize]
+    if err := uploader.WriteChunk(upload.ID, 0, checksum([]byte("something else")), bytes.NewReader(chunk)); !errors.Is(err, ErrChecksumMismatch) {
+        t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+    }
+    if err := uploader.WriteChunk(upload.ID, 0, checksum(chunk[:5]), bytes.NewReader(chunk[:5])); !errors.Is(err, ErrInvalidChunk) {
+        t.Errorf("Expected ErrInvalidChunk for a short chunk, got %v", err)
+    }
+    if err := uploader.WriteChunk(upload.ID, 2, checksum(chunk), bytes.NewReader(chunk)); !errors.Is(err, ErrInvalidChunk) {
+        t.Errorf("Expected ErrInvalidChunk for an out of range index, got %v", err)
+    }
+    if err := uploader.WriteChunk("unknown", 0, checksum(chunk), bytes.NewReader(chunk)); !errors.Is(err, ErrUploadNotFound) {
+        t.Errorf("Expected ErrUploadNotFound, got %v", err)
+    }
+
+    upload, _ = uploader.GetUpload(upload.ID)
+    if len(upload.Missing) != 1 || upload.Missing[0].Count != 2 {
+        t.Errorf("Expected rejected chunks not to be stored, got %+v", upload.Missing)
+    }
+}
+
+func TestDicomUploader_CreateUpload_Limits(t *testing.T) {
+    uploader, _ := NewDicomUploader(t.TempDir(), log.Default())
+
+    tests := []struct {
+        size      int64
+        chunkSize int64
+        valid     bool
+    }{
+        {size: 10, chunkSize: 10, valid: true},
+        {size: 10, chunkSize: 0, valid: true},
+        {size: 0, chunkSize: 10},
+        {size: 20, chunkSize: 10},
+        {size: 2 * MinChunkSize, chunkSize: -1},
+        {size: MaxChunks * MinChunkSize, chunkSize: MinChunkSize, valid: true},
+        {size: MaxChunks*MinChunkSize + 1, chunkSize: MinChunkSize},
+        {size: MaxUploadSize + 1, chunkSize: 0},
+        // A terabyte sent byte by byte
+        {size: 1 << 40, chunkSize: 1},
+    }
+    for _, test := range tests {
+        _, err := uploader.CreateUpload(test.size, test.chunkSize)
+        if test.valid && err != nil {
+            t.Errorf("Expected an upload of %d bytes in chunks of %d, got %v", test.size, test.chunkSize, err)
+        }
+        if !test.valid && !errors.Is(err, ErrInvalidUpload) {
+            t.Errorf("Expected ErrInvalidUpload for %d bytes in chunks of %d, got %v", test.size, test.chunkSize, err)
+        }
+    }
+}
+
+func TestDicomUploader_Expiry(t *testing.T) {
+    dir := t.TempDir()
+    uploader, _ := NewDicomUploader(dir, log.Default())
+
+    stale, _ := uploader.CreateUpload(10, 0)
+    old := time.Now().Add(-2 * DefaultExpiry)
+    if err := os.Chtimes(filepath.Join(dir, stale.ID), old, old); err != nil {
+        t.Fatalf("Chtimes failed: %v", err)
+    }
+
+    // Creating another upload removes the ones that received nothing for too long
+    fresh, _ := uploader.CreateUpload(10, 0)
+    if _, err := uploader.GetUpload(stale.ID); !errors.Is(err, ErrUploadNotFound) {
+        t.Errorf("Expected the stale upload to be removed, got %v", err)
+    }
+    if _, err := uploader.GetUpload(fresh.ID); err != nil {
+        t.Errorf("Expected the fresh upload to be kept, got %v", err)
+    }
+}
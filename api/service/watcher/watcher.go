@@ -0,0 +1,231 @@
+package watcher
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+)
+
+const (
+    // ProcessedDir is the folder of a drop directory that ingested files are moved to
+    ProcessedDir = "processed"
+    // FailedDir is the folder of a drop directory that files which could not be ingested are moved to,
+    // each next to a .error file with the reason
+    FailedDir = "failed"
+)
+
+type Watcher interface {
+    Start()
+    Stop()
+    Poll()
+}
+
+// DicomWatcher polls drop directories and ingests every file written to them once its size has stopped
+// changing between two polls, then moves it out of the way into the processed or failed folder. Files
+// that cannot be moved are left alone until they change
+type DicomWatcher struct {
+    ingester *ingester.DicomIngester
+    dirs     []string
+    interval time.Duration
+    pending  map[string]fileState
+    handled  map[string]fileState
+    stop     chan struct{}
+    done     chan struct{}
+    once     sync.Once
+    logger   *log.Logger
+}
+
+// fileState is what a file looked like at the previous poll
+type fileState struct {
+    size    int64
+    modTime time.Time
+}
+
+func NewDicomWatcher(dicomIngester *ingester.DicomIngester, dirs []string, interval time.Duration, logger *log.Logger) (*DicomWatcher, error) {
+    if len(dirs) == 0 {
+        return nil, errors.New("no directory to watch")
+    }
+    if interval <= 0 {
+        return nil, fmt.Errorf("invalid poll interval %v", interval)
+    }
+
+    cleaned := make([]string, len(dirs))
+    for i, dir := range dirs {
+        cleaned[i] = filepath.Clean(dir)
+        for _, folder := range []string{ProcessedDir, FailedDir} {
+            if err := os.MkdirAll(filepath.Join(dir, folder), 0755); err != nil {
+                logger.Printf("Error creating watch directory: %v", err)
+                return nil, err
+            }
+        }
+    }
+
+    return &DicomWatcher{
+        ingester: dicomIngester,
+        dirs:     cleaned,
+        interval: interval,
+        pending:  map[string]fileState{},
+        handled:  map[string]fileState{},
+        stop:     make(chan struct{}),
+        done:     make(chan struct{}),
+        logger:   logger,
+    }, nil
+}
+
+// Start polls the drop directories in the background until Stop is called
+func (w *DicomWatcher) Start() {
+    go func() {
+        defer close(w.done)
+
+        ticker := time.NewTicker(w.interval)
+        defer ticker.Stop()
+
+        for {
+            w.Poll()
+
+            select {
+            case <-ticker.C:
+            case <-w.stop:
+                return
+            }
+        }
+    }()
+
+    w.logger.Printf("Watching %s every %v", strings.Join(w.dirs, ", "), w.interval)
+}
+
+// Stop waits for the poll in progress to finish and stops polling
+func (w *DicomWatcher) Stop() {
+    w.once.Do(func() {
+        close(w.stop)
+        <-w.done
+    })
+}
+
+// Poll looks at every drop directory once. Files seen with the same size and modification time as at
+// the previous poll are ingested, every other file is remembered for the next one
+func (w *DicomWatcher) Poll() {
+    seen := map[string]fileState{}
+    handled := map[string]fileState{}
+
+    for _, dir := range w.dirs {
+        err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+            if err != nil {
+                w.logger.Printf("Error reading watch directory: %v", err)
+                return nil
+            }
+
+            if entry.IsDir() {
+                if filepath.Dir(path) == dir && (entry.Name() == ProcessedDir || entry.Name() == FailedDir) {
+                    return filepath.SkipDir
+                }
+                return nil
+            }
+            // Hidden files are usually temporary files of a transfer still in progress
+            if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+                return nil
+            }
+
+            info, err := entry.Info()
+            if err != nil {
+                return nil
+            }
+            state := fileState{size: info.Size(), modTime: info.ModTime()}
+
+            // Ingested before but could not be moved, e.g. on a read-only share
+            if previous, ok := w.handled[path]; ok && previous == state {
+                handled[path] = state
+                return nil
+            }
+            if previous, ok := w.pending[path]; !ok || previous != state {
+                seen[path] = state
+                return nil
+            }
+
+            if !w.ingest(dir, path) {
+                handled[path] = state
+            }
+            return nil
+        })
+        if err != nil {
+            w.logger.Printf("Error walking watch directory: %v", err)
+        }
+    }
+
+    w.pending = seen
+    w.handled = handled
+}
+
+// ingest runs a stable file through the pipeline and moves it to the folder matching the outcome. It
+// returns whether the file was moved
+func (w *DicomWatcher) ingest(dir string, path string) bool {
+    err := w.ingestFile(path)
+    if err == nil {
+        return w.move(dir, path, ProcessedDir) != ""
+    }
+
+    w.logger.Printf("Error ingesting watched file %s: %v", path, err)
+    target := w.move(dir, path, FailedDir)
+    if target == "" {
+        return false
+    }
+    if writeErr := os.WriteFile(target+".error", []byte(err.Error()+"\n"), 0644); writeErr != nil {
+        w.logger.Printf("Error writing failure reason: %v", writeErr)
+    }
+    return true
+}
+
+func (w *DicomWatcher) ingestFile(path string) error {
+    isDicom, err := parser.IsDicomFile(path)
+    if err != nil {
+        return err
+    }
+    if !isDicom {
+        return errors.New("not a DICOM file")
+    }
+
+    uuid, err := w.ingester.IngestPath(path, nil)
+    if err != nil {
+        return err
+    }
+
+    w.logger.Printf("Ingested watched file %s as %s", path, uuid)
+    return nil
+}
+
+// move puts the file below the folder of its drop directory, keeping its relative path and never
+// overwriting an earlier file of the same name. It returns where the file went
+func (w *DicomWatcher) move(dir string, path string, folder string) string {
+    rel, err := filepath.Rel(dir, path)
+    if err != nil {
+        rel = filepath.Base(path)
+    }
+
+    target := filepath.Join(dir, folder, rel)
+    if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+        w.logger.Printf("Error creating %s directory: %v", folder, err)
+        return ""
+    }
+    for n := 1; ; n++ {
+        if _, err := os.Lstat(target); errors.Is(err, os.ErrNotExist) {
+            break
+        }
+        target = filepath.Join(dir, folder, fmt.Sprintf("%s.%d", rel, n))
+    }
+
+    if err := os.Rename(path, target); err != nil {
+        w.logger.Printf("Error moving watched file to %s: %v", folder, err)
+        return ""
+    }
+
+    return target
+}
@@ -0,0 +1,147 @@
+package watcher
+
+import (
+    "bytes"
+    "image"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+
+    "dicom/api/model"
+    "dicom/api/repository/blob"
+    "dicom/api/repository/sql"
+    "dicom/api/service/ingester"
+    "dicom/api/service/parser"
+    "dicom/api/service/processor"
+)
+
+func newTestWatcher(t *testing.T, dir string) *DicomWatcher {
+    mockSQLRepo := &sql.MockRepository{
+        GetDicomByUUIDFunc: func(uuid string) (*model.Dicom, error) {
+            return &model.Dicom{ID: 1, ImageURL: "output/image_mock_uuid.png"}, nil
+        },
+    }
+    mockBlobRepo := &blob.MockRepository{
+        WritePngToFileFunc: func(image.Image, string) error {
+            return nil
+        },
+    }
+    dicomIngester := ingester.NewDicomIngester(
+        parser.NewDicomParser(mockSQLRepo, &blob.MockRepository{}, log.Default()),
+        processor.NewDicomProcessor(mockSQLRepo, mockBlobRepo, log.Default()),
+        log.Default(),
+    )
+
+    watcher, err := NewDicomWatcher(dicomIngester, []string{dir}, time.Hour, log.Default())
+    if err != nil {
+        t.Fatalf("Unexpected error: %v", err)
+    }
+    return watcher
+}
+
+func exists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+func TestDicomWatcher_Poll(t *testing.T) {
+    dir := t.TempDir()
+    watcher := newTestWatcher(t, dir)
+
+    data, err := os.ReadFile("../parser/test_file.dcm")
+    if err != nil {
+        t.Fatalf("Failed to read test file: %v", err)
+    }
+    os.MkdirAll(filepath.Join(dir, "SE000001"), 0755)
+    os.WriteFile(filepath.Join(dir, "SE000001", "IM000001"), data, 0644)
+    os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a dicom file"), 0644)
+    os.WriteFile(filepath.Join(dir, ".partial"), data, 0644)
+
+    // Files are left alone until they have been seen unchanged
+    watcher.Poll()
+    if !exists(filepath.Join(dir, "SE000001", "IM000001")) {
+        t.Fatal("Expected a new file to wait for the next poll")
+    }
+
+    watcher.Poll()
+    if !exists(filepath.Join(dir, ProcessedDir, "SE000001", "IM000001")) {
+        t.Error("Expected the DICOM file to be moved to the processed folder")
+    }
+    if !exists(filepath.Join(dir, FailedDir, "notes.txt")) || !exists(filepath.Join(dir, FailedDir, "notes.txt.error")) {
+        t.Error("Expected the other file to be moved to the failed folder with its reason")
+    }
+    if !exists(filepath.Join(dir, ".partial")) {
+        t.Error("Expected hidden files to be ignored")
+    }
+
+    // A file of the same name does not overwrite the one processed before
+    os.WriteFile(filepath.Join(dir, "SE000001", "IM000001"), data, 0644)
+    watcher.Poll()
+    watcher.Poll()
+    if !exists(filepath.Join(dir, ProcessedDir, "SE000001", "IM000001.1")) {
+        t.Error("Expected the second file to be processed under a new name")
+    }
+}
+
+func TestDicomWatcher_Poll_Growing(t *testing.T) {
+    dir := t.TempDir()
+    watcher := newTestWatcher(t, dir)
+
+    path := filepath.Join(dir, "IM000001")
+    os.WriteFile(path, []byte("DICM"), 0644)
+    watcher.Poll()
+
+    // The file is still being written
+    os.WriteFile(path, []byte("DICM and more"), 0644)
+    watcher.Poll()
+    if !exists(path) {
+        t.Error("Expected a file whose size changed to wait for the next poll")
+    }
+}
+
+func TestDicomWatcher_Poll_Unmovable(t *testing.T) {
+    dir := t.TempDir()
+    watcher := newTestWatcher(t, dir)
+    var logs bytes.Buffer
+    watcher.logger = log.New(&logs, "", 0)
+
+    // The failed folder cannot be written to
+    os.Remove(filepath.Join(dir, FailedDir))
+    os.WriteFile(filepath.Join(dir, FailedDir), nil, 0644)
+
+    path := filepath.Join(dir, "notes.txt")
+    os.WriteFile(path, []byte("not a dicom file"), 0644)
+    for i := 0; i < 4; i++ {
+        watcher.Poll()
+    }
+    if count := strings.Count(logs.String(), "Error ingesting watched file "+path); count != 1 {
+        t.Errorf("Expected a file that could not be moved to be ingested once, got %d times", count)
+    }
+
+    // It is ingested again once it changes
+    os.WriteFile(path, []byte("still not a dicom file"), 0644)
+    watcher.Poll()
+    watcher.Poll()
+    if count := strings.Count(logs.String(), "Error ingesting watched file "+path); count != 2 {
+        t.Errorf("Expected the changed file to be ingested again, got %d times", count)
+    }
+}
+
+func TestDicomWatcher_StartStop(t *testing.T) {
+    watcher := newTestWatcher(t, t.TempDir())
+    watcher.Start()
+    watcher.Stop()
+    watcher.Stop()
+}
+
+func TestNewDicomWatcher_Error(t *testing.T) {
+    if _, err := NewDicomWatcher(nil, nil, time.Second, log.Default()); err == nil {
+        t.Error("Expected error without directories, got nil")
+    }
+    if _, err := NewDicomWatcher(nil, []string{t.TempDir()}, 0, log.Default()); err == nil {
+        t.Error("Expected error for an invalid interval, got nil")
+    }
+}
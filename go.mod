@@ -2,12 +2,12 @@ module dicom
 
 go 1.20
 
-require github.com/suyashkumar/dicom v1.0.7
-
 require (
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/lithammer/shortuuid v3.0.0+incompatible // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-	golang.org/x/text v0.3.8 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.9.0
+	github.com/lithammer/shortuuid v3.0.0+incompatible
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/suyashkumar/dicom v1.0.7
+	golang.org/x/text v0.3.8
 )